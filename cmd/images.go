@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/Webstrates/golem-herder/container"
+	"github.com/Webstrates/golem-herder/golem"
+	units "github.com/docker/go-units"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// herderImages returns the images used by the herder: the images of the golem
+// profiles, the images of the lambda envs ("lambda-envs") and any extra images ("images").
+func herderImages() []string {
+	images := golem.Images()
+	envs := viper.GetStringSlice("lambda-envs")
+	if !viper.IsSet("lambda-envs") {
+		envs = []string{"ruby", "python", "latex", "node"}
+	}
+	for _, env := range envs {
+		images = append(images, container.ImageName(fmt.Sprintf("webstrates/%s", env), "latest"))
+	}
+	return append(images, viper.GetStringSlice("images")...)
+}
+
+// imagesCmd represents the images command
+var imagesCmd = &cobra.Command{
+	Use:   "images",
+	Short: "Manage the images used by the herder",
+}
+
+var imagesListCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the images used by the herder",
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := container.GetRuntime()
+		if err != nil {
+			panic(err)
+		}
+
+		infos, err := container.ListImages(client, herderImages())
+		if err != nil {
+			panic(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "IMAGE\tPRESENT\tID\tSIZE\tPOLICY")
+		for _, info := range infos {
+			size := ""
+			if info.Present {
+				size = units.HumanSize(float64(info.Size))
+			}
+			id := info.ID
+			if len(id) > 19 {
+				id = id[:19]
+			}
+			fmt.Fprintf(w, "%s\t%v\t%s\t%s\t%s\n", info.Name, info.Present, id, size, info.Policy)
+		}
+		w.Flush()
+	},
+}
+
+var imagesPrefetchCmd = &cobra.Command{
+	Use:   "prefetch",
+	Short: "Pull the images used by the herder",
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := container.GetRuntime()
+		if err != nil {
+			panic(err)
+		}
+
+		failed := false
+		for _, image := range herderImages() {
+			repository, tag := container.SplitImageName(image)
+			if err := container.Pull(client, repository, tag); err != nil {
+				fmt.Printf("Could not pull %s - %v\n", image, err)
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(-1)
+		}
+	},
+}
+
+var imagesPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove old versions of the images used by the herder",
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := container.GetRuntime()
+		if err != nil {
+			panic(err)
+		}
+
+		removed, err := container.PruneImages(client, herderImages())
+		if err != nil {
+			panic(err)
+		}
+		for _, id := range removed {
+			fmt.Println(id)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(imagesCmd)
+	imagesCmd.AddCommand(imagesListCmd)
+	imagesCmd.AddCommand(imagesPrefetchCmd)
+	imagesCmd.AddCommand(imagesPruneCmd)
+}
@@ -6,7 +6,9 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/Webstrates/golem-herder/container"
 	"github.com/Webstrates/golem-herder/daemon"
+	"github.com/Webstrates/golem-herder/golem"
 	"github.com/Webstrates/golem-herder/herder"
 	"github.com/Webstrates/golem-herder/minion"
 	"github.com/Webstrates/golem-herder/token"
@@ -35,6 +37,34 @@ var serveCmd = &cobra.Command{
 			panic(err)
 		}
 
+		if _, err := container.ParsePullPolicy(viper.GetString("pull-policy")); err != nil {
+			panic(err)
+		}
+
+		if _, err := container.Ports(); err != nil {
+			panic(err)
+		}
+
+		// Keep images fresh as they are only pulled when missing (unless pull policy is always)
+		if refresh := viper.GetDuration("image-refresh"); refresh > 0 {
+			go container.RefreshImages(refresh, herderImages)
+		}
+
+		// Pick up the daemons and golems left behind by a previous herder
+		herder.Reconcile(viper.GetString("reconcile-golems"))
+
+		// Keep the console output of golems for debugging
+		golem.CaptureLogs()
+
+		// Run the golem code in golems once their webstrate has loaded
+		golem.Bootstrap()
+
+		// Respawn golems which die or become unhealthy
+		golem.Supervise()
+
+		// Kill golems which have been idle for too long
+		golem.Reap(time.Minute)
+
 		r := mux.NewRouter()
 
 		gv1 := r.PathPrefix("/golem/v1").Subrouter()
@@ -42,17 +72,26 @@ var serveCmd = &cobra.Command{
 		// TODO move these handlers to golem
 		gv1.HandleFunc("/", herder.HomeHandler)
 		gv1.HandleFunc("/ls", herder.ListHandler)
-		gv1.HandleFunc("/spawn/{webstrate}", herder.SpawnHandler)
-		gv1.HandleFunc("/reset/{webstrate}", herder.ResetHandler)
-		gv1.HandleFunc("/kill/{webstrate}", herder.KillHandler)
-
-		// bah, does not work due to absolute urls in html page
-		//proxyPrefix := "/proxy"
-		//proxy := golem.NewGolemReverseProxy(proxyPrefix, golem.PortOf)
-		//gv1.PathPrefix(proxyPrefix).Handler(proxy)
-
-		// Connect a golem. Golem will get status info and connect information on this socket.
-		gv1.HandleFunc("/connect/{webstrate}", minion.GolemConnectHandler)
+		gv1.HandleFunc("/spawn/{webstrate}", herder.SpawnHandler(m)).Methods("POST")
+		gv1.HandleFunc("/ticket/{webstrate}", token.ValidatedHandler(m, herder.TicketHandler))
+		gv1.HandleFunc("/reset/{webstrate}", token.ValidatedHandler(m, herder.ResetHandler)).Methods("POST")
+		gv1.HandleFunc("/kill/{webstrate}", token.ValidatedHandler(m, herder.KillHandler)).Methods("POST")
+		gv1.HandleFunc("/status/{webstrate}", token.ValidatedHandler(m, golem.StatusHandler))
+
+		// Control the page shown by a golem
+		gv1.HandleFunc("/eval/{webstrate}", token.ValidatedHandler(m, golem.EvalHandler)).Methods("POST")
+		gv1.HandleFunc("/reload/{webstrate}", token.ValidatedHandler(m, golem.ReloadHandler)).Methods("POST")
+		gv1.HandleFunc("/navigate/{webstrate}", token.ValidatedHandler(m, golem.NavigateHandler)).Methods("POST")
+		gv1.HandleFunc("/location/{webstrate}", token.ValidatedHandler(m, golem.LocationHandler))
+		gv1.HandleFunc("/screenshot/{webstrate}", token.ValidatedHandler(m, golem.ScreenshotHandler))
+		gv1.HandleFunc("/pdf/{webstrate}", token.ValidatedHandler(m, golem.PDFHandler))
+		gv1.HandleFunc("/logs/{webstrate}", token.ValidatedHandler(m, golem.LogsHandler))
+
+		// The developer tools of golems are reached on <webstrate>.golem.<url> (see herder.HostRouter)
+		// as path prefixed proxying does not work due to absolute urls in html page
+
+		// Connect a golem. Golem will get status info and connect information on this socket and can make requests to the herder.
+		gv1.HandleFunc("/connect/{webstrate}", minion.GolemConnectHandler(m))
 
 		// Connect a golem and a specific minion
 		gv1.HandleFunc("/connect-to/{webstrate}/{minion}", minion.GolemMinionConnectHandler)
@@ -67,14 +106,29 @@ var serveCmd = &cobra.Command{
 		dv1.HandleFunc("/spawn", token.ValidatedHandler(m, daemon.SpawnHandler)).Methods("POST")
 		dv1.HandleFunc("/ls", token.ValidatedHandler(m, daemon.ListHandler))
 		dv1.HandleFunc("/kill/{name}", token.ValidatedHandler(m, daemon.KillHandler))
+		dv1.HandleFunc("/status/{name}", token.ValidatedHandler(m, daemon.StatusHandler))
+		dv1.HandleFunc("/stop/{name}", token.ValidatedHandler(m, daemon.StopHandler)).Methods("POST")
+		dv1.HandleFunc("/start/{name}", token.ValidatedHandler(m, daemon.StartHandler)).Methods("POST")
+		dv1.HandleFunc("/restart/{name}", token.ValidatedHandler(m, daemon.RestartHandler)).Methods("POST")
 		dv1.HandleFunc("/attach/{name}", token.ValidatedHandler(m, daemon.AttachHandler))
-		dv1.HandleFunc("/proxy/{name}", daemon.ProxyHandler)
+		dv1.HandleFunc("/logs/{name}", token.ValidatedHandler(m, daemon.LogsHandler))
+		dv1.HandleFunc("/exec/{name}", token.ValidatedHandler(m, daemon.ExecHandler))
+		dv1.HandleFunc("/files/{name}", token.ValidatedHandler(m, daemon.FilesHandler)).Methods("GET", "PUT", "POST", "DELETE")
+		dv1.HandleFunc("/files/{name}/{path:.*}", token.ValidatedHandler(m, daemon.FilesHandler)).Methods("GET", "PUT", "POST", "DELETE")
+		dv1.HandleFunc("/share/{name}", token.ValidatedHandler(m, daemon.ShareHandler))
+		dv1.PathPrefix("/proxy/{name}").HandlerFunc(daemon.ProxyHandler(m))
+		// Administration
+		av1 := r.PathPrefix("/admin/v1").Subrouter()
+		av1.HandleFunc("/reconcile", herder.AdminHandler(tokenPassword, herder.ReconcileHandler))
+		av1.HandleFunc("/golems", herder.AdminHandler(tokenPassword, herder.GolemPolicyHandler))
+		av1.HandleFunc("/golems/pin/{webstrate}", herder.AdminHandler(tokenPassword, herder.PinHandler)).Methods("POST", "DELETE")
+
 		// Tokens
 		r.HandleFunc("/token/v1/generate", token.GenerateHandler(m, tokenPassword))
 		r.HandleFunc("/token/v1/inspect/{token}", token.InspectHandler(m))
 
 		srv := &http.Server{
-			Handler:   handlers.CORS()(r),
+			Handler:   handlers.CORS()(herder.HostRouter(m, r)),
 			Addr:      fmt.Sprintf(":%v", port),
 			TLSConfig: &tls.Config{},
 			// Good practice: enforce timeouts for servers you create!
@@ -95,7 +149,35 @@ func init() {
 	serveCmd.Flags().Bool("proxy", false, "Whether to connect to a proxy. If you set this flag you should name the container 'webstrates' or whatever string you pass in the 'webstrates' flag")
 	serveCmd.Flags().String("url", "emet.cc.au.dk", "The url which this herder can be accessed at. This url should be reachable from the containers/golems running on this machine or - if using the proxy - the proxy")
 	serveCmd.Flags().String("webstrates", "webstrates", "The location of the webstrates server - if using the proxy this should be left to the default value (webstrates)")
-	serveCmd.Flags().String("golem", "latest", "The version (tag) of the golem image (https://hub.docker.com/r/webstrates/golem/tags/) to use.")
+	serveCmd.Flags().String("golem", "latest", "The version (tag) of the golem image (https://hub.docker.com/r/webstrates/golem/tags/) to use - unless given by the golem profile.")
+	serveCmd.Flags().String("pull-policy", "if-not-present", "When to pull images before creating containers: always, if-not-present or never. Can be set pr. image in the config under 'pull-policies'.")
+	serveCmd.Flags().Duration("image-refresh", time.Hour, "How often to re-pull the images used by the herder. Set to 0 to disable.")
+	serveCmd.Flags().String("reconcile-golems", "keep", "What to do with golems left running by a previous herder: keep, restart or kill.")
+	serveCmd.Flags().String("port-range", "40000-49999", "The range (min-max) of host ports given to golems and daemons.")
+	serveCmd.Flags().String("ports-db", "ports.db", "The file in which host port reservations are kept between restarts.")
+	serveCmd.Flags().Int("golem-log-lines", 1000, "The amount of console messages and exceptions kept for each golem.")
+	serveCmd.Flags().String("golem-bootstrap-selector", ".golem,#emet", "The query-selector of the element in a webstrate containing the code golems are bootstrapped with.")
+	serveCmd.Flags().Duration("golem-bootstrap-timeout", time.Minute, "How long the herder waits for a webstrate to load and the golem code to run when bootstrapping a golem.")
+	serveCmd.Flags().Int("golem-bootstrap-retries", 2, "How many times the page of a golem is reloaded to retry a failed bootstrap.")
+	serveCmd.Flags().Duration("golem-health-interval", 30*time.Second, "How often the health of golems is checked. Set to 0 to only respawn golems which die.")
+	serveCmd.Flags().Int("golem-health-failures", 3, "How many health checks of a golem must fail in a row before it is respawned.")
+	serveCmd.Flags().Duration("golem-restart-backoff", 5*time.Second, "How long to wait before respawning a golem. The wait is doubled for each recent respawn (up to 5 minutes).")
+	serveCmd.Flags().Int("golem-max-restarts", 5, "How many times a golem may be respawned within golem-restart-window before it is considered crash-looping and left dead.")
+	serveCmd.Flags().Duration("golem-restart-window", 10*time.Minute, "The window in which respawns of a golem count towards golem-max-restarts.")
+	serveCmd.Flags().Duration("golem-idle-timeout", 0, "How long a golem may go without minion or control websocket activity before it is killed. Golems are never killed for being idle if 0.")
+	serveCmd.Flags().Int("golem-max", 0, "The max number of concurrent golems. The least recently used golems are killed to make room for new ones. No limit if 0.")
+	serveCmd.Flags().StringSlice("golem-pinned", []string{}, "Webstrates whose golems are never killed for being idle or to make room for other golems.")
+	serveCmd.Flags().StringSlice("golem-allow", []string{}, "Patterns (e.g. 'demo-*') of the webstrates golems may be spawned on. Golems may be spawned on all webstrates if none are given.")
+	serveCmd.Flags().StringSlice("golem-deny", []string{}, "Patterns of the webstrates golems may not be spawned on.")
+	serveCmd.Flags().Bool("golem-spawn-tickets", false, "Whether spawning a golem requires a ticket (see /golem/v1/ticket) or a token for the webstrate.")
+	serveCmd.Flags().String("golem-secret", "", "The key used to sign golem spawn tickets. A random key is used if empty.")
+	serveCmd.Flags().String("golem-resources", "", "The resource class (defined in the config under 'resource-classes') to use for golems. No limits if empty.")
+	serveCmd.Flags().String("daemon-resources", "", "The default resource class for daemons. No limits if empty.")
+	serveCmd.Flags().String("daemon-max-resources", "", "The resource class capping the resources a daemon may request. No cap if empty.")
+	serveCmd.Flags().String("daemon-disk-quota", "", "The disk space (e.g. 1g) the daemons of a subject may use in their data directories through the files api. No quota if empty.")
+	serveCmd.Flags().Int("daemon-log-lines", 1000, "The amount of log lines kept for daemons which are no longer running.")
+	serveCmd.Flags().Int("daemon-log-histories", 1000, "The amount of daemons which are no longer running whose logs are kept. The logs of wiped daemons are never kept.")
+	serveCmd.Flags().String("proxy-secret", "", "The key used to sign links to shared daemons. A random key is used if empty (links are then invalidated when the herder restarts).")
 	serveCmd.Flags().StringVarP(&tokenPassword, "token-password", "k", "", "Password required to generate tokens.")
 
 	if err := viper.BindPFlags(serveCmd.Flags()); err != nil {
@@ -4,13 +4,13 @@ import (
 	"fmt"
 
 	"github.com/Webstrates/golem-herder/token"
-	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/spf13/cobra"
 )
 
 var (
-	credits int
-	email   string
+	credits    int
+	email      string
+	webstrates []string
 )
 
 // serveCmd represents the serve command
@@ -25,7 +25,7 @@ var tokenCmd = &cobra.Command{
 			panic(err)
 		}
 
-		token, err := m.Generate(email, jwt.MapClaims{"crd": credits})
+		token, err := m.Generate(email, token.Claims(credits, webstrates))
 		if err != nil {
 			panic(err)
 		}
@@ -40,4 +40,5 @@ func init() {
 
 	tokenCmd.Flags().IntVarP(&credits, "crd", "c", 3e4, "How many credits do you want in your token?")
 	tokenCmd.Flags().StringVarP(&email, "email", "e", "", "What is your email?")
+	tokenCmd.Flags().StringSliceVarP(&webstrates, "webstrate", "w", []string{}, "Patterns (e.g. 'my-*') of the webstrates whose golems the token can reset and kill.")
 }
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -14,7 +13,6 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/spf13/viper"
 
@@ -22,29 +20,14 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-// GetAvailableHostPort returns an available (and random) port on the host machine
-func GetAvailableHostPort() int {
-	addr, err := net.ResolveTCPAddr("tcp", "localhost:0")
-	if err != nil {
-		panic(err)
-	}
-
-	l, err := net.ListenTCP("tcp", addr)
-	if err != nil {
-		panic(err)
-	}
-	defer l.Close()
-	return l.Addr().(*net.TCPAddr).Port
-}
-
 // List containers matching the given predicate.
-func List(client *docker.Client, matches func(container *docker.APIContainers) bool, all bool) ([]docker.APIContainers, error) {
+// If client is nil the current runtime is used.
+func List(client Runtime, matches func(container *docker.APIContainers) bool, all bool) ([]docker.APIContainers, error) {
 
-	// Create client if it is not given
+	// Use current runtime if it is not given
 	if client == nil {
-		c, err := docker.NewClientFromEnv()
+		c, err := GetRuntime()
 		if err != nil {
-			log.WithError(err).Error("Could not create docker client")
 			return nil, err
 		}
 		client = c
@@ -108,14 +91,54 @@ func WithLabel(label, value string) func(container *docker.APIContainers) bool {
 	}
 }
 
+// HasLabel returns a func to match containers which have the given label regardless of its value (for use with e.g. List)
+func HasLabel(label string) func(container *docker.APIContainers) bool {
+	return func(container *docker.APIContainers) bool {
+		_, ok := container.Labels[label]
+		return ok
+	}
+}
+
 // WithState returns a func to match a container's state (for use with e.g. List)
 func WithState(state string) func(container *docker.APIContainers) bool {
 	return func(container *docker.APIContainers) bool {
-		fmt.Println(container.State)
 		return container.State == state
 	}
 }
 
+// SafeJoin joins the relative path p to dir making sure the result is within dir - also when following symlinks.
+func SafeJoin(dir, p string) (string, error) {
+	joined := filepath.Join(dir, filepath.Clean("/"+p))
+
+	// Resolve the deepest existing part of the path as it may be a symlink out of dir
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	existing := joined
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			if resolved != root && !strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+				return "", fmt.Errorf("Path %s is outside of the directory", p)
+			}
+			return joined, nil
+		}
+		if !os.IsNotExist(err) || existing == dir {
+			return "", err
+		}
+		if target, err := os.Readlink(existing); err == nil {
+			// A dangling symlink - where it would lead must be within dir too
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(existing), target)
+			}
+			existing = target
+			continue
+		}
+		existing = filepath.Dir(existing)
+	}
+}
+
 // LoadFiles will load the given files into the dir for container usage
 func LoadFiles(dir string, files map[string][]byte) error {
 
@@ -123,6 +146,11 @@ func LoadFiles(dir string, files map[string][]byte) error {
 
 	// write stuff to tmp dir
 	for name, content := range files {
+		file, err := SafeJoin(dir, name)
+		if err != nil {
+			log.WithError(err).WithField("file", name).Warn("Refusing to write file")
+			return err
+		}
 		// check if content is something we need to fetch
 		if url, err := url.Parse(string(content)); err == nil && strings.HasPrefix(string(content), "http") {
 			wg.Add(1)
@@ -131,23 +159,31 @@ func LoadFiles(dir string, files map[string][]byte) error {
 				request, err := http.NewRequest("GET", url.String(), nil)
 				if err != nil {
 					log.WithError(err).WithField("url", url.String()).Warn("Could not create request")
+					return
 				}
-				// we need to add basic auth for webstrates assets
-				if url.Hostname() == "webstrates.cs.au.dk" || url.Hostname() == "hiraku.cs.au.dk" {
-					request.SetBasicAuth("web", "strate")
+				// authenticate against e.g. access-controlled webstrates servers (see CredentialsFor)
+				credentials, err := CredentialsFor(url.Host)
+				if err != nil {
+					log.WithError(err).WithField("url", url.String()).Warn("Could not get credentials")
 				}
+				credentials.Authorize(request)
 				response, err := http.DefaultClient.Do(request)
 				if err != nil {
 					log.WithError(err).WithField("file", name).WithField("url", url.String()).Warn("Could not GET content to store in container")
+					return
 				}
 				defer response.Body.Close()
+				if response.StatusCode < 200 || response.StatusCode > 299 {
+					log.WithField("file", name).WithField("url", url.String()).WithField("status", response.StatusCode).Warn("Could not GET content to store in container")
+					return
+				}
 				fetchedContent, err := ioutil.ReadAll(response.Body)
 				if err != nil {
 					log.WithError(err).WithField("url", url.String()).Warn("Error getting body")
 				}
 				// write content of url to file
 				log.WithField("file", name).Info("Writing fetched content to tmp dir")
-				err = ioutil.WriteFile(filepath.Join(dir, name), fetchedContent, 0644)
+				err = ioutil.WriteFile(file, fetchedContent, 0644)
 				if err != nil {
 					log.WithError(err).WithField("file", name).Warn("Could not write file to tmp dir")
 				}
@@ -155,7 +191,7 @@ func LoadFiles(dir string, files map[string][]byte) error {
 		} else {
 			// default case, something not an url
 			log.WithField("file", name).Info("Writing provided content to tmp dir")
-			err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644)
+			err := ioutil.WriteFile(file, content, 0644)
 			if err != nil {
 				log.WithError(err).WithField("file", name).Warn("Could not write file to tmp dir")
 				return err
@@ -171,9 +207,8 @@ func LoadFiles(dir string, files map[string][]byte) error {
 // Kill the container with the given name and optionally remove mounted volumes.
 func Kill(matcher func(container *docker.APIContainers) bool, removeContainer, destroyData bool) error {
 
-	client, err := docker.NewClientFromEnv()
+	client, err := GetRuntime()
 	if err != nil {
-		log.WithError(err).Error("Could not create docker client")
 		return err
 	}
 
@@ -210,15 +245,88 @@ func Kill(matcher func(container *docker.APIContainers) bool, removeContainer, d
 	return nil
 }
 
-func run(client *docker.Client, name, repository, tag string, ports map[int]int, mounts map[string]string, labels map[string]string, restart bool) (*docker.Container, error) {
+var (
+	// stopped contains the ids of the containers which were stopped on purpose - they are kept when they die
+	stopped      = map[string]bool{}
+	stoppedMutex = &sync.Mutex{}
+)
+
+// IsStopped tells whether the container with the given id was stopped on purpose (and not started since).
+func IsStopped(id string) bool {
+	stoppedMutex.Lock()
+	defer stoppedMutex.Unlock()
+	return stopped[id]
+}
+
+// Stop the container with the given id - it is killed if it has not stopped after timeout seconds.
+// The container (and its host ports) are kept so it can be started again.
+func Stop(id string, timeout uint) error {
+	client, err := GetRuntime()
+	if err != nil {
+		return err
+	}
+
+	stoppedMutex.Lock()
+	stopped[id] = true
+	stoppedMutex.Unlock()
+
+	log.WithField("container", id).Info("Stopping container")
+	if err := client.StopContainer(id, timeout); err != nil {
+		stoppedMutex.Lock()
+		delete(stopped, id)
+		stoppedMutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Start the stopped container with the given id.
+func Start(id string) error {
+	client, err := GetRuntime()
+	if err != nil {
+		return err
+	}
+
+	log.WithField("container", id).Info("Starting container")
+	if err := client.StartContainer(id, nil); err != nil {
+		return err
+	}
+
+	stoppedMutex.Lock()
+	delete(stopped, id)
+	stoppedMutex.Unlock()
+	return nil
+}
 
-	log.WithFields(log.Fields{"image": fmt.Sprintf("%s:%s", repository, tag)}).Info("Pulling image")
+// Remove the container with the given id which is not running and optionally its volumes.
+func Remove(id string, destroyData bool) error {
+	client, err := GetRuntime()
+	if err != nil {
+		return err
+	}
 
-	err := client.PullImage(docker.PullImageOptions{
-		Repository: repository,
-		Tag:        tag,
-	}, docker.AuthConfiguration{})
+	log.WithField("container", id).Info("Removing container")
+	err = client.RemoveContainer(docker.RemoveContainerOptions{
+		ID:            id,
+		Force:         true,
+		RemoveVolumes: destroyData,
+	})
 	if err != nil {
+		log.WithError(err).Warn("Error removing container")
+		return err
+	}
+
+	stoppedMutex.Lock()
+	delete(stopped, id)
+	stoppedMutex.Unlock()
+	return nil
+}
+
+// run creates and starts the container publishing the given ports (outside port to inside port) on hostIP - on all
+// addresses of the host if hostIP is empty.
+func run(client Runtime, name, repository, tag string, ports map[int]int, hostIP string, mounts map[string]string, labels map[string]string, resources *Resources, restart bool) (*docker.Container, error) {
+
+	if err := EnsureImage(client, repository, tag); err != nil {
 		return nil, err
 	}
 
@@ -236,18 +344,31 @@ func run(client *docker.Client, name, repository, tag string, ports map[int]int,
 	// Construct port bindings
 	exposedPorts := map[docker.Port]struct{}{}
 	portBindings := map[docker.Port][]docker.PortBinding{}
+	outsidePorts := []int{}
+	if hostIP == "" {
+		hostIP = "0.0.0.0"
+	}
 	if ports != nil {
 		for outsidePort, insidePort := range ports {
+			outsidePorts = append(outsidePorts, outsidePort)
 			insidePortTCP := docker.Port(fmt.Sprintf("%d/tcp", insidePort))
 			exposedPorts[insidePortTCP] = struct{}{}
 			portBindings[insidePortTCP] = []docker.PortBinding{{
-				HostIP:   "0.0.0.0",
+				HostIP:   hostIP,
 				HostPort: fmt.Sprintf("%d", outsidePort),
 			},
 			}
 		}
 	}
 
+	hostConfig := &docker.HostConfig{
+		PortBindings: portBindings,
+		Binds:        binds,
+	}
+	if err := resources.Apply(hostConfig); err != nil {
+		return nil, err
+	}
+
 	container, err := client.CreateContainer(
 		docker.CreateContainerOptions{
 			Name: name,
@@ -260,12 +381,10 @@ func run(client *docker.Client, name, repository, tag string, ports map[int]int,
 				AttachStderr: true,
 				AttachStdin:  true,
 				OpenStdin:    true,
-				Tty:          true,
-			},
-			HostConfig: &docker.HostConfig{
-				PortBindings: portBindings,
-				Binds:        binds,
+				// No tty to keep stdout and stderr apart
+				Tty: false,
 			},
+			HostConfig: hostConfig,
 		},
 	)
 	var containerID string
@@ -284,8 +403,19 @@ func run(client *docker.Client, name, repository, tag string, ports map[int]int,
 			return nil, fmt.Errorf("Could not create nor find container with name %s", name)
 		}
 		containerID = containers[0].ID
+		// The found container keeps the ports it was created with
+		ReleasePorts(outsidePorts)
 	} else {
 		containerID = container.ID
+		if err := BindPorts(outsidePorts, containerID); err != nil {
+			log.WithError(err).WithField("containerID", containerID).Error("Could not bind ports to container")
+			// The ports would never be released with the container
+			if err := client.RemoveContainer(docker.RemoveContainerOptions{ID: containerID, Force: true}); err != nil {
+				log.WithError(err).WithField("containerID", containerID).Warn("Could not remove container")
+			}
+			ReleasePorts(outsidePorts)
+			return nil, err
+		}
 	}
 
 	log.WithField("containerID", containerID).Info("Created/found container")
@@ -310,11 +440,12 @@ func run(client *docker.Client, name, repository, tag string, ports map[int]int,
 // RunDaemonized will pull, create and start the container piping stdout and stderr to the given channels.
 // This function is meant to run longlived, persistent processes.
 // A directory (/<name>) will be mounted in the container in which data which must be persisted between sessions can be kept.
-func RunDaemonized(name, repository, tag string, ports map[int]int, files map[string][]byte, labels map[string]string, restart bool, stdout, stderr chan<- []byte, done chan<- bool) (*docker.Container, error) {
+// The ports (outside port to inside port) are published on hostIP - on all addresses of the host if empty.
+// When the container dies its final state is sent on done.
+func RunDaemonized(name, repository, tag string, ports map[int]int, hostIP string, files map[string][]byte, labels map[string]string, resources *Resources, restart bool, stdout, stderr chan<- []byte, done chan<- docker.State) (*docker.Container, error) {
 
-	client, err := docker.NewClientFromEnv()
+	client, err := GetRuntime()
 	if err != nil {
-		log.WithError(err).Error("Could not create docker client")
 		return nil, err
 	}
 
@@ -335,88 +466,134 @@ func RunDaemonized(name, repository, tag string, ports map[int]int, files map[st
 		return nil, err
 	}
 
-	c, err := run(client, name, repository, tag, ports, mounts, labels, restart)
+	// Subscribe before starting the container so its death is not missed
+	var dies *Subscription
+	if done != nil {
+		dies = Subscribe(All(OfContainer(name), OfType(EventDie)))
+	}
+
+	c, err := run(client, name, repository, tag, ports, hostIP, mounts, labels, resources, restart)
 	if err != nil {
+		if dies != nil {
+			dies.Unsubscribe()
+		}
 		return nil, err
 	}
 
 	// Setup monitor for service - if it does done should be notified
 	if done != nil {
-		go func() {
-			// Cleanup container after it exits
-			defer func() {
-				err = client.RemoveContainer(docker.RemoveContainerOptions{
-					ID:            c.ID,
-					Force:         true,
-					RemoveVolumes: false,
-				})
-				if err != nil {
-					log.WithError(err).Warn("Error removing container")
-				}
-			}()
-			for {
-				<-time.After(time.Second)
-				if cntnr, err := client.InspectContainer(c.ID); err != nil || !cntnr.State.Running {
-					log.WithField("name", name).WithField("id", c.ID).Info("Container looks dead")
-					done <- true
-					return
-				}
-			}
-		}()
+		go monitor(client, c.ID, name, dies, done)
 	}
 
 	if stdout == nil || stderr == nil {
 		return c, nil
 	}
 
-	// Use a pipe to run stdout and stderr to channels
-	stdoutr, stdoutw := io.Pipe()
-	stderrr, stderrw := io.Pipe()
-	client.Logs(docker.LogsOptions{
-		Stdout:       true,
-		Container:    c.ID,
-		OutputStream: stdoutw,
-		ErrorStream:  stderrw,
-	})
+	// Follow the logs until the container dies
+	go func() {
+		messages := make(chan LogMessage, 100)
+		go func() {
+			defer close(messages)
+			if err := StreamLogs(context.Background(), client, c.ID, LogOptions{Follow: true}, messages); err != nil {
+				log.WithError(err).WithField("name", name).Warn("Could not follow daemon logs")
+			}
+		}()
+		for message := range messages {
+			line := append([]byte(message.Line), '\n')
+			if message.Stream == Stderr {
+				stderr <- line
+			} else {
+				stdout <- line
+			}
+		}
+	}()
 
-	// stdout goes to channel
-	go func(r io.Reader, out chan<- []byte) {
-		data := make([]byte, 512)
-		_, err := r.Read(data)
-		out <- data
-		if err != nil {
-			// stop looking for stdout
-			return
+	return c, nil
+}
+
+// monitor waits for the container to die, sends its final state on done and removes it unless it was stopped.
+func monitor(client Runtime, id, name string, dies *Subscription, done chan<- docker.State) {
+	defer dies.Unsubscribe()
+	for event := range dies.C {
+		if event.ID != id {
+			continue
+		}
+		log.WithField("name", name).WithField("id", id).Info("Container died")
+		state := docker.State{ExitCode: event.ExitCode}
+		if c, err := client.InspectContainer(id); err == nil {
+			state = c.State
 		}
-	}(stdoutr, stdout)
 
-	// stderr goes to channel
-	go func(r io.Reader, out chan<- []byte) {
-		data := make([]byte, 512)
-		_, err := r.Read(data)
-		out <- data
-		if err != nil {
-			// stop looking for stderr
-			return
+		// Cleanup container after it exits - unless it was stopped (and maybe already started again)
+		if IsStopped(id) || state.Running {
+			log.WithField("name", name).Info("Keeping stopped container")
+			if state.Running {
+				state = docker.State{ExitCode: event.ExitCode}
+			}
+		} else {
+			err := client.RemoveContainer(docker.RemoveContainerOptions{
+				ID:            id,
+				Force:         true,
+				RemoveVolumes: false,
+			})
+			if err != nil {
+				log.WithError(err).Warn("Error removing container")
+			}
 		}
-	}(stderrr, stderr)
+		done <- state
+		return
+	}
+}
 
-	return c, nil
+// Monitor an already running container. When it dies its final state is sent on done and it is removed (unless it was stopped).
+// If the container is not running its state is sent on done right away.
+func Monitor(name string, done chan<- docker.State) error {
+	client, err := GetRuntime()
+	if err != nil {
+		return err
+	}
+
+	// Subscribe before inspecting so its death is not missed
+	dies := Subscribe(All(OfContainer(name), OfType(EventDie)))
+	c, err := client.InspectContainer(name)
+	if err != nil {
+		dies.Unsubscribe()
+		return err
+	}
+	if !c.State.Running {
+		dies.Unsubscribe()
+		go func() {
+			if IsStopped(c.ID) {
+				done <- c.State
+				return
+			}
+			if err := client.RemoveContainer(docker.RemoveContainerOptions{ID: c.ID, Force: true}); err != nil {
+				log.WithError(err).Warn("Error removing container")
+			}
+			done <- c.State
+		}()
+		return nil
+	}
+
+	go monitor(client, c.ID, name, dies, done)
+	return nil
 }
 
-// RunLambda will pull, create and start the container returning its stdout.
-// This function is meant to run a shortlived process.
-func RunLambda(ctx context.Context, name, repository, tag string, mounts map[string]string) ([]byte, []byte, error) {
+// LabelLambda is put on the containers run by RunLambda
+const LabelLambda = "lambda"
 
-	client, err := docker.NewClientFromEnv()
+// RunLambda will pull, create and start the container returning its stdout, stderr and the resource limits it exceeded.
+// This function is meant to run a shortlived process. The container is labelled with LabelLambda.
+func RunLambda(ctx context.Context, name, repository, tag string, mounts map[string]string, resources *Resources) ([]byte, []byte, []string, error) {
+
+	client, err := GetRuntime()
 	if err != nil {
-		log.WithError(err).Error("Could not create docker client")
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
-	container, err := run(client, name, repository, tag, nil, mounts, nil, false)
+	container, err := run(client, name, repository, tag, nil, "", mounts, map[string]string{LabelLambda: "true"}, resources, false)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	// Cleanup
@@ -434,16 +611,17 @@ func RunLambda(ctx context.Context, name, repository, tag string, mounts map[str
 	_, err = client.WaitContainerWithContext(container.ID, ctx)
 	if err != nil {
 		log.WithError(err).Warn("Error waiting for container to exit")
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
-	// Use a buffer to capture output
+	// Use a buffer to capture output - lambdas have no tty so the output is demultiplexed into stdout and stderr
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
 	err = client.Logs(docker.LogsOptions{
 		Stdout:       true,
+		Stderr:       true,
 		Container:    container.ID,
-		RawTerminal:  true,
+		RawTerminal:  false,
 		OutputStream: &stdout,
 		ErrorStream:  &stderr,
 	})
@@ -451,19 +629,47 @@ func RunLambda(ctx context.Context, name, repository, tag string, mounts map[str
 		log.WithError(err).Warn("Error getting container logs")
 	}
 
-	log.WithField("stdout", stdout.String()).WithField("stderr", stderr.String()).Info("Run done")
+	var exceeded []string
+	if c, err := client.InspectContainer(container.ID); err != nil {
+		log.WithError(err).Warn("Error inspecting container")
+	} else {
+		exceeded = Exceeded(c.State)
+	}
+
+	log.WithField("stdout", stdout.String()).WithField("stderr", stderr.String()).WithField("exceeded", exceeded).Info("Run done")
 
-	return stdout.Bytes(), stderr.Bytes(), nil
+	return stdout.Bytes(), stderr.Bytes(), exceeded, nil
+}
+
+// forward sends what is read from r on out until r fails - then c is closed.
+func forward(r io.Reader, out chan<- []byte, c io.Closer) {
+	data := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(data)
+		if n > 0 {
+			out <- append([]byte{}, data[:n]...)
+		}
+		if err != nil {
+			c.Close()
+			return
+		}
+	}
 }
 
-// Attach to a container
+// Attach to a container. Output is sent on stdout and stderr as it is read.
+// Containers with a tty can not tell the streams apart and send all output on stdout.
 func Attach(c docker.APIContainers, stdout, stderr chan<- []byte, stdin <-chan []byte) error {
 
-	client, err := docker.NewClientFromEnv()
+	client, err := GetRuntime()
+	if err != nil {
+		return err
+	}
+
+	inspected, err := client.InspectContainer(c.ID)
 	if err != nil {
-		log.WithError(err).Error("Could not create docker client")
 		return err
 	}
+	tty := inspected.Config != nil && inspected.Config.Tty
 
 	// Use a pipe to run stdout and stderr to channels
 	stdoutr, stdoutw := io.Pipe()
@@ -477,7 +683,7 @@ func Attach(c docker.APIContainers, stdout, stderr chan<- []byte, stdin <-chan [
 		Stdout:       true,
 		Stderr:       true,
 		Stdin:        true,
-		RawTerminal:  true,
+		RawTerminal:  tty,
 		OutputStream: stdoutw,
 		ErrorStream:  stderrw,
 		InputStream:  stdinr})
@@ -486,33 +692,16 @@ func Attach(c docker.APIContainers, stdout, stderr chan<- []byte, stdin <-chan [
 		return err
 	}
 
-	// stdout goes to channel
-	go func(r io.Reader, out chan<- []byte, c io.Closer) {
-		for {
-			data := make([]byte, 512)
-			_, err := r.Read(data)
-			out <- data
-			if err != nil {
-				// stop looking for stdout
-				c.Close()
-				return
-			}
-		}
-	}(stdoutr, stdout, cw)
-
-	// stderr goes to channel
-	go func(r io.Reader, out chan<- []byte, c io.Closer) {
-		for {
-			data := make([]byte, 512)
-			_, err := r.Read(data)
-			out <- data
-			if err != nil {
-				// stop looking for stderr
-				c.Close()
-				return
-			}
-		}
-	}(stderrr, stderr, cw)
+	// Close the pipes when the attachment ends
+	go func() {
+		err := cw.Wait()
+		stdoutw.CloseWithError(err)
+		stderrw.CloseWithError(err)
+	}()
+
+	// stdout and stderr go to channels
+	go forward(stdoutr, stdout, cw)
+	go forward(stderrr, stderr, cw)
 
 	// stdin goes from channel
 	go func(w io.Writer, in <-chan []byte, c io.Closer) {
@@ -0,0 +1,58 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSafeJoin(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "mount")
+	outside := filepath.Join(root, "outside")
+	for _, d := range []string{filepath.Join(dir, "sub"), outside} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"out":          outside,
+		"relative-out": "../outside",
+		"in":           "sub",
+		"dangling-out": filepath.Join(outside, "missing"),
+		"dangling-in":  "sub/missing",
+		"dangling-far": filepath.Join(root, "missing", "deeper"),
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		p      string
+		joined string
+	}{
+		{"a.txt", "a.txt"},
+		{"sub/new/a.txt", "sub/new/a.txt"},
+		{"", ""},
+		// .. and absolute paths stay within the directory
+		{"../a.txt", "a.txt"},
+		{"sub/../../../a.txt", "a.txt"},
+		{"/etc/passwd", "etc/passwd"},
+		// Symlinks are followed
+		{"in/a.txt", "in/a.txt"},
+		{"dangling-in/a.txt", "dangling-in/a.txt"},
+		{"dangling-in", "dangling-in"},
+	} {
+		joined, err := SafeJoin(dir, test.p)
+		if err != nil || joined != filepath.Join(dir, test.joined) {
+			t.Errorf("%q joined to %q (%v) - expected %q", test.p, joined, err, filepath.Join(dir, test.joined))
+		}
+	}
+
+	for _, p := range []string{"out", "out/a.txt", "relative-out/a.txt", "dangling-out", "dangling-out/a.txt", "dangling-far/a/b.txt"} {
+		if joined, err := SafeJoin(dir, p); err == nil {
+			t.Errorf("%q joined to %q - outside of the directory", p, joined)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package container
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Credentials authenticate the herder (and golems) against a server, e.g. an access-controlled webstrates server.
+// Credentials are defined in the config under "credentials" and the first entry whose host pattern (see path.Match)
+// matches the host (with or without port) is used (see defaultCredentials when none are defined), e.g.
+//
+//	credentials:
+//	  - host: "*.cs.au.dk"
+//	    username: web
+//	    password: strate
+//	  - host: "webstrates.example.com"
+//	    bearer: secret-token
+//	    cookies: ["session=abc"]
+type Credentials struct {
+	Host string `json:"host" mapstructure:"host"`
+	// Username and Password for basic auth
+	Username string `json:"username,omitempty" mapstructure:"username"`
+	Password string `json:"password,omitempty" mapstructure:"password"`
+	// Bearer is a token sent in the Authorization header (instead of basic auth)
+	Bearer string `json:"bearer,omitempty" mapstructure:"bearer"`
+	// Cookies in the format name=value
+	Cookies []string `json:"cookies,omitempty" mapstructure:"cookies"`
+}
+
+// Cookie is a cookie given by credentials.
+type Cookie struct {
+	Name  string
+	Value string
+}
+
+// defaultCredentials are used when no credentials are defined in the config. They are the basic auth of the
+// public webstrates servers which the herder has always used when fetching files.
+var defaultCredentials = []Credentials{
+	{Host: "webstrates.cs.au.dk", Username: "web", Password: "strate"},
+	{Host: "hiraku.cs.au.dk", Username: "web", Password: "strate"},
+}
+
+// CredentialsFor returns the credentials for the given host (host or host:port) - or nil if there are none.
+func CredentialsFor(host string) (*Credentials, error) {
+	all := defaultCredentials
+	if viper.IsSet("credentials") {
+		all = []Credentials{}
+		if err := viper.UnmarshalKey("credentials", &all); err != nil {
+			return nil, err
+		}
+	}
+	hostname := host
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.HasSuffix(host, "]") {
+		hostname = host[:i]
+	}
+	for _, c := range all {
+		for _, h := range []string{host, hostname} {
+			if ok, err := path.Match(c.Host, h); err == nil && ok {
+				if _, err := c.ParseCookies(); err != nil {
+					return nil, fmt.Errorf("Invalid credentials for %s: %v", c.Host, err)
+				}
+				return &c, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Authorization returns the value of the Authorization header given by the credentials - or "" if none.
+func (c *Credentials) Authorization() string {
+	if c == nil {
+		return ""
+	}
+	if c.Bearer != "" {
+		return "Bearer " + c.Bearer
+	}
+	if c.Username != "" || c.Password != "" {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(c.Username+":"+c.Password))
+	}
+	return ""
+}
+
+// ParseCookies returns the cookies given by the credentials.
+func (c *Credentials) ParseCookies() ([]Cookie, error) {
+	cookies := []Cookie{}
+	if c == nil {
+		return cookies, nil
+	}
+	for _, cookie := range c.Cookies {
+		parts := strings.SplitN(cookie, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid cookie: %s", cookie)
+		}
+		cookies = append(cookies, Cookie{Name: parts[0], Value: parts[1]})
+	}
+	return cookies, nil
+}
+
+// Authorize adds the Authorization header and cookies given by the credentials to the request.
+func (c *Credentials) Authorize(request *http.Request) {
+	if authorization := c.Authorization(); authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+	cookies, _ := c.ParseCookies()
+	for _, cookie := range cookies {
+		request.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+}
@@ -0,0 +1,355 @@
+package container
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+// EventType is the type of a container lifecycle event.
+type EventType string
+
+const (
+	// EventStart is sent when a container starts
+	EventStart EventType = "start"
+	// EventDie is sent when a container stops
+	EventDie EventType = "die"
+	// EventOOM is sent when a container runs out of memory (followed by EventDie)
+	EventOOM EventType = "oom"
+	// EventHealth is sent when the health status of a container changes
+	EventHealth EventType = "health_status"
+	// EventDestroy is sent when a container is removed
+	EventDestroy EventType = "destroy"
+)
+
+// Event is a lifecycle event of a container.
+type Event struct {
+	Type EventType
+	ID   string
+	// Name of the container (without leading /)
+	Name   string
+	Image  string
+	Labels map[string]string
+	// ExitCode is set for EventDie
+	ExitCode int
+	// Health is set for EventHealth (e.g. healthy or unhealthy)
+	Health string
+	Time   time.Time
+}
+
+// Subscription receives the events matched by its predicate on C.
+type Subscription struct {
+	C       <-chan Event
+	c       chan Event
+	matches func(event *Event) bool
+	done    chan bool
+	once    sync.Once
+
+	// pending events are queued by the watcher and delivered on c by forward
+	mutex   sync.Mutex
+	pending []Event
+	queued  chan bool
+}
+
+// Unsubscribe stops the delivery of events to the subscription.
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(func() {
+		subscriptionsMutex.Lock()
+		delete(subscriptions, s)
+		subscriptionsMutex.Unlock()
+		close(s.done)
+	})
+}
+
+// push queues the event for delivery without waiting for the subscriber.
+func (s *Subscription) push(event Event) {
+	s.mutex.Lock()
+	s.pending = append(s.pending, event)
+	n := len(s.pending)
+	s.mutex.Unlock()
+	if n%pendingWarning == 0 {
+		log.WithField("pending", n).Warn("Container event subscriber does not keep up")
+	}
+	select {
+	case s.queued <- true:
+	default:
+	}
+}
+
+// forward delivers the pending events on c in order until the subscription is cancelled.
+func (s *Subscription) forward() {
+	for {
+		s.mutex.Lock()
+		if len(s.pending) == 0 {
+			s.mutex.Unlock()
+			select {
+			case <-s.done:
+				return
+			case <-s.queued:
+			}
+			continue
+		}
+		event := s.pending[0]
+		s.pending[0] = Event{}
+		s.pending = s.pending[1:]
+		s.mutex.Unlock()
+
+		select {
+		case <-s.done:
+			return
+		case s.c <- event:
+		}
+	}
+}
+
+// pendingWarning is the number of events a subscription may fall behind before warnings are logged
+const pendingWarning = 1000
+
+var (
+	subscriptions      = map[*Subscription]bool{}
+	subscriptionsMutex = &sync.Mutex{}
+
+	// running contains the ids and events of the containers which the watcher believes are running
+	running      = map[string]*Event{}
+	runningMutex = &sync.Mutex{}
+
+	watcherOnce sync.Once
+	// watching is closed once the watcher listens for events
+	watching = make(chan bool)
+)
+
+// Subscribe to the lifecycle events of the containers matched by the predicate.
+// The watcher is started if it is not running already.
+// Events must be read from C until Unsubscribe is called. The watcher never waits for slow subscribers -
+// events are queued for each subscription and none are dropped.
+func Subscribe(matches func(event *Event) bool) *Subscription {
+	return subscribe(matches, false)
+}
+
+// SubscribeRunning is like Subscribe, but it first delivers a start event for each
+// matching container which is already running.
+func SubscribeRunning(matches func(event *Event) bool) *Subscription {
+	return subscribe(matches, true)
+}
+
+func subscribe(matches func(event *Event) bool, replay bool) *Subscription {
+	watcherOnce.Do(func() {
+		go watch()
+		// Give the watcher a chance to listen before events are expected
+		select {
+		case <-watching:
+		case <-time.After(5 * time.Second):
+			log.Warn("Container event watcher is not listening yet")
+		}
+	})
+
+	subscriptionsMutex.Lock()
+	defer subscriptionsMutex.Unlock()
+
+	// Events dispatched after the running containers are read will be delivered after the replay
+	replayed := []Event{}
+	if replay {
+		for _, event := range Running() {
+			if matches == nil || matches(&event) {
+				replayed = append(replayed, event)
+			}
+		}
+	}
+
+	c := make(chan Event)
+	s := &Subscription{C: c, c: c, matches: matches, done: make(chan bool), pending: replayed, queued: make(chan bool, 1)}
+	subscriptions[s] = true
+	go s.forward()
+
+	return s
+}
+
+// OfContainer returns an event predicate matching the container with the given id or name.
+func OfContainer(idOrName string) func(event *Event) bool {
+	return func(event *Event) bool {
+		return event.ID == idOrName || event.Name == idOrName
+	}
+}
+
+// OfLabel returns an event predicate matching containers with the given label.
+func OfLabel(label string) func(event *Event) bool {
+	return func(event *Event) bool {
+		_, ok := event.Labels[label]
+		return ok
+	}
+}
+
+// OfType returns an event predicate matching events of the given types.
+func OfType(types ...EventType) func(event *Event) bool {
+	return func(event *Event) bool {
+		for _, t := range types {
+			if event.Type == t {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// All returns an event predicate which is the conjunction of the given predicates.
+func All(predicates ...func(event *Event) bool) func(event *Event) bool {
+	return func(event *Event) bool {
+		for _, predicate := range predicates {
+			if !predicate(event) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Running returns the last start event of each container the watcher believes is running.
+func Running() []Event {
+	runningMutex.Lock()
+	defer runningMutex.Unlock()
+	events := []Event{}
+	for _, event := range running {
+		events = append(events, *event)
+	}
+	return events
+}
+
+// dispatch queues the event for all matching subscriptions.
+// Must only be called by the watcher.
+func dispatch(event Event) {
+	runningMutex.Lock()
+	switch event.Type {
+	case EventStart:
+		running[event.ID] = &event
+	case EventDie, EventDestroy:
+		delete(running, event.ID)
+	}
+	runningMutex.Unlock()
+
+	subscriptionsMutex.Lock()
+	matching := []*Subscription{}
+	for s := range subscriptions {
+		if s.matches == nil || s.matches(&event) {
+			matching = append(matching, s)
+		}
+	}
+	subscriptionsMutex.Unlock()
+
+	for _, s := range matching {
+		s.push(event)
+	}
+}
+
+// toEvent translates a docker event to a lifecycle event. Returns false for irrelevant events.
+func toEvent(e *docker.APIEvents) (Event, bool) {
+	if e.Type != "container" {
+		return Event{}, false
+	}
+	event := Event{
+		ID:     e.Actor.ID,
+		Name:   e.Actor.Attributes["name"],
+		Image:  e.Actor.Attributes["image"],
+		Labels: map[string]string{},
+		Time:   time.Unix(0, e.TimeNano),
+	}
+	// Labels are mixed with the other attributes
+	for k, v := range e.Actor.Attributes {
+		if k != "name" && k != "image" && k != "exitCode" {
+			event.Labels[k] = v
+		}
+	}
+	switch {
+	case e.Action == "start":
+		event.Type = EventStart
+	case e.Action == "die":
+		event.Type = EventDie
+		event.ExitCode, _ = strconv.Atoi(e.Actor.Attributes["exitCode"])
+	case e.Action == "oom":
+		event.Type = EventOOM
+	case e.Action == "destroy":
+		event.Type = EventDestroy
+	case strings.HasPrefix(e.Action, "health_status"):
+		event.Type = EventHealth
+		event.Health = strings.TrimSpace(strings.TrimPrefix(e.Action, "health_status:"))
+	default:
+		return Event{}, false
+	}
+	return event, true
+}
+
+// resync lists the running containers and dispatches events for the changes missed by the watcher.
+func resync(client Runtime) {
+	containers, err := client.ListContainers(docker.ListContainersOptions{})
+	if err != nil {
+		log.WithError(err).Warn("Could not list containers to resync watcher")
+		return
+	}
+
+	ids := map[string]bool{}
+	for _, c := range containers {
+		ids[c.ID] = true
+	}
+
+	runningMutex.Lock()
+	missed := []Event{}
+	for id, event := range running {
+		if !ids[id] {
+			missed = append(missed, Event{Type: EventDie, ID: id, Name: event.Name, Image: event.Image, Labels: event.Labels, Time: time.Now()})
+		}
+	}
+	for _, c := range containers {
+		if _, ok := running[c.ID]; !ok {
+			name := ""
+			if len(c.Names) > 0 {
+				name = strings.TrimPrefix(c.Names[0], "/")
+			}
+			missed = append(missed, Event{Type: EventStart, ID: c.ID, Name: name, Image: c.Image, Labels: c.Labels, Time: time.Unix(c.Created, 0)})
+		}
+	}
+	runningMutex.Unlock()
+
+	for _, event := range missed {
+		dispatch(event)
+	}
+}
+
+// watch listens for docker events and dispatches them to the subscriptions - reconnecting if the stream fails.
+func watch() {
+	var once sync.Once
+	for {
+		client, err := GetRuntime()
+		if err != nil {
+			<-time.After(5 * time.Second)
+			continue
+		}
+
+		listener := make(chan *docker.APIEvents, 100)
+		if err := client.AddEventListener(listener); err != nil {
+			log.WithError(err).Warn("Could not listen for container events, retrying")
+			<-time.After(5 * time.Second)
+			continue
+		}
+		log.Info("Watching container events")
+
+		// Catch up on what happened while not listening
+		resync(client)
+		once.Do(func() { close(watching) })
+
+		for e := range listener {
+			if e == docker.EOFEvent {
+				break
+			}
+			if event, ok := toEvent(e); ok {
+				dispatch(event)
+			}
+		}
+
+		log.Warn("Container event stream ended, reconnecting")
+		client.RemoveEventListener(listener)
+		<-time.After(time.Second)
+	}
+}
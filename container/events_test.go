@@ -0,0 +1,55 @@
+package container
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDispatchNeverWaitsOrDrops(t *testing.T) {
+	SetRuntime(NewFakeRuntime())
+	matches := OfLabel("dispatch-test")
+	slow := Subscribe(matches)
+	defer slow.Unsubscribe()
+	fast := Subscribe(matches)
+	defer fast.Unsubscribe()
+	gone := Subscribe(matches)
+	gone.Unsubscribe()
+
+	// Dispatch far more events than anyone reads - without waiting for the subscribers
+	n := 3 * pendingWarning
+	dispatched := make(chan bool)
+	go func() {
+		for i := 0; i < n; i++ {
+			dispatch(Event{Type: EventHealth, ID: "c", ExitCode: i, Labels: map[string]string{"dispatch-test": "true"}})
+		}
+		close(dispatched)
+	}()
+	select {
+	case <-dispatched:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Dispatching waits for subscribers")
+	}
+
+	// receive fails unless all events are received in order
+	receive := func(s *Subscription) {
+		for i := 0; i < n; i++ {
+			select {
+			case event := <-s.C:
+				if event.ExitCode != i {
+					t.Fatalf("Got event %d - expected %d", event.ExitCode, i)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Got %d events - expected %d", i, n)
+			}
+		}
+	}
+	receive(fast)
+	// The slow subscriber gets all events once it reads them
+	receive(slow)
+
+	select {
+	case event := <-gone.C:
+		t.Errorf("Event delivered after unsubscribing: %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,86 @@
+package container
+
+import (
+	"io"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+// ExecOptions describes a command to run in a container.
+type ExecOptions struct {
+	Cmd        []string
+	Env        []string
+	WorkingDir string
+	// Tty gives the command a terminal - its stderr is then sent on stdout
+	Tty bool
+}
+
+// Exec is a command running in a container.
+type Exec struct {
+	ID     string
+	client Runtime
+	cw     docker.CloseWaiter
+}
+
+// StartExec runs a command in the running container with the given id connecting its stdio to the given streams.
+func StartExec(client Runtime, id string, options ExecOptions, stdin io.Reader, stdout, stderr io.Writer) (*Exec, error) {
+	e, err := client.CreateExec(docker.CreateExecOptions{
+		Container:    id,
+		Cmd:          options.Cmd,
+		Env:          options.Env,
+		WorkingDir:   options.WorkingDir,
+		Tty:          options.Tty,
+		AttachStdin:  stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		log.WithError(err).WithField("container", id).Warn("Could not create exec")
+		return nil, err
+	}
+
+	cw, err := client.StartExecNonBlocking(e.ID, docker.StartExecOptions{
+		InputStream:  stdin,
+		OutputStream: stdout,
+		ErrorStream:  stderr,
+		Tty:          options.Tty,
+		RawTerminal:  options.Tty,
+	})
+	if err != nil {
+		log.WithError(err).WithField("container", id).Warn("Could not start exec")
+		return nil, err
+	}
+	log.WithField("container", id).WithField("exec", e.ID).WithField("cmd", options.Cmd).Info("Started exec")
+
+	return &Exec{ID: e.ID, client: client, cw: cw}, nil
+}
+
+// Resize resizes the terminal of the command.
+func (e *Exec) Resize(height, width int) error {
+	return e.client.ResizeExecTTY(e.ID, height, width)
+}
+
+// Wait waits for the command to finish and returns its exit code.
+func (e *Exec) Wait() (int, error) {
+	if err := e.cw.Wait(); err != nil {
+		return -1, err
+	}
+	// The output may end slightly before the command is done
+	for i := 0; ; i++ {
+		inspect, err := e.client.InspectExec(e.ID)
+		if err != nil {
+			return -1, err
+		}
+		if !inspect.Running || i == 20 {
+			return inspect.ExitCode, nil
+		}
+		<-time.After(50 * time.Millisecond)
+	}
+}
+
+// Close detaches from the command.
+func (e *Exec) Close() error {
+	return e.cw.Close()
+}
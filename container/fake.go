@@ -0,0 +1,823 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/rs/xid"
+)
+
+// FakeProgram simulates the main process of a container. It runs until it
+// returns (the exit code of the process) or until ctx is cancelled (the
+// container is killed).
+type FakeProgram func(ctx context.Context, c *docker.Container, stdin io.Reader, stdout, stderr io.Writer) int
+
+// FakeExecProgram simulates a command run in a container with exec.
+type FakeExecProgram func(ctx context.Context, cmd []string, stdin io.Reader, stdout, stderr io.Writer) int
+
+// FakeRuntime is an in-memory Runtime which simulates container lifecycles.
+// It is meant for testing code which would otherwise need a docker daemon.
+type FakeRuntime struct {
+	// Programs maps an image (repository:tag) to the program its containers run.
+	// Containers for images without a program run until they are killed.
+	Programs map[string]FakeProgram
+	// Unavailable images can not be pulled.
+	Unavailable map[string]bool
+	// Execs maps a command (the first element of the exec cmd) to the program it runs.
+	// Commands without a program echo their arguments.
+	Execs map[string]FakeExecProgram
+
+	mutex      sync.Mutex
+	images     map[string]string
+	containers map[string]*fakeContainer
+	execs      map[string]*fakeExec
+	addresses  int
+
+	// events are queued and delivered in order to the listeners by a single goroutine
+	eventsMutex sync.Mutex
+	eventsCond  *sync.Cond
+	events      []*docker.APIEvents
+	listeners   []chan<- *docker.APIEvents
+}
+
+type fakeContainer struct {
+	container *docker.Container
+
+	mutex  sync.Mutex
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+	// lines is the output line by line as docker logs sees it
+	lines    []fakeLine
+	stdin    *io.PipeWriter
+	attached map[*fakeAttachment]bool
+	cancel   context.CancelFunc
+	exited   chan bool
+	// oomKilled is set when the container is killed by OOMKill
+	oomKilled bool
+}
+
+type fakeLine struct {
+	stderr bool
+	time   time.Time
+	data   []byte
+}
+
+// write writes the line to w - prefixed by its timestamp like docker logs does if timestamps is set.
+func (l fakeLine) write(w io.Writer, timestamps bool) {
+	if timestamps {
+		io.WriteString(w, l.time.UTC().Format(time.RFC3339Nano)+" ")
+	}
+	w.Write(l.data)
+}
+
+type fakeAttachment struct {
+	stdout     io.Writer
+	stderr     io.Writer
+	timestamps bool
+	closed     chan bool
+	once       sync.Once
+}
+
+func (a *fakeAttachment) Close() error {
+	a.once.Do(func() { close(a.closed) })
+	return nil
+}
+
+func (a *fakeAttachment) Wait() error {
+	<-a.closed
+	return nil
+}
+
+// fakeStream records everything written by a fake program and fans it out to attachments.
+type fakeStream struct {
+	c      *fakeContainer
+	stderr bool
+}
+
+func (s *fakeStream) Write(p []byte) (int, error) {
+	s.c.mutex.Lock()
+	defer s.c.mutex.Unlock()
+	if s.stderr {
+		s.c.stderr.Write(p)
+	} else {
+		s.c.stdout.Write(p)
+	}
+	// Each write is taken as (the end of) a line
+	lines := []fakeLine{}
+	now := time.Now()
+	for _, data := range bytes.SplitAfter(p, []byte("\n")) {
+		if len(data) > 0 {
+			lines = append(lines, fakeLine{stderr: s.stderr, time: now, data: append([]byte{}, data...)})
+		}
+	}
+	s.c.lines = append(s.c.lines, lines...)
+	for a := range s.c.attached {
+		w := a.stdout
+		if s.stderr {
+			w = a.stderr
+		}
+		if w == nil {
+			continue
+		}
+		if !a.timestamps {
+			w.Write(p)
+			continue
+		}
+		for _, line := range lines {
+			line.write(w, true)
+		}
+	}
+	return len(p), nil
+}
+
+// NewFakeRuntime creates an empty FakeRuntime.
+func NewFakeRuntime() *FakeRuntime {
+	f := &FakeRuntime{
+		Programs:    map[string]FakeProgram{},
+		Unavailable: map[string]bool{},
+		Execs:       map[string]FakeExecProgram{},
+		images:      map[string]string{},
+		containers:  map[string]*fakeContainer{},
+		execs:       map[string]*fakeExec{},
+	}
+	f.eventsCond = sync.NewCond(&f.eventsMutex)
+	go f.deliver()
+	return f
+}
+
+// emit queues an event for the container with the given action.
+func (f *FakeRuntime) emit(c *docker.Container, action string) {
+	attributes := map[string]string{
+		"name":  strings.TrimPrefix(c.Name, "/"),
+		"image": c.Image,
+	}
+	if c.Config != nil {
+		for k, v := range c.Config.Labels {
+			attributes[k] = v
+		}
+	}
+	if action == "die" {
+		attributes["exitCode"] = strconv.Itoa(c.State.ExitCode)
+	}
+	now := time.Now()
+	f.eventsMutex.Lock()
+	f.events = append(f.events, &docker.APIEvents{
+		Action:   action,
+		Type:     "container",
+		Actor:    docker.APIActor{ID: c.ID, Attributes: attributes},
+		Status:   action,
+		ID:       c.ID,
+		From:     c.Image,
+		Time:     now.Unix(),
+		TimeNano: now.UnixNano(),
+	})
+	f.eventsCond.Signal()
+	f.eventsMutex.Unlock()
+}
+
+// deliver sends queued events to the listeners.
+func (f *FakeRuntime) deliver() {
+	for {
+		f.eventsMutex.Lock()
+		for len(f.events) == 0 {
+			f.eventsCond.Wait()
+		}
+		event := f.events[0]
+		f.events = f.events[1:]
+		listeners := append([]chan<- *docker.APIEvents{}, f.listeners...)
+		f.eventsMutex.Unlock()
+
+		for _, listener := range listeners {
+			listener <- event
+		}
+	}
+}
+
+// AddEventListener adds a listener for container events.
+func (f *FakeRuntime) AddEventListener(listener chan<- *docker.APIEvents) error {
+	f.eventsMutex.Lock()
+	defer f.eventsMutex.Unlock()
+	for _, l := range f.listeners {
+		if l == listener {
+			return docker.ErrListenerAlreadyExists
+		}
+	}
+	f.listeners = append(f.listeners, listener)
+	return nil
+}
+
+// RemoveEventListener removes a listener for container events.
+func (f *FakeRuntime) RemoveEventListener(listener chan *docker.APIEvents) error {
+	f.eventsMutex.Lock()
+	defer f.eventsMutex.Unlock()
+	listeners := []chan<- *docker.APIEvents{}
+	for _, l := range f.listeners {
+		if l != listener {
+			listeners = append(listeners, l)
+		}
+	}
+	f.listeners = listeners
+	return nil
+}
+
+// find returns the container with the given id or name. Must be called with the mutex held.
+func (f *FakeRuntime) find(id string) (*fakeContainer, error) {
+	if c, ok := f.containers[id]; ok {
+		return c, nil
+	}
+	for _, c := range f.containers {
+		if c.container.Name == id || c.container.Name == "/"+id {
+			return c, nil
+		}
+	}
+	return nil, &docker.NoSuchContainer{ID: id}
+}
+
+// AddImage makes the image present as if it was loaded locally.
+func (f *FakeRuntime) AddImage(image string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.images[image] = xid.New().String()
+}
+
+// PullImage makes the image present (with a new id) unless it is unavailable.
+func (f *FakeRuntime) PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	image := ImageName(opts.Repository, opts.Tag)
+	if f.Unavailable[image] {
+		return fmt.Errorf("Could not pull image %s", image)
+	}
+	f.images[image] = xid.New().String()
+	return nil
+}
+
+// InspectImage returns the image with the given name or id.
+func (f *FakeRuntime) InspectImage(name string) (*docker.Image, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for image, id := range f.images {
+		if image == name || id == name {
+			return &docker.Image{ID: id, RepoTags: []string{image}}, nil
+		}
+	}
+	return nil, docker.ErrNoSuchImage
+}
+
+// ListImages lists the present images.
+func (f *FakeRuntime) ListImages(opts docker.ListImagesOptions) ([]docker.APIImages, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	images := []docker.APIImages{}
+	for image, id := range f.images {
+		images = append(images, docker.APIImages{ID: id, RepoTags: []string{image}})
+	}
+	return images, nil
+}
+
+// RemoveImage removes the image with the given name or id unless a container uses it.
+func (f *FakeRuntime) RemoveImage(name string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for image, id := range f.images {
+		if image == name || id == name {
+			for _, fc := range f.containers {
+				if fc.container.Image == image {
+					return fmt.Errorf("Image %s is used by container %s", image, fc.container.ID)
+				}
+			}
+			delete(f.images, image)
+			return nil
+		}
+	}
+	return docker.ErrNoSuchImage
+}
+
+// CreateContainer creates a container in the "created" state.
+func (f *FakeRuntime) CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if opts.Config == nil {
+		return nil, fmt.Errorf("No config given")
+	}
+	image := ImageName(SplitImageName(opts.Config.Image))
+	if _, ok := f.images[image]; !ok {
+		return nil, docker.ErrNoSuchImage
+	}
+	if opts.Name != "" {
+		if _, err := f.find(opts.Name); err == nil {
+			return nil, docker.ErrContainerAlreadyExists
+		}
+	}
+
+	f.addresses++
+	c := &docker.Container{
+		ID:         xid.New().String(),
+		Name:       "/" + opts.Name,
+		Created:    time.Now(),
+		Image:      image,
+		Config:     opts.Config,
+		HostConfig: opts.HostConfig,
+		State:      docker.State{Status: "created"},
+		NetworkSettings: &docker.NetworkSettings{
+			Networks: map[string]docker.ContainerNetwork{
+				"bridge": {IPAddress: fmt.Sprintf("172.17.%d.%d", f.addresses/250, f.addresses%250+2)},
+			},
+		},
+	}
+	if opts.HostConfig != nil {
+		c.NetworkSettings.Ports = opts.HostConfig.PortBindings
+	}
+	f.containers[c.ID] = &fakeContainer{container: c, attached: map[*fakeAttachment]bool{}}
+
+	created := *c
+	return &created, nil
+}
+
+// StartContainer runs the program of the container in the background.
+func (f *FakeRuntime) StartContainer(id string, hostConfig *docker.HostConfig) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	fc, err := f.find(id)
+	if err != nil {
+		return err
+	}
+
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	if fc.container.State.Running {
+		return &docker.ContainerAlreadyRunning{ID: id}
+	}
+
+	program, ok := f.Programs[fc.container.Image]
+	if !ok {
+		program = func(ctx context.Context, c *docker.Container, stdin io.Reader, stdout, stderr io.Writer) int {
+			<-ctx.Done()
+			return 137
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stdinr, stdinw := io.Pipe()
+	fc.cancel = cancel
+	fc.stdin = stdinw
+	fc.exited = make(chan bool)
+	fc.container.State = docker.State{Status: "running", Running: true, StartedAt: time.Now()}
+
+	snapshot := *fc.container
+	f.emit(&snapshot, "start")
+	go func(exited chan bool) {
+		code := program(ctx, &snapshot, stdinr, &fakeStream{c: fc}, &fakeStream{c: fc, stderr: true})
+		if ctx.Err() != nil {
+			code = 137
+		}
+		cancel()
+		stdinr.Close()
+
+		fc.mutex.Lock()
+		fc.container.State.Running = false
+		fc.container.State.Status = "exited"
+		fc.container.State.ExitCode = code
+		fc.container.State.OOMKilled = fc.oomKilled
+		fc.oomKilled = false
+		fc.container.State.FinishedAt = time.Now()
+		for a := range fc.attached {
+			a.Close()
+		}
+		fc.attached = map[*fakeAttachment]bool{}
+		final := *fc.container
+		fc.mutex.Unlock()
+		if final.State.OOMKilled {
+			f.emit(&final, "oom")
+		}
+		f.emit(&final, "die")
+		close(exited)
+	}(fc.exited)
+
+	return nil
+}
+
+// KillContainer stops the program of a running container.
+func (f *FakeRuntime) KillContainer(opts docker.KillContainerOptions) error {
+	f.mutex.Lock()
+	fc, err := f.find(opts.ID)
+	f.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	fc.mutex.Lock()
+	if !fc.container.State.Running {
+		fc.mutex.Unlock()
+		return &docker.ContainerNotRunning{ID: opts.ID}
+	}
+	cancel, exited := fc.cancel, fc.exited
+	fc.mutex.Unlock()
+
+	cancel()
+	<-exited
+	return nil
+}
+
+// StopContainer stops a running container. Fake programs are stopped like they are killed.
+func (f *FakeRuntime) StopContainer(id string, timeout uint) error {
+	return f.KillContainer(docker.KillContainerOptions{ID: id})
+}
+
+// OOMKill kills a running container as if it ran out of memory.
+func (f *FakeRuntime) OOMKill(id string) error {
+	f.mutex.Lock()
+	fc, err := f.find(id)
+	f.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	fc.mutex.Lock()
+	fc.oomKilled = true
+	fc.mutex.Unlock()
+	return f.KillContainer(docker.KillContainerOptions{ID: id})
+}
+
+// RemoveContainer forgets the container - running containers are only removed if forced.
+func (f *FakeRuntime) RemoveContainer(opts docker.RemoveContainerOptions) error {
+	f.mutex.Lock()
+	fc, err := f.find(opts.ID)
+	f.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	fc.mutex.Lock()
+	running := fc.container.State.Running
+	fc.mutex.Unlock()
+	if running {
+		if !opts.Force {
+			return fmt.Errorf("Can not remove running container %s", opts.ID)
+		}
+		if err := f.KillContainer(docker.KillContainerOptions{ID: opts.ID}); err != nil {
+			return err
+		}
+	}
+
+	f.mutex.Lock()
+	delete(f.containers, fc.container.ID)
+	f.mutex.Unlock()
+
+	fc.mutex.Lock()
+	final := *fc.container
+	fc.mutex.Unlock()
+	f.emit(&final, "destroy")
+	return nil
+}
+
+// InspectContainer returns a copy of the container.
+func (f *FakeRuntime) InspectContainer(id string) (*docker.Container, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	fc, err := f.find(id)
+	if err != nil {
+		return nil, err
+	}
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	snapshot := *fc.container
+	return &snapshot, nil
+}
+
+// ListContainers lists running (or all) containers.
+func (f *FakeRuntime) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	containers := []docker.APIContainers{}
+	for _, fc := range f.containers {
+		fc.mutex.Lock()
+		c := fc.container
+		if opts.All || c.State.Running {
+			ports := []docker.APIPort{}
+			for port, bindings := range c.NetworkSettings.Ports {
+				for _, binding := range bindings {
+					var public, private int64
+					fmt.Sscanf(binding.HostPort, "%d", &public)
+					fmt.Sscanf(port.Port(), "%d", &private)
+					ports = append(ports, docker.APIPort{
+						PrivatePort: private,
+						PublicPort:  public,
+						Type:        port.Proto(),
+						IP:          binding.HostIP,
+					})
+				}
+			}
+			containers = append(containers, docker.APIContainers{
+				ID:      c.ID,
+				Image:   c.Image,
+				Created: c.Created.Unix(),
+				State:   c.State.Status,
+				Status:  c.State.String(),
+				Ports:   ports,
+				Names:   []string{c.Name},
+				Labels:  c.Config.Labels,
+			})
+		}
+		fc.mutex.Unlock()
+	}
+	return containers, nil
+}
+
+// Logs writes the output of the container so far to the given streams.
+// If Follow is set it keeps writing until the container exits.
+func (f *FakeRuntime) Logs(opts docker.LogsOptions) error {
+	f.mutex.Lock()
+	fc, err := f.find(opts.Container)
+	f.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	stdout, stderr := opts.OutputStream, opts.ErrorStream
+	if !opts.Stdout {
+		stdout = nil
+	}
+	if !opts.Stderr {
+		stderr = nil
+	}
+
+	fc.mutex.Lock()
+	lines := []fakeLine{}
+	for _, line := range fc.lines {
+		if (line.stderr && stderr != nil || !line.stderr && stdout != nil) && line.time.Unix() >= opts.Since {
+			lines = append(lines, line)
+		}
+	}
+	if tail, err := strconv.Atoi(opts.Tail); err == nil && tail >= 0 && tail < len(lines) {
+		lines = lines[len(lines)-tail:]
+	}
+	for _, line := range lines {
+		if line.stderr {
+			line.write(stderr, opts.Timestamps)
+		} else {
+			line.write(stdout, opts.Timestamps)
+		}
+	}
+	if !opts.Follow || !fc.container.State.Running {
+		fc.mutex.Unlock()
+		return nil
+	}
+	a := &fakeAttachment{stdout: stdout, stderr: stderr, timestamps: opts.Timestamps, closed: make(chan bool)}
+	fc.attached[a] = true
+	fc.mutex.Unlock()
+
+	if opts.Context != nil {
+		select {
+		case <-a.closed:
+		case <-opts.Context.Done():
+		}
+		fc.mutex.Lock()
+		delete(fc.attached, a)
+		fc.mutex.Unlock()
+		return nil
+	}
+	return a.Wait()
+}
+
+// AttachToContainerNonBlocking streams the output of the container and feeds it its input.
+func (f *FakeRuntime) AttachToContainerNonBlocking(opts docker.AttachToContainerOptions) (docker.CloseWaiter, error) {
+	f.mutex.Lock()
+	fc, err := f.find(opts.Container)
+	f.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	a := &fakeAttachment{closed: make(chan bool)}
+	if opts.Stdout {
+		a.stdout = opts.OutputStream
+	}
+	if opts.Stderr {
+		a.stderr = opts.ErrorStream
+	}
+
+	// Like docker the streaming happens in the background so the caller can start reading
+	go func() {
+		fc.mutex.Lock()
+		if opts.Logs {
+			if a.stdout != nil {
+				a.stdout.Write(fc.stdout.Bytes())
+			}
+			if a.stderr != nil {
+				a.stderr.Write(fc.stderr.Bytes())
+			}
+		}
+		if !fc.container.State.Running {
+			fc.mutex.Unlock()
+			a.Close()
+			return
+		}
+		fc.attached[a] = true
+		stdin := fc.stdin
+		fc.mutex.Unlock()
+
+		if opts.Stdin && opts.InputStream != nil {
+			go func() {
+				io.Copy(stdin, opts.InputStream)
+			}()
+		}
+
+		<-a.closed
+		fc.mutex.Lock()
+		delete(fc.attached, a)
+		fc.mutex.Unlock()
+	}()
+
+	return a, nil
+}
+
+// WaitContainerWithContext blocks until the container exits and returns its exit code.
+func (f *FakeRuntime) WaitContainerWithContext(id string, ctx context.Context) (int, error) {
+	f.mutex.Lock()
+	fc, err := f.find(id)
+	f.mutex.Unlock()
+	if err != nil {
+		return -1, err
+	}
+
+	fc.mutex.Lock()
+	exited := fc.exited
+	fc.mutex.Unlock()
+
+	if exited != nil {
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		select {
+		case <-exited:
+		case <-ctx.Done():
+			return -1, ctx.Err()
+		}
+	}
+
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	return fc.container.State.ExitCode, nil
+}
+
+type fakeExec struct {
+	mutex   sync.Mutex
+	inspect docker.ExecInspect
+	opts    docker.CreateExecOptions
+	// Height and Width is the last size the tty was resized to
+	Height, Width int
+}
+
+// CreateExec sets up a command in a running container.
+func (f *FakeRuntime) CreateExec(opts docker.CreateExecOptions) (*docker.Exec, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	fc, err := f.find(opts.Container)
+	if err != nil {
+		return nil, err
+	}
+	fc.mutex.Lock()
+	running := fc.container.State.Running
+	fc.mutex.Unlock()
+	if !running {
+		return nil, &docker.ContainerNotRunning{ID: opts.Container}
+	}
+
+	id := xid.New().String()
+	f.execs[id] = &fakeExec{
+		opts: opts,
+		inspect: docker.ExecInspect{
+			ID:          id,
+			ContainerID: fc.container.ID,
+			OpenStdin:   opts.AttachStdin,
+			OpenStdout:  opts.AttachStdout,
+			OpenStderr:  opts.AttachStderr,
+			ProcessConfig: docker.ExecProcessConfig{
+				Tty: opts.Tty,
+			},
+		},
+	}
+	if len(opts.Cmd) > 0 {
+		f.execs[id].inspect.ProcessConfig.EntryPoint = opts.Cmd[0]
+		f.execs[id].inspect.ProcessConfig.Arguments = opts.Cmd[1:]
+	}
+	return &docker.Exec{ID: id}, nil
+}
+
+// StartExecNonBlocking runs the command and streams its stdio.
+func (f *FakeRuntime) StartExecNonBlocking(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error) {
+	f.mutex.Lock()
+	e, ok := f.execs[id]
+	f.mutex.Unlock()
+	if !ok {
+		return nil, &docker.NoSuchExec{ID: id}
+	}
+
+	e.mutex.Lock()
+	if e.inspect.Running {
+		e.mutex.Unlock()
+		return nil, fmt.Errorf("Exec %s is already running", id)
+	}
+	e.inspect.Running = true
+	cmd := e.opts.Cmd
+	e.mutex.Unlock()
+
+	program, ok := f.Execs[""]
+	if len(cmd) > 0 {
+		program, ok = f.Execs[cmd[0]]
+	}
+	if !ok {
+		program = func(ctx context.Context, cmd []string, stdin io.Reader, stdout, stderr io.Writer) int {
+			fmt.Fprintln(stdout, strings.Join(cmd, " "))
+			return 0
+		}
+	}
+
+	stdin := opts.InputStream
+	if stdin == nil || !e.opts.AttachStdin {
+		stdin = bytes.NewReader(nil)
+	}
+	stdout, stderr := opts.OutputStream, opts.ErrorStream
+	if stdout == nil || !e.opts.AttachStdout {
+		stdout = ioutil.Discard
+	}
+	if e.opts.Tty {
+		// Like a tty stderr goes to stdout
+		stderr = stdout
+	}
+	if stderr == nil || !e.opts.AttachStderr && !e.opts.Tty {
+		stderr = ioutil.Discard
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &fakeAttachment{closed: make(chan bool)}
+	go func() {
+		<-a.closed
+		cancel()
+	}()
+	go func() {
+		code := program(ctx, cmd, stdin, stdout, stderr)
+		e.mutex.Lock()
+		e.inspect.Running = false
+		e.inspect.ExitCode = code
+		e.mutex.Unlock()
+		a.Close()
+	}()
+
+	if opts.Detach {
+		return nil, nil
+	}
+	return a, nil
+}
+
+// ResizeExecTTY records the size of the tty of the command.
+func (f *FakeRuntime) ResizeExecTTY(id string, height, width int) error {
+	f.mutex.Lock()
+	e, ok := f.execs[id]
+	f.mutex.Unlock()
+	if !ok {
+		return &docker.NoSuchExec{ID: id}
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.Height, e.Width = height, width
+	return nil
+}
+
+// ExecSize returns the size the tty of the command was last resized to.
+func (f *FakeRuntime) ExecSize(id string) (int, int) {
+	f.mutex.Lock()
+	e, ok := f.execs[id]
+	f.mutex.Unlock()
+	if !ok {
+		return 0, 0
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.Height, e.Width
+}
+
+// InspectExec returns the state of the command.
+func (f *FakeRuntime) InspectExec(id string) (*docker.ExecInspect, error) {
+	f.mutex.Lock()
+	e, ok := f.execs[id]
+	f.mutex.Unlock()
+	if !ok {
+		return nil, &docker.NoSuchExec{ID: id}
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	inspect := e.inspect
+	return &inspect, nil
+}
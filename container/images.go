@@ -0,0 +1,259 @@
+package container
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// PullPolicy determines when images are pulled before a container is created.
+type PullPolicy string
+
+const (
+	// PullAlways pulls the image before every container creation (falling back to a local image if the pull fails)
+	PullAlways PullPolicy = "always"
+	// PullIfNotPresent only pulls the image if it is not present locally
+	PullIfNotPresent PullPolicy = "if-not-present"
+	// PullNever never pulls the image - it must be present locally
+	PullNever PullPolicy = "never"
+)
+
+var (
+	// used contains the images ensured by this herder
+	used      = map[string]bool{}
+	usedMutex = &sync.Mutex{}
+)
+
+// ParsePullPolicy returns the pull policy with the given name.
+func ParsePullPolicy(name string) (PullPolicy, error) {
+	switch policy := PullPolicy(name); policy {
+	case PullAlways, PullIfNotPresent, PullNever:
+		return policy, nil
+	}
+	return "", fmt.Errorf("Unknown pull policy: %s", name)
+}
+
+// ImageName returns the name of the image given by repository and tag.
+func ImageName(repository, tag string) string {
+	if tag == "" {
+		tag = "latest"
+	}
+	return fmt.Sprintf("%s:%s", repository, tag)
+}
+
+// SplitImageName splits an image name into repository and tag.
+func SplitImageName(image string) (string, string) {
+	// the tag is after the last colon unless that colon is part of a registry host:port
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+	return image, "latest"
+}
+
+// PullPolicyFor returns the pull policy for the given image. Policies are looked up in
+// "pull-policies" by repository:tag and then repository before falling back to "pull-policy".
+func PullPolicyFor(repository, tag string) PullPolicy {
+	policies := viper.GetStringMapString("pull-policies")
+	name, ok := policies[ImageName(repository, tag)]
+	if !ok {
+		name, ok = policies[repository]
+	}
+	if !ok {
+		name = viper.GetString("pull-policy")
+	}
+	if name == "" {
+		return PullIfNotPresent
+	}
+	policy, err := ParsePullPolicy(name)
+	if err != nil {
+		log.WithError(err).WithField("image", ImageName(repository, tag)).Warn("Invalid pull policy, using if-not-present")
+		return PullIfNotPresent
+	}
+	return policy
+}
+
+// UsedImages returns the images which have been ensured by this herder since it started.
+func UsedImages() []string {
+	usedMutex.Lock()
+	defer usedMutex.Unlock()
+	images := []string{}
+	for image := range used {
+		images = append(images, image)
+	}
+	return images
+}
+
+// Pull pulls the image given by repository and tag.
+func Pull(client Runtime, repository, tag string) error {
+	log.WithFields(log.Fields{"image": ImageName(repository, tag)}).Info("Pulling image")
+	err := client.PullImage(docker.PullImageOptions{
+		Repository: repository,
+		Tag:        tag,
+	}, docker.AuthConfiguration{})
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"image": ImageName(repository, tag)}).Info("Pull done")
+	return nil
+}
+
+// EnsureImage makes sure the image is present according to its pull policy.
+func EnsureImage(client Runtime, repository, tag string) error {
+	image := ImageName(repository, tag)
+
+	usedMutex.Lock()
+	used[image] = true
+	usedMutex.Unlock()
+
+	_, err := client.InspectImage(image)
+	present := err == nil
+	if err != nil && err != docker.ErrNoSuchImage {
+		return err
+	}
+
+	switch PullPolicyFor(repository, tag) {
+	case PullNever:
+		if !present {
+			return fmt.Errorf("Image %s is not present and its pull policy is never", image)
+		}
+		return nil
+	case PullIfNotPresent:
+		if present {
+			return nil
+		}
+		return Pull(client, repository, tag)
+	default:
+		if err := Pull(client, repository, tag); err != nil {
+			if !present {
+				return err
+			}
+			log.WithError(err).WithField("image", image).Warn("Could not pull image, using local image")
+		}
+		return nil
+	}
+}
+
+// RefreshImages re-pulls the given images (and the used images) with the given interval.
+// Images with the never pull policy are not refreshed. Blocks - run it in a goroutine.
+func RefreshImages(interval time.Duration, images func() []string) {
+	for {
+		<-time.After(interval)
+
+		client, err := GetRuntime()
+		if err != nil {
+			continue
+		}
+
+		refresh := map[string]bool{}
+		for _, image := range append(images(), UsedImages()...) {
+			refresh[image] = true
+		}
+		for image := range refresh {
+			repository, tag := SplitImageName(image)
+			if PullPolicyFor(repository, tag) == PullNever {
+				continue
+			}
+			if err := Pull(client, repository, tag); err != nil {
+				log.WithError(err).WithField("image", image).Warn("Could not refresh image")
+			}
+		}
+	}
+}
+
+// ImageInfo describes the local state of an image.
+type ImageInfo struct {
+	Name    string
+	Present bool
+	ID      string `json:",omitempty"`
+	Created int64  `json:",omitempty"`
+	Size    int64  `json:",omitempty"`
+	Policy  PullPolicy
+}
+
+// ListImages returns information about the given images.
+func ListImages(client Runtime, images []string) ([]ImageInfo, error) {
+	local, err := client.ListImages(docker.ListImagesOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []ImageInfo{}
+	for _, image := range images {
+		repository, tag := SplitImageName(image)
+		info := ImageInfo{Name: ImageName(repository, tag), Policy: PullPolicyFor(repository, tag)}
+		for _, l := range local {
+			for _, repoTag := range l.RepoTags {
+				if repoTag == info.Name {
+					info.Present = true
+					info.ID = l.ID
+					info.Created = l.Created
+					info.Size = l.Size
+				}
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// repositoriesOf returns the repositories of a local image (also for untagged images).
+func repositoriesOf(image docker.APIImages) []string {
+	repositories := []string{}
+	for _, repoTag := range image.RepoTags {
+		if repoTag != "<none>:<none>" {
+			repository, _ := SplitImageName(repoTag)
+			repositories = append(repositories, repository)
+		}
+	}
+	for _, digest := range image.RepoDigests {
+		if i := strings.Index(digest, "@"); i > 0 {
+			repositories = append(repositories, digest[:i])
+		}
+	}
+	return repositories
+}
+
+// PruneImages removes local images from the repositories of the given images
+// which are not one of the given images, e.g. old versions left behind by a refresh.
+// Returns the ids of the removed images.
+func PruneImages(client Runtime, images []string) ([]string, error) {
+	keep := map[string]bool{}
+	repositories := map[string]bool{}
+	for _, image := range images {
+		repository, tag := SplitImageName(image)
+		keep[ImageName(repository, tag)] = true
+		repositories[repository] = true
+	}
+
+	local, err := client.ListImages(docker.ListImagesOptions{Digests: true})
+	if err != nil {
+		return nil, err
+	}
+
+	removed := []string{}
+	for _, image := range local {
+		ours := false
+		for _, repository := range repositoriesOf(image) {
+			ours = ours || repositories[repository]
+		}
+		kept := false
+		for _, repoTag := range image.RepoTags {
+			kept = kept || keep[repoTag]
+		}
+		if !ours || kept {
+			continue
+		}
+		log.WithField("image", image.ID).WithField("tags", image.RepoTags).Info("Removing image")
+		if err := client.RemoveImage(image.ID); err != nil {
+			log.WithError(err).WithField("image", image.ID).Warn("Could not remove image")
+			continue
+		}
+		removed = append(removed, image.ID)
+	}
+	return removed, nil
+}
@@ -0,0 +1,203 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+// Stream identifies the stream a log message was written to.
+type Stream string
+
+const (
+	// Stdout is the standard output of a container
+	Stdout Stream = "stdout"
+	// Stderr is the standard error of a container
+	Stderr Stream = "stderr"
+)
+
+// LogMessage is a line written by a container.
+type LogMessage struct {
+	Stream Stream
+	Time   time.Time
+	// Line is the line without its trailing newline
+	Line string
+}
+
+// LogOptions selects the log messages of a container.
+type LogOptions struct {
+	// Stdout and Stderr select the streams - both are selected if neither is set
+	Stdout bool
+	Stderr bool
+	// Since and Until limit the messages to a period - zero values mean unlimited
+	Since time.Time
+	Until time.Time
+	// Tail is the amount of messages to return from the end of the logs - all messages if zero
+	Tail int
+	// Follow keeps streaming messages until the container stops, Until is reached or the context is done
+	Follow bool
+}
+
+// lineWriter frames the (timestamped) output of a stream into log messages.
+type lineWriter struct {
+	stream Stream
+	buffer bytes.Buffer
+	emit   func(message LogMessage) bool
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buffer.Write(p)
+	for {
+		i := bytes.IndexByte(w.buffer.Bytes(), '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := string(w.buffer.Next(i + 1))
+		if !w.emit(parseLine(w.stream, line[:len(line)-1])) {
+			return 0, io.ErrClosedPipe
+		}
+	}
+}
+
+// flush emits what is left of an unterminated last line.
+func (w *lineWriter) flush() {
+	if w.buffer.Len() > 0 {
+		w.emit(parseLine(w.stream, w.buffer.String()))
+		w.buffer.Reset()
+	}
+}
+
+// parseLine splits the docker timestamp off a line of output.
+func parseLine(stream Stream, line string) LogMessage {
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	if i := strings.IndexByte(line, ' '); i > 0 {
+		if t, err := time.Parse(time.RFC3339Nano, line[:i]); err == nil {
+			return LogMessage{Stream: stream, Time: t, Line: line[i+1:]}
+		}
+	}
+	return LogMessage{Stream: stream, Time: time.Now(), Line: line}
+}
+
+// StreamLogs sends the log messages of the container with the given id on messages.
+// Streams are demultiplexed unless the container has a tty, in which case all output is on stdout.
+// Returns when the logs are exhausted (or followed to their end) or the context is done. Messages is not closed.
+func StreamLogs(ctx context.Context, client Runtime, id string, options LogOptions, messages chan<- LogMessage) error {
+	c, err := client.InspectContainer(id)
+	if err != nil {
+		return err
+	}
+
+	if !options.Stdout && !options.Stderr {
+		options.Stdout, options.Stderr = true, true
+	}
+	if !options.Until.IsZero() && options.Until.Before(time.Now()) {
+		options.Follow = false
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Without an end the tail can be left to docker, otherwise the messages until the end are kept
+	tail := "all"
+	var kept []LogMessage
+	var keptMutex sync.Mutex
+	if options.Tail > 0 && options.Until.IsZero() {
+		tail = strconv.Itoa(options.Tail)
+	}
+	buffered := options.Tail > 0 && !options.Until.IsZero()
+
+	emit := func(message LogMessage) bool {
+		if !options.Since.IsZero() && message.Time.Before(options.Since) {
+			return true
+		}
+		if !options.Until.IsZero() && message.Time.After(options.Until) {
+			// Messages are ordered so nothing more is wanted
+			cancel()
+			return false
+		}
+		if buffered {
+			keptMutex.Lock()
+			kept = append(kept, message)
+			if len(kept) > options.Tail {
+				kept = kept[1:]
+			}
+			keptMutex.Unlock()
+			return true
+		}
+		select {
+		case messages <- message:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	stdout := &lineWriter{stream: Stdout, emit: emit}
+	stderr := &lineWriter{stream: Stderr, emit: emit}
+	opts := docker.LogsOptions{
+		Context:      ctx,
+		Container:    c.ID,
+		OutputStream: stdout,
+		ErrorStream:  stderr,
+		Tail:         tail,
+		Follow:       options.Follow,
+		Stdout:       options.Stdout,
+		Stderr:       options.Stderr,
+		Timestamps:   true,
+		RawTerminal:  c.Config != nil && c.Config.Tty,
+	}
+	if !options.Since.IsZero() {
+		opts.Since = options.Since.Unix()
+	}
+
+	err = client.Logs(opts)
+	stdout.flush()
+	stderr.flush()
+	if err != nil && ctx.Err() == nil {
+		log.WithError(err).WithField("container", id).Warn("Error streaming logs")
+		return err
+	}
+
+	for _, message := range kept {
+		select {
+		case messages <- message:
+		case <-parent.Done():
+			return nil
+		}
+	}
+	return nil
+}
+
+// FilterLogs returns the messages selected by the options (except Follow).
+func FilterLogs(messages []LogMessage, options LogOptions) []LogMessage {
+	if !options.Stdout && !options.Stderr {
+		options.Stdout, options.Stderr = true, true
+	}
+	filtered := []LogMessage{}
+	for _, message := range messages {
+		if message.Stream == Stdout && !options.Stdout || message.Stream == Stderr && !options.Stderr {
+			continue
+		}
+		if !options.Since.IsZero() && message.Time.Before(options.Since) {
+			continue
+		}
+		if !options.Until.IsZero() && message.Time.After(options.Until) {
+			continue
+		}
+		filtered = append(filtered, message)
+	}
+	if options.Tail > 0 && options.Tail < len(filtered) {
+		filtered = filtered[len(filtered)-options.Tail:]
+	}
+	return filtered
+}
@@ -0,0 +1,343 @@
+package container
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	docker "github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// ErrNoPortsAvailable is returned when the port range of an allocator is exhausted.
+var ErrNoPortsAvailable = errors.New("No host ports available")
+
+var portsBucket = []byte("Ports")
+
+// Reservation of a host port. A reservation is made for an owner (the name of the container to be created)
+// and is bound to the container ID once the container exists.
+type Reservation struct {
+	Port  int
+	Owner string
+	ID    string `json:",omitempty"`
+}
+
+// PortAllocator hands out host ports from a range. Reservations are persisted so they survive restarts.
+type PortAllocator struct {
+	min, max int
+	db       *bolt.DB
+	mutex    sync.Mutex
+	reserved map[int]*Reservation
+}
+
+// NewPortAllocator creates an allocator for the ports in [min, max] persisting reservations in the db at path.
+func NewPortAllocator(path string, min, max int) (*PortAllocator, error) {
+	if min <= 0 || max > 65535 || min > max {
+		return nil, fmt.Errorf("Invalid port range %d-%d", min, max)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	a := &PortAllocator{min: min, max: max, db: db, reserved: map[int]*Reservation{}}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(portsBucket)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			r := &Reservation{}
+			if err := json.Unmarshal(v, r); err != nil {
+				return err
+			}
+			a.reserved[r.Port] = r
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// free checks whether the port can be bound on the host right now.
+func free(port int) bool {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
+// save persists the given reservations and deletes the given ports - must be called with the mutex held.
+func (a *PortAllocator) save(reservations []*Reservation, deleted []int) error {
+	return a.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(portsBucket)
+		for _, r := range reservations {
+			v, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(strconv.Itoa(r.Port)), v); err != nil {
+				return err
+			}
+		}
+		for _, port := range deleted {
+			if err := b.Delete([]byte(strconv.Itoa(port))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Reserve reserves count ports for the owner. Returns ErrNoPortsAvailable if the range is exhausted.
+func (a *PortAllocator) Reserve(owner string, count int) ([]int, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	reservations := []*Reservation{}
+	ports := []int{}
+	for port := a.min; port <= a.max && len(ports) < count; port++ {
+		// Ports used by other processes on the host are skipped too
+		if _, ok := a.reserved[port]; ok || !free(port) {
+			continue
+		}
+		reservations = append(reservations, &Reservation{Port: port, Owner: owner})
+		ports = append(ports, port)
+	}
+	if len(ports) < count {
+		log.WithField("owner", owner).WithField("range", fmt.Sprintf("%d-%d", a.min, a.max)).Warn("Port range exhausted")
+		return nil, ErrNoPortsAvailable
+	}
+
+	if err := a.save(reservations, nil); err != nil {
+		return nil, err
+	}
+	for _, r := range reservations {
+		a.reserved[r.Port] = r
+	}
+	log.WithField("owner", owner).WithField("ports", ports).Info("Reserved ports")
+	return ports, nil
+}
+
+// Bind ties the reservations of the given ports to the container with the given id.
+// Ports which are not reserved are ignored.
+func (a *PortAllocator) Bind(ports []int, id string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	bound := []*Reservation{}
+	for _, port := range ports {
+		if r, ok := a.reserved[port]; ok {
+			b := *r
+			b.ID = id
+			bound = append(bound, &b)
+		}
+	}
+	if err := a.save(bound, nil); err != nil {
+		return err
+	}
+	for _, r := range bound {
+		a.reserved[r.Port] = r
+	}
+	return nil
+}
+
+// Release releases the given ports.
+func (a *PortAllocator) Release(ports []int) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if err := a.save(nil, ports); err != nil {
+		return err
+	}
+	for _, port := range ports {
+		delete(a.reserved, port)
+	}
+	log.WithField("ports", ports).Info("Released ports")
+	return nil
+}
+
+// ReleaseContainer releases the ports bound to the container with the given id.
+func (a *PortAllocator) ReleaseContainer(id string) error {
+	ports := []int{}
+	for _, r := range a.Reservations() {
+		if r.ID == id {
+			ports = append(ports, r.Port)
+		}
+	}
+	if len(ports) == 0 {
+		return nil
+	}
+	return a.Release(ports)
+}
+
+// Reservations returns the current reservations ordered by port.
+func (a *PortAllocator) Reservations() []Reservation {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	reservations := []Reservation{}
+	for _, r := range a.reserved {
+		reservations = append(reservations, *r)
+	}
+	sort.Slice(reservations, func(i, j int) bool { return reservations[i].Port < reservations[j].Port })
+	return reservations
+}
+
+var (
+	allocator      *PortAllocator
+	allocatorMutex = &sync.Mutex{}
+)
+
+// parsePortRange parses a range in the form min-max.
+func parsePortRange(r string) (int, int, error) {
+	parts := strings.SplitN(r, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Invalid port range %s - expected min-max", r)
+	}
+	min, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, err
+	}
+	max, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, err
+	}
+	return min, max, nil
+}
+
+// Ports returns the port allocator of the herder given by "port-range" and "ports-db".
+// Ports are released when the container they are bound to dies (or is removed if it was stopped).
+func Ports() (*PortAllocator, error) {
+	allocatorMutex.Lock()
+	defer allocatorMutex.Unlock()
+
+	if allocator != nil {
+		return allocator, nil
+	}
+
+	portRange := viper.GetString("port-range")
+	if portRange == "" {
+		portRange = "40000-49999"
+	}
+	min, max, err := parsePortRange(portRange)
+	if err != nil {
+		return nil, err
+	}
+	path := viper.GetString("ports-db")
+	if path == "" {
+		path = "ports.db"
+	}
+	a, err := NewPortAllocator(path, min, max)
+	if err != nil {
+		log.WithError(err).Error("Could not create port allocator")
+		return nil, err
+	}
+
+	// Release ports of containers which die or are removed - stopped containers keep theirs until they are removed
+	events := Subscribe(OfType(EventDie, EventDestroy))
+	go func() {
+		for event := range events.C {
+			if event.Type == EventDie && kept(event.ID) {
+				continue
+			}
+			if err := a.ReleaseContainer(event.ID); err != nil {
+				log.WithError(err).WithField("name", event.Name).Warn("Could not release ports")
+			}
+		}
+	}()
+
+	allocator = a
+	return allocator, nil
+}
+
+// kept tells whether the container with the given id keeps its ports although it died - because it was stopped
+// on purpose or is already running again.
+func kept(id string) bool {
+	if IsStopped(id) {
+		return true
+	}
+	client, err := GetRuntime()
+	if err != nil {
+		return false
+	}
+	c, err := client.InspectContainer(id)
+	return err == nil && c.State.Running
+}
+
+// ReservePorts reserves count host ports for the container with the given name.
+func ReservePorts(name string, count int) ([]int, error) {
+	a, err := Ports()
+	if err != nil {
+		return nil, err
+	}
+	return a.Reserve(name, count)
+}
+
+// BindPorts ties the reserved ports to the container with the given id.
+func BindPorts(ports []int, id string) error {
+	if len(ports) == 0 {
+		return nil
+	}
+	a, err := Ports()
+	if err != nil {
+		return err
+	}
+	return a.Bind(ports, id)
+}
+
+// ReleasePorts releases the given reserved ports, e.g. if the container could not be created.
+func ReleasePorts(ports []int) {
+	if len(ports) == 0 {
+		return
+	}
+	a, err := Ports()
+	if err != nil {
+		return
+	}
+	if err := a.Release(ports); err != nil {
+		log.WithError(err).WithField("ports", ports).Warn("Could not release ports")
+	}
+}
+
+// ReconcilePorts releases the reservations whose container is gone, e.g. because
+// it was removed while the herder was down. Returns the released ports.
+func ReconcilePorts() ([]int, error) {
+	a, err := Ports()
+	if err != nil {
+		return nil, err
+	}
+
+	containers, err := List(nil, func(c *docker.APIContainers) bool { return true }, true)
+	if err != nil {
+		return nil, err
+	}
+	ids := map[string]bool{}
+	for _, c := range containers {
+		ids[c.ID] = true
+	}
+
+	released := []int{}
+	for _, r := range a.Reservations() {
+		if !ids[r.ID] {
+			released = append(released, r.Port)
+		}
+	}
+	if len(released) == 0 {
+		return released, nil
+	}
+	return released, a.Release(released)
+}
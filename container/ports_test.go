@@ -0,0 +1,160 @@
+package container
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// reservedBy returns the ports reserved by the allocator for the owner.
+func reservedBy(a *PortAllocator, owner string) []int {
+	ports := []int{}
+	for _, r := range a.Reservations() {
+		if r.Owner == owner {
+			ports = append(ports, r.Port)
+		}
+	}
+	return ports
+}
+
+func TestPortsReleasedAfterFallingBehind(t *testing.T) {
+	SetRuntime(NewFakeRuntime())
+	viper.Set("ports-db", filepath.Join(t.TempDir(), "ports.db"))
+	defer viper.Set("ports-db", "")
+	a, err := Ports()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The allocator falls far behind on the containers being removed
+	a.mutex.Lock()
+	for i := 0; i < 3*pendingWarning; i++ {
+		dispatch(Event{Type: EventDestroy, ID: "flood"})
+	}
+	a.mutex.Unlock()
+
+	ports, err := a.Reserve("released", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Bind(ports, "released-id"); err != nil {
+		t.Fatal(err)
+	}
+	dispatch(Event{Type: EventDestroy, ID: "released-id"})
+	for deadline := time.Now().Add(5 * time.Second); len(reservedBy(a, "released")) > 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("Ports not released after the allocator fell behind")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestPortAllocator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ports.db")
+	a, err := NewPortAllocator(path, 47990, 47992)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := a.Reserve("first", 2)
+	if err != nil || len(first) != 2 {
+		t.Fatalf("Could not reserve 2 ports: %v %v", first, err)
+	}
+	if err := a.Bind(first, "first-id"); err != nil {
+		t.Fatal(err)
+	}
+	// The range is exhausted
+	if ports, err := a.Reserve("second", 2); err != ErrNoPortsAvailable {
+		t.Errorf("Reserved %v beyond the range (%v)", ports, err)
+	}
+	second, err := a.Reserve("second", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ports, err := a.Reserve("third", 1); err != ErrNoPortsAvailable {
+		t.Errorf("Reserved %v beyond the range (%v)", ports, err)
+	}
+
+	// The reservations are kept when the allocator is reopened
+	a.db.Close()
+	if a, err = NewPortAllocator(path, 47990, 47992); err != nil {
+		t.Fatal(err)
+	}
+	defer a.db.Close()
+	if reserved := reservedBy(a, "first"); len(reserved) != 2 || reserved[0] != first[0] || reserved[1] != first[1] {
+		t.Errorf("Reservations of first after reopening: %v - expected %v", reserved, first)
+	}
+	for _, r := range a.Reservations() {
+		if r.Owner == "first" && r.ID != "first-id" {
+			t.Errorf("Reservation of port %d bound to %q after reopening", r.Port, r.ID)
+		}
+	}
+	if ports, err := a.Reserve("third", 1); err != ErrNoPortsAvailable {
+		t.Errorf("Reserved %v beyond the range after reopening (%v)", ports, err)
+	}
+
+	// Released ports can be reserved again
+	if err := a.Release(second); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.ReleaseContainer("first-id"); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Reservations()) != 0 {
+		t.Errorf("Ports still reserved after release: %v", a.Reservations())
+	}
+	if ports, err := a.Reserve("third", 3); err != nil || len(ports) != 3 {
+		t.Errorf("Could not reserve released ports: %v %v", ports, err)
+	}
+}
+
+func TestPortsReleasedWhenContainersDie(t *testing.T) {
+	SetRuntime(NewFakeRuntime())
+	viper.Set("ports-db", filepath.Join(t.TempDir(), "ports.db"))
+	defer viper.Set("ports-db", "")
+	a, err := Ports()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, owner := range []string{"died", "stopped"} {
+		ports, err := a.Reserve(owner, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := a.Bind(ports, owner+"-id"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stoppedMutex.Lock()
+	stopped["stopped-id"] = true
+	stoppedMutex.Unlock()
+	defer func() {
+		stoppedMutex.Lock()
+		delete(stopped, "stopped-id")
+		stoppedMutex.Unlock()
+	}()
+
+	// Events are handled in order - so the stopped container is handled once the other is released
+	dispatch(Event{Type: EventDie, ID: "stopped-id"})
+	dispatch(Event{Type: EventDie, ID: "died-id"})
+	for deadline := time.Now().Add(5 * time.Second); len(reservedBy(a, "died")) > 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("Ports not released when the container died")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(reservedBy(a, "stopped")) != 1 {
+		t.Error("Ports released when the stopped container died")
+	}
+
+	dispatch(Event{Type: EventDestroy, ID: "stopped-id"})
+	for deadline := time.Now().Add(5 * time.Second); len(reservedBy(a, "stopped")) > 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("Ports not released when the stopped container was removed")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,209 @@
+package container
+
+import (
+	"fmt"
+
+	units "github.com/docker/go-units"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/spf13/viper"
+)
+
+// Resources describes the limits put on a container. Zero values mean no limit.
+// Resource classes are defined in the config under "resource-classes", e.g.
+//
+//	resource-classes:
+//	  small:
+//	    memory: 256m
+//	    cpu-shares: 512
+//	    pids-limit: 100
+//	    ulimits: ["nofile=1024:2048"]
+type Resources struct {
+	// Memory is the memory limit in human readable form (e.g. 512m)
+	Memory string `json:"memory,omitempty" mapstructure:"memory"`
+	// CPUShares is the relative cpu weight of the container
+	CPUShares int64 `json:"cpu-shares,omitempty" mapstructure:"cpu-shares"`
+	// CPUQuota is the cpu time (in microseconds) the container may use pr. CPUPeriod
+	CPUQuota int64 `json:"cpu-quota,omitempty" mapstructure:"cpu-quota"`
+	// CPUPeriod is the cfs period in microseconds
+	CPUPeriod int64 `json:"cpu-period,omitempty" mapstructure:"cpu-period"`
+	// PidsLimit is the max amount of processes in the container
+	PidsLimit int64 `json:"pids-limit,omitempty" mapstructure:"pids-limit"`
+	// Disk is the size of the writable layer (only supported by some storage drivers)
+	Disk string `json:"disk,omitempty" mapstructure:"disk"`
+	// Ulimits in the format of docker run --ulimit, e.g. nofile=1024:2048
+	Ulimits []string `json:"ulimits,omitempty" mapstructure:"ulimits"`
+}
+
+// ResourceClass returns the resources of the class with the given name.
+// An empty name gives no resources (i.e. no limits).
+func ResourceClass(name string) (*Resources, error) {
+	if name == "" {
+		return nil, nil
+	}
+	key := fmt.Sprintf("resource-classes.%s", name)
+	if !viper.IsSet(key) {
+		return nil, fmt.Errorf("Unknown resource class: %s", name)
+	}
+	r := &Resources{}
+	if err := viper.UnmarshalKey(key, r); err != nil {
+		return nil, err
+	}
+	if _, err := r.hostConfig(); err != nil {
+		return nil, fmt.Errorf("Invalid resource class %s: %v", name, err)
+	}
+	return r, nil
+}
+
+// hostConfig returns a host config with only the resource limits set.
+func (r *Resources) hostConfig() (*docker.HostConfig, error) {
+	hc := &docker.HostConfig{}
+	if r == nil {
+		return hc, nil
+	}
+	if r.Memory != "" {
+		memory, err := units.RAMInBytes(r.Memory)
+		if err != nil {
+			return nil, err
+		}
+		hc.Memory = memory
+		// Disallow swap, the container gets what is given
+		hc.MemorySwap = memory
+	}
+	hc.CPUShares = r.CPUShares
+	hc.CPUQuota = r.CPUQuota
+	hc.CPUPeriod = r.CPUPeriod
+	if r.PidsLimit > 0 {
+		pids := r.PidsLimit
+		hc.PidsLimit = &pids
+	}
+	if r.Disk != "" {
+		if _, err := units.FromHumanSize(r.Disk); err != nil {
+			return nil, err
+		}
+		hc.StorageOpt = map[string]string{"size": r.Disk}
+	}
+	for _, u := range r.Ulimits {
+		ulimit, err := units.ParseUlimit(u)
+		if err != nil {
+			return nil, err
+		}
+		hc.Ulimits = append(hc.Ulimits, docker.ULimit{Name: ulimit.Name, Soft: ulimit.Soft, Hard: ulimit.Hard})
+	}
+	return hc, nil
+}
+
+// Apply sets the resource limits on the given host config.
+func (r *Resources) Apply(hc *docker.HostConfig) error {
+	limits, err := r.hostConfig()
+	if err != nil {
+		return err
+	}
+	hc.Memory = limits.Memory
+	hc.MemorySwap = limits.MemorySwap
+	hc.CPUShares = limits.CPUShares
+	hc.CPUQuota = limits.CPUQuota
+	hc.CPUPeriod = limits.CPUPeriod
+	hc.PidsLimit = limits.PidsLimit
+	hc.StorageOpt = limits.StorageOpt
+	hc.Ulimits = limits.Ulimits
+	return nil
+}
+
+// capInt returns requested capped by max - a zero (unlimited) request is capped too.
+func capInt(requested, max int64) int64 {
+	if max > 0 && (requested <= 0 || requested > max) {
+		return max
+	}
+	return requested
+}
+
+// defaultCFSPeriod is the cfs period (in microseconds) docker uses when none is given
+const defaultCFSPeriod = 100000
+
+// cfsPeriod returns the given cfs period - or the default period if none is given.
+func cfsPeriod(period int64) int64 {
+	if period <= 0 {
+		return defaultCFSPeriod
+	}
+	return period
+}
+
+// Cap returns the requested resources limited by max.
+func (r *Resources) Cap(max *Resources) (*Resources, error) {
+	if max == nil {
+		return r, nil
+	}
+	if r == nil {
+		r = &Resources{}
+	}
+
+	requested, err := r.hostConfig()
+	if err != nil {
+		return nil, err
+	}
+	limits, err := max.hostConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	capped := &Resources{
+		Memory:    r.Memory,
+		CPUShares: capInt(r.CPUShares, max.CPUShares),
+		CPUQuota:  r.CPUQuota,
+		CPUPeriod: r.CPUPeriod,
+		PidsLimit: capInt(r.PidsLimit, max.PidsLimit),
+		Disk:      r.Disk,
+	}
+	if capInt(requested.Memory, limits.Memory) != requested.Memory {
+		capped.Memory = max.Memory
+	}
+	// The cpus a container may use is the ratio of quota to period - a shorter period does not give more cpus
+	if max.CPUQuota > 0 {
+		period, maxPeriod := cfsPeriod(r.CPUPeriod), cfsPeriod(max.CPUPeriod)
+		if r.CPUQuota <= 0 || r.CPUQuota*maxPeriod > max.CPUQuota*period {
+			capped.CPUQuota = max.CPUQuota
+			capped.CPUPeriod = max.CPUPeriod
+		}
+	}
+	if max.Disk != "" {
+		requestedDisk, _ := units.FromHumanSize(r.Disk)
+		maxDisk, _ := units.FromHumanSize(max.Disk)
+		if capInt(requestedDisk, maxDisk) != requestedDisk {
+			capped.Disk = max.Disk
+		}
+	}
+
+	// Ulimits are capped by name, ulimits not given in max are left as requested
+	maxUlimits := map[string]docker.ULimit{}
+	for _, u := range limits.Ulimits {
+		maxUlimits[u.Name] = u
+	}
+	for i, u := range requested.Ulimits {
+		if m, ok := maxUlimits[u.Name]; ok {
+			delete(maxUlimits, u.Name)
+			if u.Soft > m.Soft || u.Hard > m.Hard {
+				capped.Ulimits = append(capped.Ulimits, fmt.Sprintf("%s=%d:%d", u.Name, capInt(u.Soft, m.Soft), capInt(u.Hard, m.Hard)))
+				continue
+			}
+		}
+		capped.Ulimits = append(capped.Ulimits, r.Ulimits[i])
+	}
+	for _, u := range max.Ulimits {
+		if ulimit, err := units.ParseUlimit(u); err == nil {
+			if _, ok := maxUlimits[ulimit.Name]; ok {
+				capped.Ulimits = append(capped.Ulimits, u)
+			}
+		}
+	}
+
+	return capped, nil
+}
+
+// Exceeded returns the limits which the container exceeded given its final state.
+func Exceeded(state docker.State) []string {
+	exceeded := []string{}
+	if state.OOMKilled {
+		exceeded = append(exceeded, "memory")
+	}
+	return exceeded
+}
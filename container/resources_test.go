@@ -0,0 +1,38 @@
+package container
+
+import "testing"
+
+func TestCapCPU(t *testing.T) {
+	max := &Resources{CPUQuota: 50000, CPUPeriod: 100000}
+	for _, test := range []struct {
+		quota, period             int64
+		cappedQuota, cappedPeriod int64
+	}{
+		// Unlimited requests get the max
+		{0, 0, 50000, 100000},
+		// Half a cpu is allowed - also with another period
+		{50000, 100000, 50000, 100000},
+		{25000, 50000, 25000, 50000},
+		// A shorter period does not give more cpus
+		{40000, 1000, 50000, 100000},
+		{50000, 0, 50000, 0},
+		// Less is fine
+		{10000, 0, 10000, 0},
+	} {
+		r := &Resources{CPUQuota: test.quota, CPUPeriod: test.period}
+		capped, err := r.Cap(max)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if capped.CPUQuota != test.cappedQuota || capped.CPUPeriod != test.cappedPeriod {
+			t.Errorf("%d/%d capped to %d/%d - expected %d/%d", test.quota, test.period,
+				capped.CPUQuota, capped.CPUPeriod, test.cappedQuota, test.cappedPeriod)
+		}
+	}
+
+	// The max period defaults to that of docker
+	capped, _ := (&Resources{CPUQuota: 40000, CPUPeriod: 1000}).Cap(&Resources{CPUQuota: 50000})
+	if capped.CPUQuota != 50000 || capped.CPUPeriod != 0 {
+		t.Errorf("40000/1000 capped to %d/%d - expected 50000/0", capped.CPUQuota, capped.CPUPeriod)
+	}
+}
@@ -0,0 +1,75 @@
+package container
+
+import (
+	"context"
+	"sync"
+
+	docker "github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+// Runtime is the container engine used by the herder. It covers the part of
+// the docker API that the herder relies on. *docker.Client satisfies it, so
+// does the in-memory FakeRuntime.
+type Runtime interface {
+	PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error
+	InspectImage(name string) (*docker.Image, error)
+	ListImages(opts docker.ListImagesOptions) ([]docker.APIImages, error)
+	RemoveImage(name string) error
+	CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error)
+	StartContainer(id string, hostConfig *docker.HostConfig) error
+	KillContainer(opts docker.KillContainerOptions) error
+	StopContainer(id string, timeout uint) error
+	RemoveContainer(opts docker.RemoveContainerOptions) error
+	InspectContainer(id string) (*docker.Container, error)
+	ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error)
+	Logs(opts docker.LogsOptions) error
+	AttachToContainerNonBlocking(opts docker.AttachToContainerOptions) (docker.CloseWaiter, error)
+	WaitContainerWithContext(id string, ctx context.Context) (int, error)
+	CreateExec(opts docker.CreateExecOptions) (*docker.Exec, error)
+	StartExecNonBlocking(id string, opts docker.StartExecOptions) (docker.CloseWaiter, error)
+	ResizeExecTTY(id string, height, width int) error
+	InspectExec(id string) (*docker.ExecInspect, error)
+	AddEventListener(listener chan<- *docker.APIEvents) error
+	RemoveEventListener(listener chan *docker.APIEvents) error
+}
+
+var (
+	runtime      Runtime
+	runtimeMutex = &sync.Mutex{}
+)
+
+// NewDockerRuntime returns a Runtime backed by the docker daemon given by the environment.
+func NewDockerRuntime() (Runtime, error) {
+	client, err := docker.NewClientFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// SetRuntime sets the runtime used by this and dependent packages.
+// Use it to e.g. swap in a FakeRuntime.
+func SetRuntime(r Runtime) {
+	runtimeMutex.Lock()
+	defer runtimeMutex.Unlock()
+	runtime = r
+}
+
+// GetRuntime returns the current runtime. If none has been set a docker runtime is created from the environment.
+func GetRuntime() (Runtime, error) {
+	runtimeMutex.Lock()
+	defer runtimeMutex.Unlock()
+
+	if runtime != nil {
+		return runtime, nil
+	}
+
+	r, err := NewDockerRuntime()
+	if err != nil {
+		log.WithError(err).Error("Could not create docker client")
+		return nil, err
+	}
+	runtime = r
+	return runtime, nil
+}
@@ -4,8 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Webstrates/golem-herder/container"
@@ -15,6 +18,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 )
 
 var (
@@ -27,23 +31,57 @@ var (
 )
 
 // Options contains configuration options for the daemon spawn.
+// Access is the access policy of the reverse proxy to the daemon (public, owner or shared) - owner if empty.
 type Options struct {
-	Meter   *metering.Meter
-	Restart bool
-	Ports   []int
-	Files   map[string][]byte
-	StdOut  chan []byte
-	StdErr  chan []byte
-	Done    chan bool
+	Meter     *metering.Meter
+	Restart   bool
+	Ports     []int
+	Files     map[string][]byte
+	Resources *container.Resources
+	Access    string
+	StdOut    chan []byte
+	StdErr    chan []byte
+	Done      chan bool
 }
 
-// Info is information about a running deamon.
+// Info is information about a deamon. Ports maps the ports inside the daemon to the ports on the host.
 type Info struct {
-	Name    string
-	Address string
-	Ports   map[int]int
+	Name      string
+	Address   string
+	Ports     map[int]int
+	Resources *container.Resources `json:",omitempty"`
+	Image     string               `json:",omitempty"`
+	// State is running, stopped or the state of the container of a daemon which is no longer running
+	State string `json:",omitempty"`
+	// Uptime is the number of seconds since the daemon was (re)started
+	Uptime int64 `json:",omitempty"`
+	// Proxy is the url of the reverse proxy to the first port of the daemon
+	Proxy string `json:",omitempty"`
+	// Consumed is the number of credits charged for the daemon so far
+	Consumed int `json:",omitempty"`
+	// TokenID is the id (jti) of the token which spawned the daemon
+	TokenID string `json:",omitempty"`
 }
 
+// Status is the status of a daemon.
+type Status struct {
+	Name      string
+	Running   bool
+	Stopped   bool                 `json:",omitempty"`
+	ExitCode  int                  `json:",omitempty"`
+	Exceeded  []string             `json:",omitempty"`
+	Resources *container.Resources `json:",omitempty"`
+	subject   string
+}
+
+var (
+	// exits keeps the status of daemons which are no longer running
+	exits = map[string]*Status{}
+	// exited contains channels which are closed when the daemon with the given name has exited
+	exited     = map[string]chan bool{}
+	exitsMutex = &sync.Mutex{}
+)
+
 // Spawn a daemon with the given options.
 func Spawn(token *jwt.Token, name, image string, options Options) (*Info, error) {
 
@@ -57,56 +95,156 @@ func Spawn(token *jwt.Token, name, image string, options Options) (*Info, error)
 	// Change the unique name generation if you want to e.g. restrict to one container of each kind
 	uname := fmt.Sprintf("%s-%v", name, claims["jti"])
 
-	// Get random outside ports
+	// Reserve outside ports
+	reserved, err := container.ReservePorts(uname, len(options.Ports))
+	if err != nil {
+		log.WithError(err).WithField("name", uname).Error("Could not reserve ports for daemon")
+		return nil, err
+	}
 	ports := map[int]int{}
 	invertedPorts := map[int]int{}
-	for _, insidePort := range options.Ports {
-		outsidePort := container.GetAvailableHostPort()
-		ports[outsidePort] = insidePort
-		invertedPorts[insidePort] = outsidePort
+	for i, insidePort := range options.Ports {
+		ports[reserved[i]] = insidePort
+		invertedPorts[insidePort] = reserved[i]
 	}
 
-	// Labels for container
+	// Labels for container - the token itself is not kept as labels are readable by anyone listing containers
 	labels := map[string]string{
 		"subject": claims["sub"].(string),
-		"token":   token.Raw,
 		"tokenid": fmt.Sprintf("%v", claims["jti"]),
+		"image":   image,
+		"access":  options.Access,
+	}
+	if len(options.Ports) > 0 {
+		labels["port"] = strconv.Itoa(options.Ports[0])
 	}
 
-	done := make(chan bool, 5) // does not need to be synchronized
-	c, err := container.RunDaemonized(uname, image, "latest", ports, options.Files, labels, options.Restart, options.StdOut, options.StdErr, done)
+	exitsMutex.Lock()
+	delete(exits, uname)
+	exitsMutex.Unlock()
+	resetConsumed(uname)
+
+	// Charge the meter while the daemon runs
+	meter(uname, options.Meter)
+
+	// Only public daemons can be reached around the proxy and its access policy
+	hostIP := "127.0.0.1"
+	if options.Access == AccessPublic {
+		hostIP = ""
+	}
+
+	done := make(chan docker.State, 5) // does not need to be synchronized
+	c, err := container.RunDaemonized(uname, image, "latest", ports, hostIP, options.Files, labels, options.Resources, options.Restart, options.StdOut, options.StdErr, done)
 	if err != nil {
+		unmeter(uname)
+		container.ReleasePorts(reserved)
 		return nil, err
 	}
 
+	// Keep the logs around for when the daemon is gone
+	go retainLogs(uname, claims["sub"].(string), c.ID, true)
+
 	go func() {
+		state := <-done
+		unmeter(uname)
+		recordExit(uname, claims["sub"].(string), options.Resources, state)
 		// Let someone else now that we're done
-		defer func() {
-			options.Done <- true
-		}()
-		for {
-			t0 := time.Now().UnixNano()
-			select {
-			case <-time.After(time.Second):
-				ms := (time.Now().UnixNano() - t0) / 1e9
-				if err := options.Meter.Record(int(ms)); err != nil {
-					log.WithError(err).Warn("Could not record time spent - kill and exit")
-					if err := container.Kill(container.WithName(uname), false, false); err != nil {
-						log.WithError(err).Warn("Error killing container")
-					}
-					return
-				}
-			case <-done:
-				return
+		options.Done <- true
+	}()
+
+	return &Info{Address: c.NetworkSettings.Networks["bridge"].IPAddress, Name: uname, Ports: invertedPorts, Resources: options.Resources}, nil
+}
+
+// recordExit keeps the final state of a daemon for later status requests.
+func recordExit(name, subject string, resources *container.Resources, state docker.State) {
+	exceeded := container.Exceeded(state)
+	if len(exceeded) > 0 {
+		log.WithField("name", name).WithField("exceeded", exceeded).Warn("Daemon exceeded its resource limits")
+	}
+	exitsMutex.Lock()
+	defer exitsMutex.Unlock()
+	exits[name] = &Status{
+		Name:      name,
+		ExitCode:  state.ExitCode,
+		Exceeded:  exceeded,
+		Resources: resources,
+		subject:   subject,
+	}
+	if c, ok := exited[name]; ok {
+		close(c)
+		delete(exited, name)
+	}
+}
+
+// GetStatus returns the status of the daemon with the given name iff it is owned by the owner of the token.
+func GetStatus(token *jwt.Token, name string) (*Status, error) {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("Could not extract claims from token")
+	}
+	subject := claims["sub"].(string)
+
+	predicate := container.And(container.WithName(name), container.WithLabel("subject", subject))
+	cs, err := container.List(nil, predicate, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(cs) == 1 {
+		return &Status{Name: name, Running: true, subject: subject}, nil
+	}
+
+	exitsMutex.Lock()
+	defer exitsMutex.Unlock()
+	status, ok := exits[name]
+	if ok && status.subject != subject {
+		ok = false
+	}
+
+	// Stopped daemons keep their container
+	if isStopped(name) {
+		if cs, err := container.List(nil, predicate, true); err == nil && len(cs) == 1 {
+			stopped := Status{Name: name, subject: subject}
+			if ok {
+				stopped = *status
 			}
+			stopped.Stopped = true
+			return &stopped, nil
 		}
-	}()
+	}
+
+	if ok {
+		return status, nil
+	}
+	return nil, fmt.Errorf("Could not find daemon with name: %s", name)
+}
+
+// resourcesFor returns the resources requested in the given form value capped by the "daemon-max-resources" class.
+// The requested value is either a resource class name or a json object with the resources.
+func resourcesFor(requested string) (*container.Resources, error) {
+	var resources *container.Resources
+	var err error
+	switch {
+	case requested == "":
+		resources, err = container.ResourceClass(viper.GetString("daemon-resources"))
+	case strings.HasPrefix(requested, "{"):
+		resources = &container.Resources{}
+		err = json.Unmarshal([]byte(requested), resources)
+	default:
+		resources, err = container.ResourceClass(requested)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	return &Info{Address: c.NetworkSettings.Networks["bridge"].IPAddress, Name: uname, Ports: invertedPorts}, nil
+	max, err := container.ResourceClass(viper.GetString("daemon-max-resources"))
+	if err != nil {
+		return nil, err
+	}
+	return resources.Cap(max)
 }
 
 // Attach will attach to an already running daemon and forward stdout/err and allow for stdin
-func Attach(token *jwt.Token, name string, in <-chan []byte, out chan<- []byte) error {
+func Attach(token *jwt.Token, name string, in <-chan []byte, stdout, stderr chan<- []byte) error {
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
 		return fmt.Errorf("Could not extract claims from token")
@@ -122,10 +260,17 @@ func Attach(token *jwt.Token, name string, in <-chan []byte, out chan<- []byte)
 	c := cs[0]
 
 	// io, io, io
-	return container.Attach(c, out, out, in)
+	return container.Attach(c, stdout, stderr, in)
+}
+
+// Frame is output from an attached daemon sent on the websocket when framing is requested.
+type Frame struct {
+	Stream container.Stream
+	Data   string
 }
 
-// AttachHandler handles attach requests
+// AttachHandler handles attach requests. Output is sent as is unless framed=true is given,
+// then each message is a json Frame telling which stream the output is from.
 func AttachHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
 	vars := mux.Vars(r)
 	name, ok := vars["name"]
@@ -134,6 +279,7 @@ func AttachHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
 		http.Error(w, "No name given", 404)
 		return
 	}
+	framed := r.URL.Query().Get("framed") == "true"
 	log.WithField("name", name).Info("Attaching")
 	// Upgrade HTTP connection to WebSocket
 	conn, err := upgrader.Upgrade(w, r, nil)
@@ -144,7 +290,8 @@ func AttachHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
 
 	// Connect websocket and channel
 	in := make(chan []byte)
-	out := make(chan []byte)
+	stdout := make(chan []byte)
+	stderr := make(chan []byte)
 
 	// -- from websocket -> stdin
 	go func() {
@@ -161,8 +308,22 @@ func AttachHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
 
 	// -- from stdout/err -> websocket
 	go func() {
-		for line := range out {
-			err := conn.WriteMessage(websocket.TextMessage, line)
+		for {
+			var data []byte
+			frame := Frame{}
+			select {
+			case data = <-stdout:
+				frame.Stream = container.Stdout
+			case data = <-stderr:
+				frame.Stream = container.Stderr
+			}
+			var err error
+			if framed {
+				frame.Data = string(data)
+				err = conn.WriteJSON(frame)
+			} else {
+				err = conn.WriteMessage(websocket.TextMessage, data)
+			}
 			if err != nil {
 				log.WithError(err).WithField("container", name).Warn("Error writing to stdout-websocket")
 				return
@@ -170,58 +331,135 @@ func AttachHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
 		}
 	}()
 
-	if err := Attach(token, name, in, out); err != nil {
-		log.WithError(err).Warn("Could not attach to %s", name)
+	if err := Attach(token, name, in, stdout, stderr); err != nil {
+		log.WithError(err).Warnf("Could not attach to %s", name)
 		conn.Close()
 	}
 }
 
-// List the daemons running on this token.
-func List(token *jwt.Token) ([]docker.APIContainers, error) {
+// List the daemons of the owner of the token. The daemons are filtered by state (running, stopped or
+// the state of their container) and name (a pattern as in path.Match) unless these are empty.
+func List(token *jwt.Token, state, name string) ([]Info, error) {
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
 		return nil, fmt.Errorf("Could not extract claims from token")
 	}
-	return container.List(nil, container.WithLabel("subject", claims["sub"].(string)), true)
+	if _, err := path.Match(name, ""); err != nil {
+		return nil, fmt.Errorf("Invalid name pattern: %s", name)
+	}
+	containers, err := container.List(nil, container.And(container.WithLabel("subject", claims["sub"].(string)), container.HasLabel("tokenid")), true)
+	if err != nil {
+		return nil, err
+	}
+	client, err := container.GetRuntime()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []Info{}
+	for _, c := range containers {
+		info := Info{
+			Name:     strings.TrimPrefix(c.Names[0], "/"),
+			Ports:    map[int]int{},
+			Image:    c.Labels["image"],
+			State:    c.State,
+			TokenID:  c.Labels["tokenid"],
+			Consumed: consumption(strings.TrimPrefix(c.Names[0], "/")),
+		}
+		if info.Image == "" {
+			info.Image = c.Image
+		}
+		if c.State != "running" && isStopped(info.Name) {
+			info.State = "stopped"
+		}
+		if state != "" && info.State != state {
+			continue
+		}
+		if matched, _ := path.Match(name, info.Name); name != "" && !matched {
+			continue
+		}
+
+		if network, ok := c.Networks.Networks["bridge"]; ok {
+			info.Address = network.IPAddress
+		}
+		for _, port := range c.Ports {
+			if port.PublicPort != 0 {
+				info.Ports[int(port.PrivatePort)] = int(port.PublicPort)
+			}
+		}
+		if c.State == "running" {
+			if inspected, err := client.InspectContainer(c.ID); err == nil {
+				info.Uptime = int64(time.Since(inspected.State.StartedAt).Seconds())
+			}
+		}
+		exitsMutex.Lock()
+		if status, ok := exits[info.Name]; ok {
+			info.Resources = status.Resources
+		}
+		exitsMutex.Unlock()
+		infos = append(infos, info)
+	}
+	return infos, nil
 }
 
-// SpawnHandler handles spawn requests
-func SpawnHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
-	// Read name, image
-	name := r.FormValue("name")
-	image := r.FormValue("image")
-	ps := r.FormValue("ports")
+// proxyURL returns the url of the reverse proxy to the daemon with the given name as seen by the client making the request.
+func proxyURL(r *http.Request, name string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if forwarded := r.Header.Get("X-Forwarded-Proto"); forwarded != "" {
+		scheme = forwarded
+	}
+	return fmt.Sprintf("%s://%s/daemon/v1/proxy/%s", scheme, r.Host, name)
+}
 
-	var ports []int
-	if err := json.Unmarshal([]byte(ps), &ports); err != nil {
-		http.Error(w, "Could not unmarshal ports - "+err.Error(), 400)
-		return
+// SpawnRequest is a request to spawn a daemon. Resources is the name of a resource class and Access the access
+// policy of the reverse proxy to the daemon (owner if empty).
+type SpawnRequest struct {
+	Name      string
+	Image     string
+	Ports     []int
+	Files     map[string][]byte
+	Resources string
+	Access    string
+}
+
+// validate checks the request and returns the resources it asks for.
+func (s *SpawnRequest) validate() (*container.Resources, error) {
+	resources, err := resourcesFor(s.Resources)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid resources - %v", err)
+	}
+	if s.Access == "" {
+		s.Access = AccessOwner
+	}
+	if s.Access != AccessPublic && s.Access != AccessOwner && s.Access != AccessShared {
+		return nil, fmt.Errorf("Invalid access - must be public, owner or shared")
 	}
+	return resources, nil
+}
 
-	// Consider rest of form values as files
-	files := map[string][]byte{}
-	for key, values := range r.Form {
-		if key != "name" && key != "image" && key != "ports" && key != "token" && len(values) > 0 {
-			files[key] = []byte(values[0])
-		}
+// SpawnMetered spawns the daemon of the request - charging the credits of the token while it runs.
+func SpawnMetered(token *jwt.Token, request SpawnRequest) (*Info, error) {
+	resources, err := request.validate()
+	if err != nil {
+		return nil, err
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		http.Error(w, "Could extract claims from token", 500)
-		return
+		return nil, fmt.Errorf("Could extract claims from token")
 	}
 
 	crd, ok := claims["crd"].(float64)
 	if !ok {
-		http.Error(w, "Could not extract \"crd\" (credits) from token", 500)
-		return
+		return nil, fmt.Errorf("Could not extract \"crd\" (credits) from token")
 	}
 
 	exp, ok := claims["exp"].(float64)
 	if !ok {
-		http.Error(w, "Could not extract \"exp\" (expiration) from token", 500)
-		return
+		return nil, fmt.Errorf("Could not extract \"exp\" (expiration) from token")
 	}
 
 	// Construct meter - one meter pr sub(ject) aka email
@@ -232,36 +470,77 @@ func SpawnHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
 
 	m, err := metering.NewMeter(subject, tokenID, expiration, credits)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
+		return nil, err
 	}
 
 	// Check if meter has resources before spawning
 	if credits, err := m.Credits(); err != nil || credits <= 0 {
-		http.Error(w, "Not even running on fumes", 402 /* Payment required */)
-		return
+		return nil, ErrNoCredits
 	}
 
 	done := make(chan bool)
 
+	name := request.Name
 	go func() {
 		<-done
 		log.WithField("name", name).Info("Daemon is now done")
 	}()
 
 	options := Options{
-		Meter:   m,
-		Restart: true,
-		Ports:   ports,
-		Files:   files,
-		StdOut:  nil,
-		StdErr:  nil,
-		Done:    done,
+		Meter:     m,
+		Restart:   true,
+		Ports:     request.Ports,
+		Files:     request.Files,
+		Resources: resources,
+		Access:    request.Access,
+		StdOut:    nil,
+		StdErr:    nil,
+		Done:      done,
 	}
 
 	// TODO support content in similar fashion to lambdaed minions
-	info, err := Spawn(token, name, image, options)
+	return Spawn(token, request.Name, request.Image, options)
+}
+
+// SpawnHandler handles spawn requests
+func SpawnHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
+	// Read name, image
+	request := SpawnRequest{
+		Name:      r.FormValue("name"),
+		Image:     r.FormValue("image"),
+		Resources: r.FormValue("resources"),
+		Access:    r.FormValue("access"),
+	}
+	ps := r.FormValue("ports")
+
+	if err := json.Unmarshal([]byte(ps), &request.Ports); err != nil {
+		http.Error(w, "Could not unmarshal ports - "+err.Error(), 400)
+		return
+	}
+
+	if _, err := request.validate(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	// Consider rest of form values as files
+	request.Files = map[string][]byte{}
+	for key, values := range r.Form {
+		if key != "name" && key != "image" && key != "ports" && key != "resources" && key != "access" && key != "token" && len(values) > 0 {
+			request.Files[key] = []byte(values[0])
+		}
+	}
+
+	info, err := SpawnMetered(token, request)
 	if err != nil {
+		if err == ErrNoCredits {
+			http.Error(w, "Not even running on fumes", 402 /* Payment required */)
+			return
+		}
+		if err == container.ErrNoPortsAvailable {
+			http.Error(w, err.Error(), 503 /* Service Unavailable */)
+			return
+		}
 		http.Error(w, err.Error(), 500)
 		return
 	}
@@ -283,89 +562,81 @@ func Kill(name string, wipe bool, token *jwt.Token) error {
 		return fmt.Errorf("Could extract claims from token")
 	}
 	subject := claims["sub"].(string)
-	containers, err := container.List(nil, container.And(container.WithName(name), container.WithLabel("subject", subject)), false)
+	containers, err := container.List(nil, container.And(container.WithName(name), container.WithLabel("subject", subject)), true)
 	if err != nil {
 		return err
 	}
 	if len(containers) != 1 {
 		return fmt.Errorf("Could not find container to kill")
 	}
+	defer os.Remove(stoppedFile(name))
+	if wipe {
+		defer forgetLogs(name)
+	}
+
+	// Stopped daemons are just removed
+	if containers[0].State != "running" {
+		return container.Remove(containers[0].ID, wipe)
+	}
 	return container.Kill(container.WithID(containers[0].ID), wipe, wipe)
 }
 
-// ListHandler handles list requests
+// ListHandler handles list requests. The daemons can be filtered with the query params state and name.
 func ListHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
-	containers, err := List(token)
+	infos, err := List(token, r.URL.Query().Get("state"), r.URL.Query().Get("name"))
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		http.Error(w, err.Error(), 400 /* Bad request */)
+		return
+	}
+	for i := range infos {
+		if len(infos[i].Ports) > 0 {
+			infos[i].Proxy = proxyURL(r, infos[i].Name)
+		}
 	}
 
-	s, err := json.Marshal(containers)
+	s, err := json.Marshal(infos)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
+		return
 	}
 
 	w.Write(s)
 }
 
-// KillHandler handles kill requests
-func KillHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
+// StatusHandler handles status requests
+func StatusHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
 	vars := mux.Vars(r)
 	name, ok := vars["name"]
 	if !ok {
 		http.Error(w, "No name given", 404)
 		return
 	}
-	err := Kill(name, r.URL.Query().Get("wipe") == "true", token)
+	status, err := GetStatus(token, name)
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+
+	s, err := json.Marshal(status)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
+
+	w.Write(s)
 }
 
-// ProxyHandler proxies requests to daemons
-func ProxyHandler(w http.ResponseWriter, r *http.Request) {
+// KillHandler handles kill requests
+func KillHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
 	vars := mux.Vars(r)
 	name, ok := vars["name"]
 	if !ok {
-		log.Warn("No name given")
 		http.Error(w, "No name given", 404)
 		return
 	}
-
-	predicate := container.And(container.WithName(name), container.WithState("running"))
-	containers, err := container.List(nil, predicate, true)
-	if err != nil {
-		http.Error(w, "Failed to look for container", 500)
-		return
-	}
-
-	if len(containers) != 1 {
-		http.Error(w, "No container found", 404)
-		return
-	}
-
-	container := containers[0]
-	if len(container.Ports) < 1 {
-		http.Error(w, "No exposed ports found", 500)
-		return
-	}
-
-	port := container.Ports[0].PublicPort
-
-	log.WithField("status", container.Status).
-		WithField("ports", container.Ports).
-		WithField("networks", container.Networks).
-		Info("Found container")
-
-	target, err := url.Parse(fmt.Sprintf("http://localhost:%d", port))
+	err := Kill(name, r.URL.Query().Get("wipe") == "true", token)
 	if err != nil {
-		http.Error(w, "Invalid proxy endpoint", 404)
+		http.Error(w, err.Error(), 500)
 		return
 	}
-
-	log.WithField("container", name).Info("Proxy request made")
-
-	proxy := httputil.NewSingleHostReverseProxy(target)
-	proxy.ServeHTTP(w, r)
 }
@@ -0,0 +1,196 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Webstrates/golem-herder/container"
+	"github.com/Webstrates/golem-herder/metering"
+	jwt "github.com/dgrijalva/jwt-go"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/spf13/viper"
+)
+
+// The container watcher and the port allocator live as long as the tests - so the tests share a runtime
+var fake = container.NewFakeRuntime()
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "daemon")
+	if err != nil {
+		panic(err)
+	}
+	viper.Set("ports-db", filepath.Join(dir, "ports.db"))
+	fake.Programs["echo:latest"] = func(ctx context.Context, c *docker.Container, stdin io.Reader, stdout, stderr io.Writer) int {
+		fmt.Fprintln(stdout, "started")
+		<-ctx.Done()
+		return 0
+	}
+	fake.Programs["chatty:latest"] = func(ctx context.Context, c *docker.Container, stdin io.Reader, stdout, stderr io.Writer) int {
+		for i := 1; i <= 5; i++ {
+			fmt.Fprintf(stdout, "out %d\n", i)
+			fmt.Fprintf(stderr, "err %d\n", i)
+		}
+		<-ctx.Done()
+		return 0
+	}
+	container.SetRuntime(fake)
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// setup gives the test its own mounts and meter database.
+func setup(t *testing.T) {
+	dir := t.TempDir()
+	viper.Set("mounts", dir)
+	if err := metering.Open(filepath.Join(dir, "meter.db")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// spawn spawns a daemon running the image for a new token of the subject with the given credits.
+func spawn(t *testing.T, image, subject string, credits int, options Options) (*jwt.Token, *Info) {
+	jti := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	token := &jwt.Token{Claims: jwt.MapClaims{"sub": subject, "jti": jti, "crd": float64(credits)}}
+	m, err := metering.NewMeter(subject, jti, int(time.Now().Add(time.Hour).Unix()), credits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.Meter = m
+	info, err := Spawn(token, image, image, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token, info
+}
+
+// eventually fails unless the condition holds within 5 seconds.
+func eventually(t *testing.T, condition func() bool, format string, args ...interface{}) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !condition(); {
+		if time.Now().After(deadline) {
+			t.Fatalf(format, args...)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// reserved tells whether the port is reserved by the allocator.
+func reserved(t *testing.T, port int) bool {
+	a, err := container.Ports()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range a.Reservations() {
+		if r.Port == port {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSpawn(t *testing.T) {
+	setup(t)
+
+	jti := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	token := &jwt.Token{Claims: jwt.MapClaims{"sub": "me@example.com", "jti": jti, "crd": 10.0}}
+	other := &jwt.Token{Claims: jwt.MapClaims{"sub": "other@example.com", "jti": "other"}}
+	meter, err := metering.NewMeter("me@example.com", jti, int(time.Now().Add(time.Hour).Unix()), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan bool, 1)
+	info, err := Spawn(token, "echo", "echo", Options{Meter: meter, Ports: []int{80}, Files: map[string][]byte{"a.txt": []byte("a")}, Done: done})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "echo-"+jti || info.Address == "" || info.Ports[80] == 0 {
+		t.Errorf("Unexpected info: %+v", info)
+	}
+
+	if status, err := GetStatus(token, info.Name); err != nil || !status.Running {
+		t.Errorf("Daemon not running: %+v %v", status, err)
+	}
+	if _, err := GetStatus(other, info.Name); err == nil {
+		t.Error("Status of daemon given to another owner")
+	}
+	if err := Kill(info.Name, true, other); err == nil {
+		t.Error("Daemon killed by another owner")
+	}
+
+	if err := Kill(info.Name, true, token); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Daemon not done after kill")
+	}
+	if status, err := GetStatus(token, info.Name); err != nil || status.Running {
+		t.Errorf("Daemon still running after kill: %+v %v", status, err)
+	}
+}
+
+func TestMeteringFallingBehind(t *testing.T) {
+	setup(t)
+
+	// A subscriber which never reads - and one which is gone
+	stalled := container.Subscribe(nil)
+	defer stalled.Unsubscribe()
+	container.Subscribe(nil).Unsubscribe()
+	flooded := container.Subscribe(container.All(container.OfLabel("flood"), container.OfType(container.EventDestroy)))
+	defer flooded.Unsubscribe()
+
+	// Metering falls far behind while daemons start, die and are removed
+	meteringOnce.Do(startMetering)
+	metersMutex.Lock()
+	fake.AddImage("flood:latest")
+	n := 600
+	for i := 0; i < n; i++ {
+		c, err := fake.CreateContainer(docker.CreateContainerOptions{
+			Name:   fmt.Sprintf("flood-%d", i),
+			Config: &docker.Config{Image: "flood:latest", Labels: map[string]string{"subject": "flood@example.com", "flood": "true"}},
+		})
+		if err == nil {
+			err = fake.StartContainer(c.ID, nil)
+		}
+		if err == nil {
+			err = fake.RemoveContainer(docker.RemoveContainerOptions{ID: c.ID, Force: true})
+		}
+		if err != nil {
+			metersMutex.Unlock()
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case <-flooded.C:
+		case <-time.After(5 * time.Second):
+			metersMutex.Unlock()
+			t.Fatalf("Got %d of %d events", i, n)
+		}
+	}
+	metersMutex.Unlock()
+
+	// Daemons spawned afterwards are still charged
+	done := make(chan bool, 1)
+	token, info := spawn(t, "echo", "slow@example.com", 100, Options{Ports: []int{80}, Done: done})
+	eventually(t, func() bool { return consumption(info.Name) > 0 }, "Daemon is not charged")
+
+	if err := Kill(info.Name, true, token); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Daemon not done after kill")
+	}
+	eventually(t, func() bool { return !reserved(t, info.Ports[80]) }, "Port of daemon not released")
+}
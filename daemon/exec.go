@@ -0,0 +1,168 @@
+package daemon
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/Webstrates/golem-herder/container"
+	jwt "github.com/dgrijalva/jwt-go"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+// ExecMessage is a message on the exec websocket. Clients send stdin and resize messages,
+// the herder sends stdout and stderr messages and finally an exit (or error) message.
+type ExecMessage struct {
+	// Type is one of stdin, resize, stdout, stderr, exit or error
+	Type string
+	Data string `json:",omitempty"`
+	// Height and Width are the terminal size given in resize messages
+	Height int `json:",omitempty"`
+	Width  int `json:",omitempty"`
+	// ExitCode is given in the exit message
+	ExitCode *int `json:",omitempty"`
+}
+
+// execConn serializes the writes to an exec websocket.
+type execConn struct {
+	conn  *websocket.Conn
+	mutex sync.Mutex
+}
+
+func (c *execConn) send(message ExecMessage) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.conn.WriteJSON(message)
+}
+
+// execWriter sends what is written to it as messages of the given type.
+type execWriter struct {
+	conn *execConn
+	typ  string
+}
+
+func (w *execWriter) Write(p []byte) (int, error) {
+	if err := w.conn.send(ExecMessage{Type: w.typ, Data: string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// find returns the running daemon with the given name iff it is owned by the owner of the token.
+func find(token *jwt.Token, name string) (*docker.APIContainers, error) {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("Could not extract claims from token")
+	}
+	subject := claims["sub"].(string)
+	containers, err := container.List(nil, container.And(container.WithName(name), container.WithLabel("subject", subject)), false)
+	if err != nil {
+		return nil, err
+	}
+	if len(containers) != 1 {
+		return nil, fmt.Errorf("Could not find daemon with name: %s", name)
+	}
+	return &containers[0], nil
+}
+
+// ExecHandler runs a command in a daemon and connects it to a websocket using ExecMessages.
+// The command is given by the query params cmd (repeated, defaults to /bin/sh), env (repeated, KEY=value),
+// workdir and tty=true.
+func ExecHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
+	vars := mux.Vars(r)
+	name, ok := vars["name"]
+	if !ok {
+		http.Error(w, "No name given", 404)
+		return
+	}
+
+	c, err := find(token, name)
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+
+	query := r.URL.Query()
+	options := container.ExecOptions{
+		Cmd:        query["cmd"],
+		Env:        query["env"],
+		WorkingDir: query.Get("workdir"),
+		Tty:        query.Get("tty") == "true",
+	}
+	if len(options.Cmd) == 0 {
+		options.Cmd = []string{"/bin/sh"}
+	}
+
+	client, err := container.GetRuntime()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.WithError(err).Warn("Error upgrading connection")
+		return
+	}
+	defer ws.Close()
+	conn := &execConn{conn: ws}
+
+	stdinr, stdinw := io.Pipe()
+	exec, err := container.StartExec(client, c.ID, options, stdinr,
+		&execWriter{conn: conn, typ: "stdout"}, &execWriter{conn: conn, typ: "stderr"})
+	if err != nil {
+		conn.send(ExecMessage{Type: "error", Data: err.Error()})
+		return
+	}
+	logger := log.WithField("name", name).WithField("exec", exec.ID)
+
+	// -- from websocket -> stdin and resizes
+	done := make(chan bool)
+	go func() {
+		defer stdinw.Close()
+		for {
+			message := ExecMessage{}
+			if err := ws.ReadJSON(&message); err != nil {
+				select {
+				case <-done:
+					// the websocket was closed after the command finished
+				default:
+					if _, closed := err.(*websocket.CloseError); !closed {
+						logger.WithError(err).Warn("Error reading from exec-websocket")
+					}
+				}
+				exec.Close()
+				return
+			}
+			switch message.Type {
+			case "stdin":
+				if _, err := stdinw.Write([]byte(message.Data)); err != nil {
+					return
+				}
+			case "resize":
+				if err := exec.Resize(message.Height, message.Width); err != nil {
+					logger.WithError(err).Warn("Could not resize exec")
+				}
+			default:
+				logger.WithField("type", message.Type).Warn("Unknown exec message")
+			}
+		}
+	}()
+
+	code, err := exec.Wait()
+	close(done)
+	stdinr.Close()
+	if err != nil {
+		conn.send(ExecMessage{Type: "error", Data: err.Error()})
+		return
+	}
+	logger.WithField("exitcode", code).Info("Exec done")
+	conn.send(ExecMessage{Type: "exit", ExitCode: &code})
+	conn.mutex.Lock()
+	ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	conn.mutex.Unlock()
+}
@@ -0,0 +1,463 @@
+package daemon
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Webstrates/golem-herder/container"
+	jwt "github.com/dgrijalva/jwt-go"
+	units "github.com/docker/go-units"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// ErrQuotaExceeded is returned when a subject would use more disk than its quota.
+var ErrQuotaExceeded = fmt.Errorf("Disk quota exceeded")
+
+// FileInfo describes a file in the data directory of a daemon.
+type FileInfo struct {
+	Name     string
+	Size     int64
+	Dir      bool
+	Modified time.Time
+}
+
+// dataDir returns the directory mounted into the daemon with the given name.
+func dataDir(name string) string {
+	return path.Join(viper.GetString("mounts"), name)
+}
+
+// dirSize returns the size of the files in dir.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// quota returns the disk quota pr. subject given by "daemon-disk-quota" - 0 means no quota.
+func quota() (int64, error) {
+	q := viper.GetString("daemon-disk-quota")
+	if q == "" {
+		return 0, nil
+	}
+	return units.RAMInBytes(q)
+}
+
+// available returns the bytes the subject may still write to the data directories of its daemons.
+// The known daemons of a subject are its containers and the daemons which exited while the herder ran.
+// A negative value means there is no quota.
+func available(subject string) (int64, error) {
+	max, err := quota()
+	if err != nil || max <= 0 {
+		return -1, err
+	}
+
+	names := map[string]bool{}
+	containers, err := container.List(nil, container.WithLabel("subject", subject), true)
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range containers {
+		for _, name := range c.Names {
+			names[strings.TrimPrefix(name, "/")] = true
+		}
+	}
+	exitsMutex.Lock()
+	for name, status := range exits {
+		if status.subject == subject {
+			names[name] = true
+		}
+	}
+	exitsMutex.Unlock()
+
+	used := int64(0)
+	for name := range names {
+		size, err := dirSize(dataDir(name))
+		if err != nil {
+			return 0, err
+		}
+		used += size
+	}
+	if used >= max {
+		return 0, nil
+	}
+	return max - used, nil
+}
+
+// quotaWriter fails when more than the remaining bytes are written to it.
+type quotaWriter struct {
+	w         io.Writer
+	remaining int64
+}
+
+func (q *quotaWriter) Write(p []byte) (int, error) {
+	if q.remaining >= 0 {
+		if int64(len(p)) > q.remaining {
+			return 0, ErrQuotaExceeded
+		}
+		q.remaining -= int64(len(p))
+	}
+	return q.w.Write(p)
+}
+
+// writeFile writes r to the file p counting the bytes against the quota. Nothing is kept if writing fails.
+func writeFile(p string, r io.Reader, q *quotaWriter) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	// Write next to the file and rename it so the daemon never sees a partial file
+	tmp, err := ioutil.TempFile(filepath.Dir(p), ".upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	q.w = tmp
+	_, err = io.Copy(q, r)
+	tmp.Close()
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// extractTar extracts the tar archive into dir.
+func extractTar(dir string, r io.Reader, q *quotaWriter) error {
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p, err := container.SafeJoin(dir, header.Name)
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(p, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := writeFile(p, archive, q); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("Unsupported entry %s in archive - only files and directories are allowed", header.Name)
+		}
+	}
+}
+
+// extractZip extracts the zip archive into dir.
+func extractZip(dir string, r io.Reader, q *quotaWriter) error {
+	// Zip needs random access so the archive is kept in a temporary file - it may not be larger than the remaining quota
+	tmp, err := ioutil.TempFile("", "herder-upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	limit := &quotaWriter{w: tmp, remaining: q.remaining}
+	size, err := io.Copy(limit, r)
+	if err != nil {
+		return err
+	}
+
+	archive, err := zip.NewReader(tmp, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range archive.File {
+		p, err := container.SafeJoin(dir, f.Name)
+		if err != nil {
+			return err
+		}
+		switch {
+		case f.FileInfo().IsDir():
+			if err := os.MkdirAll(p, 0755); err != nil {
+				return err
+			}
+		case f.FileInfo().Mode().IsRegular():
+			content, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = writeFile(p, content, q)
+			content.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("Unsupported entry %s in archive - only files and directories are allowed", f.Name)
+		}
+	}
+	return nil
+}
+
+// walk calls fn for p and the files and directories below it with their paths relative to the parent of p.
+func walk(p string, fn func(rel string, info os.FileInfo) error) error {
+	base := filepath.Dir(p)
+	return filepath.Walk(p, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// Symlinks are skipped as they may point out of the directory
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		rel, err := filepath.Rel(base, file)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel), info)
+	})
+}
+
+// writeTar writes the file or directory p as a tar archive to w.
+func writeTar(w io.Writer, p string) error {
+	archive := tar.NewWriter(w)
+	err := walk(p, func(rel string, info os.FileInfo) error {
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = rel
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(filepath.Join(filepath.Dir(p), rel))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(archive, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// writeZip writes the file or directory p as a zip archive to w.
+func writeZip(w io.Writer, p string) error {
+	archive := zip.NewWriter(w)
+	err := walk(p, func(rel string, info os.FileInfo) error {
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = rel
+		if info.IsDir() {
+			header.Name += "/"
+		} else {
+			header.Method = zip.Deflate
+		}
+		entry, err := archive.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(filepath.Join(filepath.Dir(p), rel))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(entry, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// list returns the files in the directory p.
+func list(p string) ([]FileInfo, error) {
+	infos, err := ioutil.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+	files := []FileInfo{}
+	for _, info := range infos {
+		files = append(files, FileInfo{Name: info.Name(), Size: info.Size(), Dir: info.IsDir(), Modified: info.ModTime()})
+	}
+	return files, nil
+}
+
+// FilesHandler handles requests for the files in the data directory of a daemon:
+//   - GET lists a directory (as json) or downloads a file. With format=tar or format=zip the file or directory is downloaded as an archive.
+//   - PUT uploads the body to a file. With format=tar or format=zip the body is extracted into the directory. With dir=true a directory is created.
+//   - DELETE deletes a file or directory.
+//
+// Uploads count against the disk quota ("daemon-disk-quota") of the owner of the token.
+func FilesHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
+	vars := mux.Vars(r)
+	name, ok := vars["name"]
+	if !ok {
+		http.Error(w, "No name given", 404)
+		return
+	}
+
+	status, err := GetStatus(token, name)
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	dir := dataDir(name)
+	if _, err := os.Stat(dir); err != nil {
+		http.Error(w, "Daemon has no data directory", 404)
+		return
+	}
+
+	p, err := container.SafeJoin(dir, vars["path"])
+	if err != nil {
+		http.Error(w, err.Error(), 400 /* Bad request */)
+		return
+	}
+	logger := log.WithField("name", name).WithField("path", vars["path"])
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "tar" && format != "zip" {
+		http.Error(w, fmt.Sprintf("Invalid format: %s", format), 400 /* Bad request */)
+		return
+	}
+
+	// Transfers may take longer than the timeouts of the server
+	controller := http.NewResponseController(w)
+	if err := controller.SetReadDeadline(time.Time{}); err != nil {
+		logger.WithError(err).Warn("Could not clear read deadline of files request")
+	}
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil {
+		logger.WithError(err).Warn("Could not clear write deadline of files request")
+	}
+
+	switch r.Method {
+	case "GET":
+		info, err := os.Stat(p)
+		if err != nil {
+			http.Error(w, "No such file or directory", 404)
+			return
+		}
+		download := filepath.Base(p)
+		if p == dir {
+			download = name
+		}
+		switch {
+		case format == "tar":
+			w.Header().Set("Content-Type", "application/x-tar")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", download+".tar"))
+			err = writeTar(w, p)
+		case format == "zip":
+			w.Header().Set("Content-Type", "application/zip")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", download+".zip"))
+			err = writeZip(w, p)
+		case info.IsDir():
+			var files []FileInfo
+			if files, err = list(p); err == nil {
+				var s []byte
+				if s, err = json.Marshal(files); err == nil {
+					w.Header().Set("Content-Type", "application/json")
+					w.Write(s)
+				}
+			}
+		default:
+			http.ServeFile(w, r, p)
+		}
+		if err != nil {
+			logger.WithError(err).Warn("Could not read files")
+			http.Error(w, err.Error(), 500)
+		}
+
+	case "PUT", "POST":
+		remaining, err := available(status.subject)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		q := &quotaWriter{remaining: remaining}
+		switch {
+		case r.URL.Query().Get("dir") == "true":
+			err = os.MkdirAll(p, 0755)
+		case format == "tar":
+			if err = os.MkdirAll(p, 0755); err == nil {
+				err = extractTar(p, r.Body, q)
+			}
+		case format == "zip":
+			if err = os.MkdirAll(p, 0755); err == nil {
+				err = extractZip(p, r.Body, q)
+			}
+		default:
+			if p == dir {
+				http.Error(w, "No file name given", 400 /* Bad request */)
+				return
+			}
+			err = writeFile(p, r.Body, q)
+		}
+		if err == ErrQuotaExceeded {
+			logger.Warn("Upload exceeds disk quota")
+			http.Error(w, err.Error(), 413 /* Request entity too large */)
+			return
+		}
+		if err != nil {
+			logger.WithError(err).Warn("Could not write files")
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		logger.Info("Files uploaded")
+
+	case "DELETE":
+		if p == dir {
+			http.Error(w, "Can not delete the data directory - kill the daemon with wipe=true instead", 400 /* Bad request */)
+			return
+		}
+		if _, err := os.Lstat(p); err != nil {
+			http.Error(w, "No such file or directory", 404)
+			return
+		}
+		if err := os.RemoveAll(p); err != nil {
+			logger.WithError(err).Warn("Could not delete files")
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		logger.Info("Files deleted")
+
+	default:
+		http.Error(w, "Method not allowed", 405 /* Method Not Allowed */)
+	}
+}
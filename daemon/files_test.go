@@ -0,0 +1,170 @@
+package daemon
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+)
+
+// entry is a file in an archive - or a directory if its name ends with /.
+type entry struct {
+	name    string
+	content string
+}
+
+func tarOf(t *testing.T, entries ...entry) []byte {
+	var b bytes.Buffer
+	archive := tar.NewWriter(&b)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.content)), Typeflag: tar.TypeReg}
+		if strings.HasSuffix(e.name, "/") {
+			header.Typeflag, header.Mode, header.Size = tar.TypeDir, 0755, 0
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		archive.Write([]byte(e.content))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func zipOf(t *testing.T, entries ...entry) []byte {
+	var b bytes.Buffer
+	archive := zip.NewWriter(&b)
+	for _, e := range entries {
+		w, err := archive.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(e.content))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// exists tells whether the file p exists (without following symlinks).
+func exists(p string) bool {
+	_, err := os.Lstat(p)
+	return err == nil
+}
+
+func TestExtract(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "mount")
+	outside := filepath.Join(root, "outside")
+	for _, d := range []string{dir, outside} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "out")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "missing"), filepath.Join(dir, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+
+	extractors := map[string]func(entries ...entry) error{
+		"tar": func(entries ...entry) error {
+			return extractTar(dir, bytes.NewReader(tarOf(t, entries...)), &quotaWriter{remaining: -1})
+		},
+		"zip": func(entries ...entry) error {
+			return extractZip(dir, bytes.NewReader(zipOf(t, entries...)), &quotaWriter{remaining: -1})
+		},
+	}
+	for format, extract := range extractors {
+		// .. and absolute entries are extracted within the directory
+		if err := extract(entry{"a/", ""}, entry{"a/b.txt", "b"}, entry{"../up-" + format, "up"}, entry{"/abs-" + format, "abs"}); err != nil {
+			t.Errorf("Could not extract %s: %v", format, err)
+		}
+		for _, p := range []string{"a/b.txt", "up-" + format, "abs-" + format} {
+			if !exists(filepath.Join(dir, p)) {
+				t.Errorf("%s not extracted from %s", p, format)
+			}
+		}
+		if exists(filepath.Join(root, "up-"+format)) {
+			t.Errorf("%s extracted out of the directory", format)
+		}
+
+		// Entries through symlinks out of the directory are refused - also if the symlink is dangling
+		for _, name := range []string{"out/x-" + format, "dangling/x-" + format, "dangling/y/x-" + format} {
+			if err := extract(entry{name, "x"}); err == nil {
+				t.Errorf("%s extracted %s through a symlink out of the directory", format, name)
+			}
+		}
+		if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+			t.Errorf("%s extracted out of the directory: %v", format, entries)
+		}
+	}
+
+	// Symlinks in archives are refused
+	var b bytes.Buffer
+	archive := tar.NewWriter(&b)
+	archive.WriteHeader(&tar.Header{Name: "link", Linkname: outside, Typeflag: tar.TypeSymlink})
+	archive.Close()
+	if err := extractTar(dir, &b, &quotaWriter{remaining: -1}); err == nil || exists(filepath.Join(dir, "link")) {
+		t.Errorf("Symlink extracted from tar (%v)", err)
+	}
+}
+
+func TestFilesQuota(t *testing.T) {
+	setup(t)
+	viper.Set("daemon-disk-quota", "1k")
+	defer viper.Set("daemon-disk-quota", "")
+
+	token, info := spawn(t, "echo", "quota@example.com", 100, Options{Done: make(chan bool, 1)})
+	defer Kill(info.Name, true, token)
+	dir := dataDir(info.Name)
+
+	upload := func(token *jwt.Token, path, format string, body []byte) int {
+		r := httptest.NewRequest("PUT", "/daemon/v1/files/"+info.Name+"/"+path+"?format="+format, bytes.NewReader(body))
+		r = mux.SetURLVars(r, map[string]string{"name": info.Name, "path": path})
+		w := httptest.NewRecorder()
+		FilesHandler(w, r, token)
+		return w.Code
+	}
+
+	if code := upload(token, "half.txt", "", bytes.Repeat([]byte("a"), 512)); code != 200 {
+		t.Fatalf("Upload within quota gave %d", code)
+	}
+	for _, test := range []struct {
+		path, format string
+		body         []byte
+	}{
+		{"big.txt", "", bytes.Repeat([]byte("a"), 600)},
+		{"big", "tar", tarOf(t, entry{"big.txt", strings.Repeat("a", 600)})},
+		{"big", "zip", zipOf(t, entry{"big.txt", strings.Repeat("a", 600)})},
+	} {
+		if code := upload(token, test.path, test.format, test.body); code != 413 {
+			t.Errorf("Upload of %s (%s) over quota gave %d - expected 413", test.path, test.format, code)
+		}
+		if exists(filepath.Join(dir, test.path, "big.txt")) || exists(filepath.Join(dir, "big.txt")) {
+			t.Errorf("Upload of %s (%s) over quota was kept", test.path, test.format)
+		}
+	}
+	// Others can not upload to the daemon
+	other := &jwt.Token{Claims: jwt.MapClaims{"sub": "other@example.com", "jti": "other"}}
+	if code := upload(other, "other.txt", "", []byte("a")); code != 404 {
+		t.Errorf("Upload by another owner gave %d - expected 404", code)
+	}
+	if code := upload(token, "rest.txt", "", bytes.Repeat([]byte("a"), 512)); code != 200 {
+		t.Errorf("Upload of the rest of the quota gave %d", code)
+	}
+	if code := upload(token, "more.txt", "", []byte("a")); code != 413 {
+		t.Errorf("Upload over the used quota gave %d - expected 413", code)
+	}
+}
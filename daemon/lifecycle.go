@@ -0,0 +1,185 @@
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/Webstrates/golem-herder/container"
+	"github.com/Webstrates/golem-herder/metering"
+	jwt "github.com/dgrijalva/jwt-go"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// ErrNoCredits is returned when a daemon can not be started as its owner has no credits left.
+var ErrNoCredits = fmt.Errorf("No credits left")
+
+// stopTimeout is the seconds a daemon is given to stop before it is killed.
+const stopTimeout = 10
+
+// stoppedFile returns the file marking the daemon with the given name as stopped.
+// It is kept next to (and not in) the data directory of the daemon so the daemon can not touch it.
+func stoppedFile(name string) string {
+	return path.Join(viper.GetString("mounts"), fmt.Sprintf(".%s.stopped", name))
+}
+
+// isStopped tells whether the daemon with the given name was stopped (and not started since).
+func isStopped(name string) bool {
+	_, err := os.Stat(stoppedFile(name))
+	return err == nil
+}
+
+// owned returns the container of the daemon with the given name (running or not) iff it is owned by the owner of the token.
+func owned(token *jwt.Token, name string) (*docker.APIContainers, string, error) {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, "", fmt.Errorf("Could not extract claims from token")
+	}
+	subject := claims["sub"].(string)
+	containers, err := container.List(nil, container.And(container.WithName(name), container.WithLabel("subject", subject)), true)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(containers) != 1 {
+		return nil, "", fmt.Errorf("Could not find daemon with name: %s", name)
+	}
+	return &containers[0], subject, nil
+}
+
+// Stop the daemon with the given name. Its container and data are kept and it is not charged until it is started again.
+func Stop(token *jwt.Token, name string) error {
+	c, _, err := owned(token, name)
+	if err != nil {
+		return err
+	}
+	if c.State != "running" {
+		return fmt.Errorf("Daemon %s is not running", name)
+	}
+
+	exitsMutex.Lock()
+	wait, ok := exited[name]
+	if !ok {
+		wait = make(chan bool)
+		exited[name] = wait
+	}
+	exitsMutex.Unlock()
+
+	// Mark the daemon first so a herder restart while stopping keeps it
+	if err := ioutil.WriteFile(stoppedFile(name), []byte{}, 0644); err != nil {
+		return err
+	}
+	if err := container.Stop(c.ID, stopTimeout); err != nil {
+		os.Remove(stoppedFile(name))
+		return err
+	}
+
+	// Wait for the exit to be recorded (and the meter detached) so the daemon can be started right away
+	select {
+	case <-wait:
+	case <-time.After(stopTimeout * time.Second):
+		log.WithField("name", name).Warn("Daemon exit was not recorded")
+	}
+	log.WithField("name", name).Info("Daemon stopped")
+	return nil
+}
+
+// Start the stopped daemon with the given name. Its owner is charged again while it runs.
+func Start(token *jwt.Token, name string) error {
+	c, subject, err := owned(token, name)
+	if err != nil {
+		return err
+	}
+	if c.State == "running" {
+		return fmt.Errorf("Daemon %s is already running", name)
+	}
+	if !isStopped(name) {
+		return fmt.Errorf("Daemon %s was not stopped", name)
+	}
+
+	m, err := metering.ExistingMeter(subject)
+	if err != nil {
+		return err
+	}
+	if credits, err := m.Credits(); err != nil || credits <= 0 {
+		return ErrNoCredits
+	}
+
+	exitsMutex.Lock()
+	var resources *container.Resources
+	if status, ok := exits[name]; ok {
+		resources = status.Resources
+	}
+	delete(exits, name)
+	exitsMutex.Unlock()
+
+	meter(name, m)
+	if err := container.Start(c.ID); err != nil {
+		unmeter(name)
+		return err
+	}
+	os.Remove(stoppedFile(name))
+
+	done := make(chan docker.State, 1)
+	if err := container.Monitor(name, done); err != nil {
+		log.WithError(err).WithField("name", name).Warn("Could not monitor daemon")
+	}
+	go retainLogs(name, subject, c.ID, true)
+	go func() {
+		state := <-done
+		unmeter(name)
+		recordExit(name, subject, resources, state)
+	}()
+
+	log.WithField("name", name).Info("Daemon started")
+	return nil
+}
+
+// Restart the daemon with the given name - it is started if it is stopped.
+func Restart(token *jwt.Token, name string) error {
+	c, _, err := owned(token, name)
+	if err != nil {
+		return err
+	}
+	if c.State == "running" {
+		if err := Stop(token, name); err != nil {
+			return err
+		}
+	}
+	return Start(token, name)
+}
+
+// lifecycleHandler returns a handler performing the given action on the daemon given in the path.
+func lifecycleHandler(action func(token *jwt.Token, name string) error) func(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
+	return func(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
+		vars := mux.Vars(r)
+		name, ok := vars["name"]
+		if !ok {
+			http.Error(w, "No name given", 404)
+			return
+		}
+		if err := action(token, name); err != nil {
+			if err == ErrNoCredits {
+				http.Error(w, err.Error(), 402 /* Payment required */)
+				return
+			}
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		StatusHandler(w, r, token)
+	}
+}
+
+// StopHandler handles stop requests
+var StopHandler = lifecycleHandler(Stop)
+
+// StartHandler handles start requests
+var StartHandler = lifecycleHandler(Start)
+
+// RestartHandler handles restart requests
+var RestartHandler = lifecycleHandler(Restart)
@@ -0,0 +1,62 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Webstrates/golem-herder/metering"
+)
+
+func TestStopStart(t *testing.T) {
+	setup(t)
+
+	token, info := spawn(t, "echo", "lifecycle@example.com", 100, Options{Ports: []int{80}, Done: make(chan bool, 1)})
+	defer Kill(info.Name, true, token)
+	port := info.Ports[80]
+	m, err := metering.ExistingMeter("lifecycle@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	credits := func() int {
+		c, err := m.Credits()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	eventually(t, func() bool { return consumption(info.Name) > 0 }, "Daemon is not charged")
+
+	if err := Stop(token, info.Name); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := GetStatus(token, info.Name); err != nil || status.Running || !status.Stopped {
+		t.Errorf("Daemon not stopped: %+v %v", status, err)
+	}
+	// Stopped daemons keep their ports and are not charged
+	before := credits()
+	time.Sleep(1500 * time.Millisecond)
+	if after := credits(); after != before {
+		t.Errorf("Stopped daemon charged %d credits", before-after)
+	}
+	if !reserved(t, port) {
+		t.Errorf("Port %d of stopped daemon released", port)
+	}
+	if err := Stop(token, info.Name); err == nil {
+		t.Error("Stopped daemon stopped again")
+	}
+
+	if err := Start(token, info.Name); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := GetStatus(token, info.Name); err != nil || !status.Running || status.Stopped {
+		t.Errorf("Daemon not started: %+v %v", status, err)
+	}
+	if !reserved(t, port) {
+		t.Errorf("Port %d of started daemon released", port)
+	}
+	// The daemon is charged again
+	eventually(t, func() bool { return credits() < before }, "Started daemon is not charged")
+	if err := Start(token, info.Name); err == nil {
+		t.Error("Running daemon started again")
+	}
+}
@@ -0,0 +1,291 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Webstrates/golem-herder/container"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// history keeps the last log messages of a daemon so they can be read after it is gone.
+type history struct {
+	subject  string
+	mutex    sync.Mutex
+	messages []container.LogMessage
+	// ended is when the messages stopped being retained - zero while they are
+	ended time.Time
+}
+
+func (h *history) add(message container.LogMessage) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.messages = append(h.messages, message)
+	if max := viper.GetInt("daemon-log-lines"); max > 0 && len(h.messages) > max {
+		h.messages = h.messages[len(h.messages)-max:]
+	}
+}
+
+func (h *history) get(options container.LogOptions) []container.LogMessage {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return container.FilterLogs(h.messages, options)
+}
+
+var (
+	histories      = map[string]*history{}
+	historiesMutex = &sync.Mutex{}
+)
+
+// retainLogs keeps the log messages of the daemon container with the given id.
+// If follow is set the logs are kept until the container stops, otherwise only the current logs are kept.
+func retainLogs(name, subject, id string, follow bool) {
+	h := &history{subject: subject}
+	historiesMutex.Lock()
+	histories[name] = h
+	historiesMutex.Unlock()
+
+	client, err := container.GetRuntime()
+	if err != nil {
+		return
+	}
+
+	messages := make(chan container.LogMessage, 100)
+	go func() {
+		defer close(messages)
+		if err := container.StreamLogs(context.Background(), client, id, container.LogOptions{Follow: follow}, messages); err != nil {
+			log.WithError(err).WithField("name", name).Warn("Could not retain daemon logs")
+		}
+	}()
+	for message := range messages {
+		h.add(message)
+	}
+
+	h.mutex.Lock()
+	h.ended = time.Now()
+	h.mutex.Unlock()
+	pruneLogs()
+}
+
+// pruneLogs forgets the logs of the daemons which are gone the longest - keeping those of the last
+// "daemon-log-histories" daemons.
+func pruneLogs() {
+	max := viper.GetInt("daemon-log-histories")
+	if max <= 0 {
+		return
+	}
+
+	historiesMutex.Lock()
+	defer historiesMutex.Unlock()
+	names := []string{}
+	ended := map[string]time.Time{}
+	for name, h := range histories {
+		h.mutex.Lock()
+		if !h.ended.IsZero() {
+			names = append(names, name)
+			ended[name] = h.ended
+		}
+		h.mutex.Unlock()
+	}
+	if len(names) <= max {
+		return
+	}
+	sort.Slice(names, func(i, j int) bool { return ended[names[i]].Before(ended[names[j]]) })
+	for _, name := range names[:len(names)-max] {
+		delete(histories, name)
+	}
+}
+
+// forgetLogs forgets the logs kept for the daemon with the given name.
+func forgetLogs(name string) {
+	historiesMutex.Lock()
+	defer historiesMutex.Unlock()
+	delete(histories, name)
+}
+
+// Logs sends the log messages of the daemon with the given name on messages iff it is owned by the owner of the token.
+// The logs of running daemons are read from the container, the last lines of stopped daemons are kept by the herder.
+func Logs(ctx context.Context, token *jwt.Token, name string, options container.LogOptions, messages chan<- container.LogMessage) error {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return fmt.Errorf("Could not extract claims from token")
+	}
+	subject := claims["sub"].(string)
+
+	containers, err := container.List(nil, container.And(container.WithName(name), container.WithLabel("subject", subject)), false)
+	if err != nil {
+		return err
+	}
+	if len(containers) == 1 {
+		client, err := container.GetRuntime()
+		if err != nil {
+			return err
+		}
+		return container.StreamLogs(ctx, client, containers[0].ID, options, messages)
+	}
+
+	historiesMutex.Lock()
+	h, ok := histories[name]
+	historiesMutex.Unlock()
+	if !ok || h.subject != subject {
+		return fmt.Errorf("Could not find daemon with name: %s", name)
+	}
+	for _, message := range h.get(options) {
+		select {
+		case messages <- message:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return nil
+}
+
+// parseTime parses a point in time given as RFC3339, unix seconds or a duration before now (e.g. 10m).
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// logOptions reads the log options from the query params tail, since, until, stream and follow.
+func logOptions(r *http.Request) (container.LogOptions, error) {
+	query := r.URL.Query()
+	options := container.LogOptions{Follow: query.Get("follow") == "true"}
+
+	var err error
+	if tail := query.Get("tail"); tail != "" && tail != "all" {
+		if options.Tail, err = strconv.Atoi(tail); err != nil {
+			return options, fmt.Errorf("Invalid tail: %s", tail)
+		}
+	}
+	if options.Since, err = parseTime(query.Get("since")); err != nil {
+		return options, fmt.Errorf("Invalid since: %s", query.Get("since"))
+	}
+	if options.Until, err = parseTime(query.Get("until")); err != nil {
+		return options, fmt.Errorf("Invalid until: %s", query.Get("until"))
+	}
+	switch stream := query.Get("stream"); stream {
+	case "":
+	case string(container.Stdout):
+		options.Stdout = true
+	case string(container.Stderr):
+		options.Stderr = true
+	default:
+		return options, fmt.Errorf("Invalid stream: %s", stream)
+	}
+	return options, nil
+}
+
+// LogsHandler handles log requests. Messages are written as plain text lines unless format=ndjson is given
+// in which case each line is a json LogMessage. Websocket connections get a json LogMessage pr. message.
+func LogsHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
+	vars := mux.Vars(r)
+	name, ok := vars["name"]
+	if !ok {
+		http.Error(w, "No name given", 404)
+		return
+	}
+
+	options, err := logOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400 /* Bad request */)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "text" && format != "ndjson" {
+		http.Error(w, fmt.Sprintf("Invalid format: %s", format), 400 /* Bad request */)
+		return
+	}
+
+	// Check access before upgrading or writing anything
+	if _, err := GetStatus(token, name); err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	var write func(message container.LogMessage) error
+	if websocket.IsWebSocketUpgrade(r) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.WithError(err).Warn("Error upgrading connection")
+			return
+		}
+		defer conn.Close()
+		// Reading detects when the client goes away
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					cancel()
+					return
+				}
+			}
+		}()
+		write = func(message container.LogMessage) error {
+			return conn.WriteJSON(message)
+		}
+	} else {
+		// Following may take longer than the write timeout of the server
+		if options.Follow {
+			if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+				log.WithError(err).Warn("Could not clear write deadline of logs request")
+			}
+		}
+		flusher, _ := w.(http.Flusher)
+		encoder := json.NewEncoder(w)
+		if format == "ndjson" {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		} else {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		write = func(message container.LogMessage) error {
+			var err error
+			if format == "ndjson" {
+				err = encoder.Encode(message)
+			} else {
+				_, err = fmt.Fprintln(w, message.Line)
+			}
+			if flusher != nil && options.Follow {
+				flusher.Flush()
+			}
+			return err
+		}
+	}
+
+	messages := make(chan container.LogMessage, 100)
+	go func() {
+		defer close(messages)
+		if err := Logs(ctx, token, name, options, messages); err != nil {
+			log.WithError(err).WithField("name", name).Warn("Could not read daemon logs")
+		}
+	}()
+	for message := range messages {
+		if err := write(message); err != nil {
+			cancel()
+			break
+		}
+	}
+	// Let the reader finish
+	for range messages {
+	}
+}
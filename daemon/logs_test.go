@@ -0,0 +1,147 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Webstrates/golem-herder/container"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+)
+
+// readLogs reads the logs of the daemon as ndjson with the given query params.
+func readLogs(t *testing.T, token *jwt.Token, name, query string) ([]container.LogMessage, int) {
+	r := httptest.NewRequest("GET", "/daemon/v1/logs/"+name+"?format=ndjson&"+query, nil)
+	r = mux.SetURLVars(r, map[string]string{"name": name})
+	w := httptest.NewRecorder()
+	LogsHandler(w, r, token)
+
+	messages := []container.LogMessage{}
+	if w.Code != 200 {
+		return messages, w.Code
+	}
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		message := container.LogMessage{}
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			t.Fatalf("Invalid ndjson line %q: %v", scanner.Text(), err)
+		}
+		messages = append(messages, message)
+	}
+	return messages, w.Code
+}
+
+// lines returns the sorted lines of the messages - lines of different streams are not necessarily ordered.
+func lines(messages []container.LogMessage) string {
+	stdout, stderr := []string{}, []string{}
+	for _, message := range messages {
+		if message.Stream == container.Stderr {
+			stderr = append(stderr, message.Line)
+		} else {
+			stdout = append(stdout, message.Line)
+		}
+	}
+	return strings.Join(append(stdout, stderr...), ",")
+}
+
+func TestLogs(t *testing.T) {
+	setup(t)
+	before := time.Now().Add(-time.Second)
+
+	done := make(chan bool, 1)
+	token, info := spawn(t, "chatty", "logs@example.com", 100, Options{Done: done})
+	other := &jwt.Token{Claims: jwt.MapClaims{"sub": "other@example.com", "jti": "other"}}
+
+	check := func(from string) {
+		eventually(t, func() bool {
+			messages, _ := readLogs(t, token, info.Name, "")
+			return len(messages) == 10
+		}, "Not all logs of the %s daemon are read", from)
+
+		for _, test := range []struct {
+			query string
+			lines string
+		}{
+			{"", "out 1,out 2,out 3,out 4,out 5,err 1,err 2,err 3,err 4,err 5"},
+			{"stream=stderr", "err 1,err 2,err 3,err 4,err 5"},
+			{"stream=stdout&tail=2", "out 4,out 5"},
+			{"tail=all&stream=stdout", "out 1,out 2,out 3,out 4,out 5"},
+			{"since=1h&stream=stderr&tail=1", "err 5"},
+			{"since=" + time.Now().Add(time.Hour).Format(time.RFC3339), ""},
+			{fmt.Sprintf("until=%d", before.Unix()), ""},
+			{fmt.Sprintf("since=%d&until=%s&stream=stdout", before.Unix(), time.Now().Add(time.Second).Format(time.RFC3339Nano)), "out 1,out 2,out 3,out 4,out 5"},
+		} {
+			messages, code := readLogs(t, token, info.Name, test.query)
+			if code != 200 || lines(messages) != test.lines {
+				t.Errorf("Logs of %s daemon with %q gave %d %q - expected %q", from, test.query, code, lines(messages), test.lines)
+			}
+		}
+
+		for _, query := range []string{"tail=x", "since=yesterday", "until=x", "stream=stdin"} {
+			if _, code := readLogs(t, token, info.Name, query); code != 400 {
+				t.Errorf("Logs of %s daemon with %q gave %d - expected 400", from, query, code)
+			}
+		}
+		if _, code := readLogs(t, other, info.Name, ""); code != 404 {
+			t.Errorf("Logs of %s daemon given to another owner (%d)", from, code)
+		}
+	}
+
+	check("running")
+	if err := Kill(info.Name, false, token); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Daemon not done after kill")
+	}
+	// The logs are kept when the daemon is gone
+	check("gone")
+}
+
+func TestLogsForgotten(t *testing.T) {
+	setup(t)
+
+	// The logs of wiped daemons are not kept
+	done := make(chan bool, 1)
+	token, info := spawn(t, "chatty", "wiped@example.com", 100, Options{Done: done})
+	eventually(t, func() bool {
+		messages, _ := readLogs(t, token, info.Name, "")
+		return len(messages) == 10
+	}, "Not all logs of the daemon are read")
+	if err := Kill(info.Name, true, token); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	eventually(t, func() bool {
+		messages, _ := readLogs(t, token, info.Name, "")
+		return len(messages) == 0
+	}, "Logs of wiped daemon are kept")
+
+	// Only the logs of the last daemons which are gone are kept
+	viper.Set("daemon-log-histories", 2)
+	defer viper.Set("daemon-log-histories", 0)
+	now := time.Now()
+	historiesMutex.Lock()
+	histories["pruned-running"] = &history{}
+	for i := 0; i < 4; i++ {
+		histories[fmt.Sprintf("pruned-%d", i)] = &history{ended: now.Add(time.Duration(i) * time.Minute)}
+	}
+	historiesMutex.Unlock()
+	pruneLogs()
+
+	historiesMutex.Lock()
+	defer historiesMutex.Unlock()
+	for name, kept := range map[string]bool{"pruned-running": true, "pruned-0": false, "pruned-1": false, "pruned-2": true, "pruned-3": true} {
+		if _, ok := histories[name]; ok != kept {
+			t.Errorf("Logs of %s kept: %v - expected %v", name, ok, kept)
+		}
+	}
+}
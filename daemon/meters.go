@@ -0,0 +1,106 @@
+package daemon
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Webstrates/golem-herder/container"
+	"github.com/Webstrates/golem-herder/metering"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// meters contains the meters of the daemons by container name
+	meters = map[string]*metering.Meter{}
+	// charging contains the names of the running daemons as seen by the container watcher
+	charging = map[string]bool{}
+	// consumed contains the credits charged for the daemons by container name
+	consumed     = map[string]int{}
+	metersMutex  = &sync.Mutex{}
+	meteringOnce sync.Once
+)
+
+// meter will charge the given meter every second the daemon with the given name runs.
+func meter(name string, m *metering.Meter) {
+	meteringOnce.Do(startMetering)
+	metersMutex.Lock()
+	defer metersMutex.Unlock()
+	meters[name] = m
+}
+
+// unmeter stops charging for the daemon with the given name.
+func unmeter(name string) {
+	metersMutex.Lock()
+	defer metersMutex.Unlock()
+	delete(meters, name)
+}
+
+// consumption returns the credits charged for the daemon with the given name so far.
+func consumption(name string) int {
+	metersMutex.Lock()
+	defer metersMutex.Unlock()
+	return consumed[name]
+}
+
+// resetConsumed forgets the credits charged for a previous daemon with the given name.
+func resetConsumed(name string) {
+	metersMutex.Lock()
+	defer metersMutex.Unlock()
+	delete(consumed, name)
+}
+
+// startMetering follows daemons starting and dying and charges the running ones once a second.
+func startMetering() {
+	events := container.SubscribeRunning(container.All(
+		container.OfLabel("subject"),
+		container.OfType(container.EventStart, container.EventDie)))
+
+	go func() {
+		for event := range events.C {
+			metersMutex.Lock()
+			if event.Type == container.EventStart {
+				charging[event.Name] = true
+			} else {
+				delete(charging, event.Name)
+			}
+			metersMutex.Unlock()
+		}
+	}()
+
+	// Charging writes to the meter database - it must not hold up following the daemons
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			charge()
+		}
+	}()
+}
+
+// charge records a second of usage on the meters of the running daemons.
+func charge() {
+	metersMutex.Lock()
+	running := map[string]*metering.Meter{}
+	for name := range charging {
+		if m, ok := meters[name]; ok {
+			running[name] = m
+		}
+	}
+	metersMutex.Unlock()
+
+	for name, m := range running {
+		if err := m.Record(1); err != nil {
+			log.WithError(err).WithField("name", name).Warn("Could not record time spent - kill")
+			unmeter(name)
+			go func(name string) {
+				if err := container.Kill(container.WithName(name), false, false); err != nil {
+					log.WithError(err).Warn("Error killing container")
+				}
+			}(name)
+			continue
+		}
+		metersMutex.Lock()
+		consumed[name]++
+		metersMutex.Unlock()
+	}
+}
@@ -0,0 +1,334 @@
+package daemon
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Webstrates/golem-herder/container"
+	"github.com/Webstrates/golem-herder/token"
+	jwt "github.com/dgrijalva/jwt-go"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Access policies for the reverse proxy to a daemon.
+const (
+	// AccessPublic lets anyone reach the daemon
+	AccessPublic = "public"
+	// AccessOwner lets only the owner of the daemon (with a valid token) reach it
+	AccessOwner = "owner"
+	// AccessShared lets the owner and anyone with a share link reach the daemon
+	AccessShared = "shared"
+)
+
+const (
+	// grantTTL is how long the owner of a daemon may use the proxy after giving a token
+	grantTTL = 12 * time.Hour
+	// maxShareTTL is the longest time a share link can be valid
+	maxShareTTL = 7 * 24 * time.Hour
+)
+
+var (
+	secret     []byte
+	secretOnce sync.Once
+)
+
+// proxySecret returns the key share links and proxy cookies are signed with. Unless "proxy-secret" is set
+// a random key is used and share links will not survive a restart of the herder.
+func proxySecret() []byte {
+	secretOnce.Do(func() {
+		if s := viper.GetString("proxy-secret"); s != "" {
+			secret = []byte(s)
+			return
+		}
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.WithError(err).Panic("Could not generate proxy secret")
+		}
+	})
+	return secret
+}
+
+// grant returns a signed grant to use the proxy to the daemon with the given name until expires.
+func grant(name string, expires time.Time) string {
+	mac := hmac.New(sha256.New, proxySecret())
+	fmt.Fprintf(mac, "%s\n%d", name, expires.Unix())
+	return fmt.Sprintf("%d.%s", expires.Unix(), hex.EncodeToString(mac.Sum(nil)))
+}
+
+// validGrant tells whether the given grant to use the proxy to the daemon with the given name is valid and unexpired.
+func validGrant(name, g string) bool {
+	parts := strings.SplitN(g, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(grant(name, time.Unix(expires, 0))), []byte(g))
+}
+
+// cookieName returns the name of the cookie holding the grant to use the proxy to the daemon with the given name.
+func cookieName(name string) string {
+	return "herder-proxy-" + name
+}
+
+// authorize checks that the request may reach the daemon in the given container according to its access policy.
+// The owner (given a token) and users of share links are given a cookie (on the given path, which must be the
+// daemon's own prefix or host) so they can navigate the daemon without passing the token or share link again.
+func authorize(m *token.Manager, w http.ResponseWriter, r *http.Request, c *docker.APIContainers, name, cookiePath string) bool {
+	access := c.Labels["access"]
+	if access == AccessPublic {
+		return true
+	}
+
+	if cookie, err := r.Cookie(cookieName(name)); err == nil && validGrant(name, cookie.Value) {
+		return true
+	}
+
+	setCookie := func(g string, expires time.Time) {
+		http.SetCookie(w, &http.Cookie{
+			Name:     cookieName(name),
+			Value:    g,
+			Path:     cookiePath,
+			Expires:  expires,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+
+	if share := r.URL.Query().Get("share"); access == AccessShared && share != "" && validGrant(name, share) {
+		expires, _ := strconv.ParseInt(strings.SplitN(share, ".", 2)[0], 10, 64)
+		setCookie(share, time.Unix(expires, 0))
+		return true
+	}
+
+	if t, err := m.ValidateRequest(r); err == nil {
+		if claims, ok := t.Claims.(jwt.MapClaims); ok && claims["sub"] == c.Labels["subject"] {
+			expires := time.Now().Add(grantTTL)
+			setCookie(grant(name, expires), expires)
+			// The token is for the herder - not the daemon
+			r.Header.Del("Authorization")
+			return true
+		}
+	}
+	return false
+}
+
+// targetPort returns the host port of the given (inside) port of the daemon in the given container.
+// If port is 0 the first port given when the daemon was spawned is used.
+func targetPort(c *docker.APIContainers, port int) (int64, error) {
+	if port == 0 {
+		port, _ = strconv.Atoi(c.Labels["port"])
+	}
+	ports := []docker.APIPort{}
+	for _, p := range c.Ports {
+		if p.PublicPort != 0 {
+			ports = append(ports, p)
+		}
+	}
+	if len(ports) == 0 {
+		return 0, fmt.Errorf("No exposed ports found")
+	}
+	if port == 0 {
+		// Daemons spawned before the port label was added - use the lowest port
+		sort.Slice(ports, func(i, j int) bool { return ports[i].PrivatePort < ports[j].PrivatePort })
+		return ports[0].PublicPort, nil
+	}
+	for _, p := range ports {
+		if p.PrivatePort == int64(port) {
+			return p.PublicPort, nil
+		}
+	}
+	return 0, fmt.Errorf("Port %d is not exposed", port)
+}
+
+// proxy forwards the request to the given path on the given port of the daemon with the given name.
+// Prefix is the part of the request path which was stripped (if any). Websocket upgrades are proxied as well.
+func proxy(m *token.Manager, w http.ResponseWriter, r *http.Request, name string, port int, path, prefix, cookiePath string) {
+	predicate := container.And(container.And(container.WithName(name), container.HasLabel("tokenid")), container.WithState("running"))
+	containers, err := container.List(nil, predicate, true)
+	if err != nil {
+		http.Error(w, "Failed to look for container", 500)
+		return
+	}
+	if len(containers) != 1 {
+		http.Error(w, "No container found", 404)
+		return
+	}
+	c := &containers[0]
+
+	if !authorize(m, w, r, c, name, cookiePath) {
+		http.Error(w, "Not allowed to access daemon", 401 /* Unauthorized */)
+		return
+	}
+
+	public, err := targetPort(c, port)
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if forwarded := r.Header.Get("X-Forwarded-Proto"); forwarded != "" {
+		scheme = forwarded
+	}
+
+	director := func(req *http.Request) {
+		req.URL.Scheme = "http"
+		req.URL.Host = fmt.Sprintf("localhost:%d", public)
+		req.URL.Path = "/" + strings.TrimPrefix(path, "/")
+		req.URL.RawPath = ""
+
+		// Remove what was meant for the herder
+		query := req.URL.Query()
+		query.Del("token")
+		query.Del("share")
+		req.URL.RawQuery = query.Encode()
+		cookies := req.Cookies()
+		req.Header.Del("Cookie")
+		for _, cookie := range cookies {
+			if !strings.HasPrefix(cookie.Name, "herder-proxy-") {
+				req.AddCookie(cookie)
+			}
+		}
+
+		req.Header.Set("X-Forwarded-Host", r.Host)
+		req.Header.Set("X-Forwarded-Proto", scheme)
+		if prefix != "" {
+			req.Header.Set("X-Forwarded-Prefix", prefix)
+		}
+	}
+
+	log.WithField("name", name).WithField("port", public).WithField("path", path).Debug("Proxy request made")
+	(&httputil.ReverseProxy{Director: director}).ServeHTTP(w, r)
+}
+
+// ProxyHandler returns a handler proxying requests on .../proxy/<name>[:<port>]/<path> to <path> on the given port
+// of the daemon - the first port given when the daemon was spawned if no port is given.
+func ProxyHandler(m *token.Manager) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		segment, ok := vars["name"]
+		if !ok {
+			http.Error(w, "No name given", 404)
+			return
+		}
+
+		name, port := segment, 0
+		if i := strings.LastIndex(segment, ":"); i >= 0 {
+			var err error
+			if port, err = strconv.Atoi(segment[i+1:]); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid port: %s", segment[i+1:]), 400 /* Bad request */)
+				return
+			}
+			name = segment[:i]
+		}
+
+		// Split the path in prefix (up to and including the name) and the path in the daemon
+		i := strings.Index(r.URL.Path, "/proxy/"+segment)
+		if i < 0 {
+			http.Error(w, "No name given", 404)
+			return
+		}
+		base := r.URL.Path[:i+len("/proxy/")]
+		prefix := base + segment
+		path := r.URL.Path[len(prefix):]
+
+		// Redirect to the root of the daemon so relative urls work
+		if path == "" {
+			target := prefix + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, 302 /* Found */)
+			return
+		}
+
+		// The grant is only sent to this daemon - not to other daemons behind the proxy
+		proxy(m, w, r, name, port, path, prefix, prefix)
+	}
+}
+
+// ProxyHostHandler returns a handler proxying requests to the same path on the given port of the daemon with the
+// given name (the first port given when the daemon was spawned if port is 0). It is used when the daemon is reached
+// on a host of its own - see herder.HostRouter.
+func ProxyHostHandler(m *token.Manager, name string, port int) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		proxy(m, w, r, name, port, r.URL.Path, "", "/")
+	}
+}
+
+// Share returns a signed link to the daemon with the given name which is valid for the given duration.
+// The daemon must be owned by the owner of the token and have the shared access policy.
+func Share(token *jwt.Token, name string, ttl time.Duration) (string, time.Time, error) {
+	c, _, err := owned(token, name)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if c.Labels["access"] != AccessShared {
+		return "", time.Time{}, fmt.Errorf("Daemon %s can not be shared - it must be spawned with access=shared", name)
+	}
+	if ttl <= 0 || ttl > maxShareTTL {
+		return "", time.Time{}, fmt.Errorf("Share links must be valid for more than 0 and at most %v", maxShareTTL)
+	}
+	expires := time.Now().Add(ttl)
+	return grant(name, expires), expires, nil
+}
+
+// ShareLink is a signed, time-limited link to a daemon.
+type ShareLink struct {
+	URL     string
+	Expires time.Time
+}
+
+// ShareHandler handles share requests. The duration of the link is given by the query param ttl (e.g. 2h) - 1h by default.
+func ShareHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
+	vars := mux.Vars(r)
+	name, ok := vars["name"]
+	if !ok {
+		http.Error(w, "No name given", 404)
+		return
+	}
+
+	ttl := time.Hour
+	if value := r.URL.Query().Get("ttl"); value != "" {
+		var err error
+		if ttl, err = time.ParseDuration(value); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid ttl: %s", value), 400 /* Bad request */)
+			return
+		}
+	}
+
+	g, expires, err := Share(token, name, ttl)
+	if err != nil {
+		http.Error(w, err.Error(), 400 /* Bad request */)
+		return
+	}
+
+	s, err := json.Marshal(ShareLink{URL: proxyURL(r, name) + "/?share=" + g, Expires: expires})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Write(s)
+}
@@ -0,0 +1,129 @@
+package daemon
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Webstrates/golem-herder/token"
+	jwt "github.com/dgrijalva/jwt-go"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestGrants(t *testing.T) {
+	expires := time.Now().Add(time.Hour)
+	g := grant("a", expires)
+	if !validGrant("a", g) {
+		t.Errorf("Grant %q for a not valid", g)
+	}
+
+	signature := strings.SplitN(g, ".", 2)[1]
+	forged := strings.Repeat("0", len(signature))
+	for _, test := range []struct {
+		description string
+		name        string
+		grant       string
+	}{
+		{"expired", "a", grant("a", time.Now().Add(-time.Second))},
+		{"for another daemon", "b", g},
+		{"with a later expiry", "a", strings.Replace(g, strings.SplitN(g, ".", 2)[0], "9999999999", 1)},
+		{"with a forged signature", "a", strings.SplitN(g, ".", 2)[0] + "." + forged},
+		{"without a signature", "a", strings.SplitN(g, ".", 2)[0]},
+		{"with an invalid expiry", "a", "soon." + signature},
+		{"empty", "a", ""},
+	} {
+		if validGrant(test.name, test.grant) {
+			t.Errorf("Grant %s (%q) is valid", test.description, test.grant)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	// No tokens are given so the manager never needs its keys
+	m := &token.Manager{}
+	c := func(access string) *docker.APIContainers {
+		return &docker.APIContainers{Labels: map[string]string{"access": access, "subject": "me@example.com"}}
+	}
+	authorized := func(access, query string, g string) (bool, string) {
+		r := httptest.NewRequest("GET", "/daemon/v1/proxy/a/?"+query, nil)
+		if g != "" {
+			r.Header.Set("Cookie", cookieName("a")+"="+g)
+		}
+		w := httptest.NewRecorder()
+		ok := authorize(m, w, r, c(access), "a", "/daemon/v1/proxy/a")
+		return ok, w.Header().Get("Set-Cookie")
+	}
+
+	share := grant("a", time.Now().Add(time.Hour))
+	if ok, _ := authorized(AccessPublic, "", ""); !ok {
+		t.Error("Public daemon not authorized")
+	}
+	if ok, cookie := authorized(AccessShared, "share="+share, ""); !ok || !strings.Contains(cookie, share) || !strings.Contains(cookie, "Path=/daemon/v1/proxy/a") {
+		t.Errorf("Share link not authorized for shared daemon (cookie %q)", cookie)
+	}
+	if ok, _ := authorized(AccessShared, "", share); !ok {
+		t.Error("Cookie of share link not authorized")
+	}
+	for _, test := range []struct {
+		description   string
+		access, query string
+		cookie        string
+	}{
+		{"Share link for owner daemon", AccessOwner, "share=" + share, ""},
+		{"Share link for another daemon", AccessShared, "share=" + grant("b", time.Now().Add(time.Hour)), ""},
+		{"Expired share link", AccessShared, "share=" + grant("a", time.Now().Add(-time.Second)), ""},
+		{"Cookie for another daemon", AccessShared, "", grant("b", time.Now().Add(time.Hour))},
+		{"No token", AccessOwner, "", ""},
+	} {
+		if ok, cookie := authorized(test.access, test.query, test.cookie); ok || cookie != "" {
+			t.Errorf("%s authorized (cookie %q)", test.description, cookie)
+		}
+	}
+}
+
+func TestShare(t *testing.T) {
+	setup(t)
+
+	token, shared := spawn(t, "echo", "share@example.com", 100, Options{Access: AccessShared, Ports: []int{80}, Done: make(chan bool, 1)})
+	defer Kill(shared.Name, true, token)
+	ownerToken, owner := spawn(t, "echo", "share@example.com", 100, Options{Ports: []int{80}, Done: make(chan bool, 1)})
+	defer Kill(owner.Name, true, ownerToken)
+	publicToken, public := spawn(t, "echo", "share@example.com", 100, Options{Access: AccessPublic, Ports: []int{80}, Done: make(chan bool, 1)})
+	defer Kill(public.Name, true, publicToken)
+
+	g, expires, err := Share(token, shared.Name, time.Hour)
+	if err != nil || !validGrant(shared.Name, g) || time.Until(expires) > time.Hour {
+		t.Errorf("Invalid share link %q (expires %v): %v", g, expires, err)
+	}
+	for _, ttl := range []time.Duration{0, -time.Hour, maxShareTTL + time.Hour} {
+		if _, _, err := Share(token, shared.Name, ttl); err == nil {
+			t.Errorf("Share link valid for %v given", ttl)
+		}
+	}
+	if _, _, err := Share(token, owner.Name, time.Hour); err == nil {
+		t.Error("Share link given for daemon without access=shared")
+	}
+	other := &jwt.Token{Claims: jwt.MapClaims{"sub": "other@example.com", "jti": "other"}}
+	if _, _, err := Share(other, shared.Name, time.Hour); err == nil {
+		t.Error("Share link given to another owner")
+	}
+
+	// Only public daemons are published on all addresses of the host
+	for name, hostIP := range map[string]string{shared.Name: "127.0.0.1", owner.Name: "127.0.0.1", public.Name: "0.0.0.0"} {
+		c, err := fake.InspectContainer(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(c.HostConfig.PortBindings) == 0 {
+			t.Errorf("No ports of %s published", name)
+		}
+		for port, bindings := range c.HostConfig.PortBindings {
+			for _, binding := range bindings {
+				if binding.HostIP != hostIP {
+					t.Errorf("Port %s of %s published on %q - expected %q", port, name, binding.HostIP, hostIP)
+				}
+			}
+		}
+	}
+}
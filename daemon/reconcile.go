@@ -0,0 +1,90 @@
+package daemon
+
+import (
+	"strings"
+
+	"github.com/Webstrates/golem-herder/container"
+	"github.com/Webstrates/golem-herder/metering"
+	docker "github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReconcileReport describes what was done to the daemons found when reconciling.
+type ReconcileReport struct {
+	// Attached are running daemons which are metered and monitored again
+	Attached []string
+	// Killed are running daemons whose subject has no credits left
+	Killed []string
+	// Removed are daemons which exited while the herder was not running
+	Removed []string
+	// Stopped are daemons which were stopped and are kept until they are started again
+	Stopped []string
+}
+
+// Reconcile finds the daemons left behind by a previous herder (by their subject and tokenid labels)
+// and re-attaches meters and monitors to them.
+func Reconcile() (*ReconcileReport, error) {
+	containers, err := container.List(nil, container.And(container.HasLabel("subject"), container.HasLabel("tokenid")), true)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReconcileReport{Attached: []string{}, Killed: []string{}, Removed: []string{}, Stopped: []string{}}
+	for _, c := range containers {
+		if len(c.Names) == 0 {
+			continue
+		}
+		name := strings.TrimPrefix(c.Names[0], "/")
+		subject := c.Labels["subject"]
+		logger := log.WithField("name", name).WithField("subject", subject)
+
+		if c.State != "running" && isStopped(name) {
+			logger.Info("Keeping stopped daemon")
+			report.Stopped = append(report.Stopped, name)
+			retainLogs(name, subject, c.ID, false)
+			continue
+		}
+
+		if c.State != "running" {
+			logger.Info("Removing daemon which exited while herder was down")
+			report.Removed = append(report.Removed, name)
+			retainLogs(name, subject, c.ID, false)
+		} else {
+			go retainLogs(name, subject, c.ID, true)
+		}
+
+		done := make(chan docker.State, 1)
+		if err := container.Monitor(name, done); err != nil {
+			logger.WithError(err).Warn("Could not monitor daemon")
+			continue
+		}
+		go func(name, subject string) {
+			state := <-done
+			unmeter(name)
+			recordExit(name, subject, nil, state)
+		}(name, subject)
+
+		if c.State != "running" {
+			continue
+		}
+
+		m, err := metering.ExistingMeter(subject)
+		if err == nil {
+			var credits int
+			if credits, err = m.Credits(); err == nil && credits > 0 {
+				logger.Info("Re-attaching meter to daemon")
+				meter(name, m)
+				report.Attached = append(report.Attached, name)
+				continue
+			}
+		}
+
+		logger.WithError(err).Warn("No credits left for daemon - kill")
+		if err := container.Kill(container.WithID(c.ID), false, false); err != nil {
+			logger.WithError(err).Warn("Error killing container")
+			continue
+		}
+		report.Killed = append(report.Killed, name)
+	}
+	return report, nil
+}
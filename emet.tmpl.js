@@ -20,12 +20,17 @@
     To *reload* the golem, i.e. reset its state and run it
     again:
 
-    $ curl https://{{ .BaseURL }}/golem/v1/reset/<webstrate-id>
+    $ curl -X POST -H "Authorization: Bearer <token>" \
+        https://{{ .BaseURL }}/golem/v1/reset/<webstrate-id>
 
     To *kill* the golem (the golem will respawn the next
     time the page is loaded):
 
-    $ curl https://{{ .BaseURL }}/golem/v1/kill/<webstrate-id>
+    $ curl -X POST -H "Authorization: Bearer <token>" \
+        https://{{ .BaseURL }}/golem/v1/kill/<webstrate-id>
+
+    The token must be given access to the webstrate, see
+    https://github.com/Webstrates/golem-herder
                                                                 
 */
 
@@ -33,24 +38,27 @@
   if (!/headless/i.exec(navigator.userAgent)) { 
     // You're human, probably.
     // Send request to spawn golem, multiple requests
-    // for the same webstrate will be ignored
+    // for the same webstrate give the same golem
     var spawnRequest = new XMLHttpRequest();
     spawnRequest.onreadystatechange = function() {
       if (spawnRequest.readyState === XMLHttpRequest.DONE) {
-        if (spawnRequest.status === 200) {
+        if (spawnRequest.status === 201) {
           console.log("Golem spawned for "+webstrate.webstrateId);
+        } else if (spawnRequest.status === 200) {
+          console.log("Golem already running for "+webstrate.webstrateId);
         } else {
           console.warn("Golem could not be spawned - "+spawnRequest.responseText);
         }
       }
     }; 
-    spawnRequest.open('GET', 'https://{{ .BaseURL }}/golem/v1/spawn/'+webstrate.webstrateId, true);
+    // The ticket (if the herder requires one) allows spawning
+    // a golem on this webstrate for a while
+    var ticket = "{{ .Ticket }}";
+    spawnRequest.open('POST', 'https://{{ .BaseURL }}/golem/v1/spawn/'+webstrate.webstrateId+(ticket ? '?ticket='+ticket : ''), true);
     spawnRequest.send();
   } else {
     // You're a golem, most likely.
-    // Bootstrap yourself. Rise! 
-    window.setTimeout(function() {
-      new Function(document.querySelector(".golem,#emet").textContent)();
-    }, 5000);
+    // The herder will bootstrap you once the webstrate has
+    // loaded. Rise!
   }
 })();
@@ -0,0 +1,161 @@
+package golem
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Webstrates/golem-herder/token"
+	jwt "github.com/dgrijalva/jwt-go"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// TicketTTL is how long a spawn ticket is valid unless another ttl is asked for
+const TicketTTL = 30 * 24 * time.Hour
+
+// MaxTicketTTL is the longest a spawn ticket can be valid
+const MaxTicketTTL = 365 * 24 * time.Hour
+
+// devToolsGrantTTL is how long the developer tools of a golem may be used after giving a token
+const devToolsGrantTTL = 12 * time.Hour
+
+// devToolsCookie is the cookie holding the grant to use the developer tools of a golem
+const devToolsCookie = "herder-devtools"
+
+var (
+	// ErrNotAllowed is returned when a golem may not be spawned on a webstrate
+	ErrNotAllowed = fmt.Errorf("Golems are not allowed on this webstrate")
+
+	secret     []byte
+	secretOnce sync.Once
+)
+
+// ticketSecret returns the key spawn tickets are signed with. Unless "golem-secret" is set a random key is used
+// and tickets will not survive a restart of the herder.
+func ticketSecret() []byte {
+	secretOnce.Do(func() {
+		if s := viper.GetString("golem-secret"); s != "" {
+			secret = []byte(s)
+			return
+		}
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.WithError(err).Panic("Could not generate golem secret")
+		}
+	})
+	return secret
+}
+
+// matchesAny tells whether the webstrate matches any of the given patterns (see path.Match).
+func matchesAny(patterns []string, webstrate string) bool {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, webstrate); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed tells whether golems may be spawned on the given webstrate - that is if it matches one of the
+// "golem-allow" patterns (or none are given) and none of the "golem-deny" patterns.
+func Allowed(webstrate string) bool {
+	if allow := viper.GetStringSlice("golem-allow"); len(allow) > 0 && !matchesAny(allow, webstrate) {
+		return false
+	}
+	return !matchesAny(viper.GetStringSlice("golem-deny"), webstrate)
+}
+
+// TicketsRequired tells whether spawning a golem requires a spawn ticket (or a token scoped to the webstrate).
+func TicketsRequired() bool {
+	return viper.GetBool("golem-spawn-tickets")
+}
+
+// sign returns a signed permission to do what (e.g. spawn) with the golem on the given webstrate until expires.
+func sign(what, webstrate string, expires time.Time) string {
+	mac := hmac.New(sha256.New, ticketSecret())
+	fmt.Fprintf(mac, "%s\n%s\n%d", what, webstrate, expires.Unix())
+	return fmt.Sprintf("%d.%s", expires.Unix(), hex.EncodeToString(mac.Sum(nil)))
+}
+
+// validSignature tells whether the given permission to do what with the golem on the given webstrate is valid
+// and unexpired.
+func validSignature(what, webstrate, signed string) bool {
+	parts := strings.SplitN(signed, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(sign(what, webstrate, time.Unix(expires, 0))), []byte(signed))
+}
+
+// Ticket returns a signed ticket to spawn a golem on the given webstrate until expires.
+func Ticket(webstrate string, expires time.Time) string {
+	return sign("spawn", webstrate, expires)
+}
+
+// ValidTicket tells whether the given ticket to spawn a golem on the given webstrate is valid and unexpired.
+func ValidTicket(webstrate, ticket string) bool {
+	return validSignature("spawn", webstrate, ticket)
+}
+
+// AuthorizeDevTools checks that the request may use the developer tools of the golem on the given webstrate -
+// that is if it gives a token scoped to the webstrate (see Scoped) or the cookie set when one was given. The
+// developer tools give full control of the page (and the credentials given to it) so they are never public.
+func AuthorizeDevTools(m *token.Manager, w http.ResponseWriter, r *http.Request, webstrate string) bool {
+	if cookie, err := r.Cookie(devToolsCookie); err == nil && validSignature("devtools", webstrate, cookie.Value) {
+		return true
+	}
+	t, err := m.ValidateRequest(r)
+	if err != nil || !Scoped(t, webstrate) {
+		return false
+	}
+	expires := time.Now().Add(devToolsGrantTTL)
+	// The cookie is only sent to the host of the golem (no domain is given)
+	http.SetCookie(w, &http.Cookie{
+		Name:     devToolsCookie,
+		Value:    sign("devtools", webstrate, expires),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	// The token is for the herder - not the golem
+	r.Header.Del("Authorization")
+	return true
+}
+
+// Scoped tells whether the token is scoped to the given webstrate - that is if one of the patterns in its "wst"
+// claim matches the webstrate.
+func Scoped(token *jwt.Token, webstrate string) bool {
+	if token == nil {
+		return false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return false
+	}
+	scopes, ok := claims["wst"].([]interface{})
+	if !ok {
+		return false
+	}
+	patterns := []string{}
+	for _, scope := range scopes {
+		if pattern, ok := scope.(string); ok {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return matchesAny(patterns, webstrate)
+}
@@ -0,0 +1,87 @@
+package golem
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/spf13/viper"
+)
+
+func TestSignatures(t *testing.T) {
+	expires := time.Now().Add(time.Hour)
+	signed := sign("spawn", "ws", expires)
+	if !validSignature("spawn", "ws", signed) || !ValidTicket("ws", Ticket("ws", expires)) {
+		t.Errorf("Signature %q not valid", signed)
+	}
+
+	parts := strings.SplitN(signed, ".", 2)
+	for _, test := range []struct {
+		description     string
+		what, webstrate string
+		signed          string
+	}{
+		{"expired", "spawn", "ws", sign("spawn", "ws", time.Now().Add(-time.Second))},
+		{"for another webstrate", "spawn", "other", signed},
+		{"for something else", "devtools", "ws", signed},
+		{"with a later expiry", "spawn", "ws", "9999999999." + parts[1]},
+		{"with a forged signature", "spawn", "ws", parts[0] + "." + strings.Repeat("0", len(parts[1]))},
+		{"without a signature", "spawn", "ws", parts[0]},
+		{"with an invalid expiry", "spawn", "ws", "soon." + parts[1]},
+		{"empty", "spawn", "ws", ""},
+	} {
+		if validSignature(test.what, test.webstrate, test.signed) {
+			t.Errorf("Signature %s (%q) is valid", test.description, test.signed)
+		}
+	}
+	if ValidTicket("other", Ticket("ws", expires)) {
+		t.Error("Ticket valid for another webstrate")
+	}
+}
+
+func TestScoped(t *testing.T) {
+	for _, test := range []struct {
+		token     *jwt.Token
+		webstrate string
+		scoped    bool
+	}{
+		{&jwt.Token{Claims: jwt.MapClaims{"wst": []interface{}{"ws"}}}, "ws", true},
+		{&jwt.Token{Claims: jwt.MapClaims{"wst": []interface{}{"other", "my-*"}}}, "my-ws", true},
+		{&jwt.Token{Claims: jwt.MapClaims{"wst": []interface{}{"other", "my-*"}}}, "ws", false},
+		{&jwt.Token{Claims: jwt.MapClaims{"wst": []interface{}{"ws-*"}}}, "ws", false},
+		{&jwt.Token{Claims: jwt.MapClaims{"wst": []interface{}{42, "["}}}, "ws", false},
+		{&jwt.Token{Claims: jwt.MapClaims{"wst": "ws"}}, "ws", false},
+		{&jwt.Token{Claims: jwt.MapClaims{"sub": "me@example.com"}}, "ws", false},
+		{nil, "ws", false},
+	} {
+		var claims interface{}
+		if test.token != nil {
+			claims = test.token.Claims
+		}
+		if scoped := Scoped(test.token, test.webstrate); scoped != test.scoped {
+			t.Errorf("Token with %v scoped to %s: %v - expected %v", claims, test.webstrate, scoped, test.scoped)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	defer viper.Set("golem-allow", nil)
+	defer viper.Set("golem-deny", nil)
+
+	viper.Set("golem-deny", []string{"secret-*"})
+	for webstrate, allowed := range map[string]bool{"ws": true, "secret-ws": false} {
+		if Allowed(webstrate) != allowed {
+			t.Errorf("Golems allowed on %s without allow patterns: %v - expected %v", webstrate, !allowed, allowed)
+		}
+	}
+
+	// Deny patterns take precedence over allow patterns
+	viper.Set("golem-allow", []string{"public-*", "secret-*"})
+	viper.Set("golem-deny", []string{"public-secret*", "secret-*"})
+	for webstrate, allowed := range map[string]bool{"public-ws": true, "public-secret-ws": false, "secret-ws": false, "ws": false} {
+		if Allowed(webstrate) != allowed {
+			t.Errorf("Golems allowed on %s: %v - expected %v", webstrate, !allowed, allowed)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package golem
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// States of the bootstrap of a golem.
+const (
+	// BootstrapPending is the state until the page of the golem can be reached
+	BootstrapPending = "pending"
+	// BootstrapRunning is the state while waiting for the webstrate to load and running the golem code
+	BootstrapRunning = "running"
+	// BootstrapRetrying is the state after a failed attempt while the page is reloaded for the next attempt
+	BootstrapRetrying = "retrying"
+	// BootstrapSucceeded is the state when the golem code has run (and the promise it returned has resolved)
+	BootstrapSucceeded = "succeeded"
+	// BootstrapFailed is the state when all attempts have failed
+	BootstrapFailed = "failed"
+)
+
+// BootstrapStatus is the state of the bootstrap of the golem on a webstrate.
+type BootstrapStatus struct {
+	State    string
+	Attempts int    `json:",omitempty"`
+	Error    string `json:",omitempty"`
+	Time     time.Time
+}
+
+// bootstrapMarker is set on the window of the page once the golem code has been run on it
+const bootstrapMarker = "__herderBootstrapped"
+
+// loadedExpression resolves once the webstrate shown by the page has loaded.
+const loadedExpression = `new Promise(function(resolve) {
+	(function wait() {
+		if (window.webstrate && typeof window.webstrate.on === "function") {
+			if (window.webstrate.loaded) {
+				resolve(true);
+			} else {
+				window.webstrate.on("loaded", function() { resolve(true); });
+			}
+		} else {
+			window.setTimeout(wait, 100);
+		}
+	})();
+})`
+
+// runExpression runs the golem code found in the element matching the selector (%s) - returning what it returns.
+const runExpression = `(function(selector) {
+	window[%q] = true;
+	var element = document.querySelector(selector);
+	if (!element) {
+		throw new Error("No element matches " + selector);
+	}
+	return new Function(element.textContent)();
+})(%s)`
+
+var (
+	bootstraps         = map[string]BootstrapStatus{}
+	bootstrapFollowers = map[string]map[chan BootstrapStatus]bool{}
+	bootstrapsMutex    = &sync.Mutex{}
+	bootstrapOnce      sync.Once
+)
+
+// setBootstrap records the bootstrap status of the golem on the given webstrate and passes it on to followers.
+func setBootstrap(webstrate string, status BootstrapStatus) {
+	status.Time = time.Now()
+	bootstrapsMutex.Lock()
+	defer bootstrapsMutex.Unlock()
+	bootstraps[webstrate] = status
+	for follower := range bootstrapFollowers[webstrate] {
+		select {
+		case follower <- status:
+		default:
+			// the follower does not keep up
+		}
+	}
+}
+
+// BootstrapOf returns the bootstrap status of the golem on the given webstrate.
+func BootstrapOf(webstrate string) (BootstrapStatus, bool) {
+	bootstrapsMutex.Lock()
+	defer bootstrapsMutex.Unlock()
+	status, ok := bootstraps[webstrate]
+	return status, ok
+}
+
+// FollowBootstrap returns a channel receiving changes to the bootstrap status of the golem on the given webstrate
+// and a function to stop following.
+func FollowBootstrap(webstrate string) (<-chan BootstrapStatus, func()) {
+	follower := make(chan BootstrapStatus, 10)
+	bootstrapsMutex.Lock()
+	if bootstrapFollowers[webstrate] == nil {
+		bootstrapFollowers[webstrate] = map[chan BootstrapStatus]bool{}
+	}
+	bootstrapFollowers[webstrate][follower] = true
+	bootstrapsMutex.Unlock()
+	return follower, func() {
+		bootstrapsMutex.Lock()
+		delete(bootstrapFollowers[webstrate], follower)
+		if len(bootstrapFollowers[webstrate]) == 0 {
+			delete(bootstrapFollowers, webstrate)
+		}
+		bootstrapsMutex.Unlock()
+	}
+}
+
+// runGolemCode waits for the webstrate to load and runs the golem code in the page unless it has already been run.
+// It tells whether the code was run.
+func runGolemCode(ctx context.Context, c *CDP, webstrate string, attempt int) (bool, error) {
+	done, err := evaluate(ctx, c, "window."+bootstrapMarker+" === true")
+	if err != nil {
+		return false, err
+	}
+	if string(done) == "true" {
+		return false, nil
+	}
+
+	setBootstrap(webstrate, BootstrapStatus{State: BootstrapRunning, Attempts: attempt})
+	ctx, cancel := context.WithTimeout(ctx, viper.GetDuration("golem-bootstrap-timeout"))
+	defer cancel()
+	if _, err := evaluate(ctx, c, loadedExpression); err != nil {
+		if err == context.DeadlineExceeded {
+			return true, fmt.Errorf("Webstrate did not load in time")
+		}
+		return true, err
+	}
+	selector, err := json.Marshal(viper.GetString("golem-bootstrap-selector"))
+	if err != nil {
+		return true, err
+	}
+	if _, err := evaluate(ctx, c, fmt.Sprintf(runExpression, bootstrapMarker, selector)); err != nil {
+		if err == context.DeadlineExceeded {
+			return true, fmt.Errorf("Golem code did not finish in time")
+		}
+		return true, err
+	}
+	return true, nil
+}
+
+// bootstrapPage bootstraps the page shown by the golem on the given webstrate. A failed attempt reloads the page
+// so the golem code never runs twice in the same page - the next attempt is made when the page has loaded.
+func bootstrapPage(ctx context.Context, c *CDP, webstrate string) {
+	attempt := 1
+	if status, ok := BootstrapOf(webstrate); ok && status.State == BootstrapRetrying {
+		attempt = status.Attempts + 1
+	}
+
+	ran, err := runGolemCode(ctx, c, webstrate, attempt)
+	if !ran && err == nil {
+		return
+	}
+	if ctx.Err() != nil {
+		// The golem is gone
+		return
+	}
+	logger := log.WithField("webstrate", webstrate).WithField("attempt", attempt)
+	if err == nil {
+		logger.Info("Golem bootstrapped")
+		setBootstrap(webstrate, BootstrapStatus{State: BootstrapSucceeded, Attempts: attempt})
+		return
+	}
+
+	if attempt > viper.GetInt("golem-bootstrap-retries") {
+		logger.WithError(err).Warn("Could not bootstrap golem")
+		setBootstrap(webstrate, BootstrapStatus{State: BootstrapFailed, Attempts: attempt, Error: err.Error()})
+		return
+	}
+	logger.WithError(err).Info("Could not bootstrap golem - retrying")
+	setBootstrap(webstrate, BootstrapStatus{State: BootstrapRetrying, Attempts: attempt, Error: err.Error()})
+	if err := c.Call(ctx, "Page.reload", map[string]interface{}{"ignoreCache": true}, nil); err != nil {
+		logger.WithError(err).Warn("Could not reload golem for another bootstrap attempt")
+	}
+}
+
+// bootstrap runs the golem code in the page of the golem on the given webstrate - and again each time the page
+// is loaded - until stop is closed. The page is authenticated against the webstrates server first (see authenticate).
+func bootstrap(webstrate string, stop <-chan bool) {
+	setBootstrap(webstrate, BootstrapStatus{State: BootstrapPending})
+	logger := log.WithField("webstrate", webstrate)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	for {
+		c, err := Connect(ctx, webstrate)
+		if err != nil {
+			// The golem may still be starting
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+				continue
+			}
+		}
+
+		loads := c.Listen("Page.loadEventFired")
+		if err := c.Call(ctx, "Page.enable", nil, nil); err != nil {
+			logger.WithError(err).Warn("Could not follow page loads of golem")
+		}
+		navigated, err := authenticate(ctx, c, webstrate)
+		if err != nil {
+			logger.WithError(err).Warn("Could not authenticate golem against webstrates server")
+		}
+		if !navigated {
+			// The golem is bootstrapped once the webstrate has loaded otherwise
+			bootstrapPage(ctx, c, webstrate)
+		}
+	follow:
+		for {
+			select {
+			case _, ok := <-loads:
+				if !ok {
+					break follow
+				}
+				bootstrapPage(ctx, c, webstrate)
+			case <-ctx.Done():
+				break follow
+			}
+		}
+		c.Close()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// Bootstrap starts bootstrapping golems - running the golem code in their page once the webstrate has loaded.
+func Bootstrap() {
+	bootstrapOnce.Do(func() {
+		forEachGolem(bootstrap)
+	})
+}
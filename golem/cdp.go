@@ -0,0 +1,232 @@
+package golem
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+// devtoolsPort is the port of the remote debugging (Chrome DevTools Protocol) endpoint in golems
+const devtoolsPort = 9222
+
+// CDPError is an error returned by the browser for a Chrome DevTools Protocol call.
+type CDPError struct {
+	Code    int
+	Message string
+	Data    string `json:",omitempty"`
+}
+
+func (e *CDPError) Error() string {
+	return fmt.Sprintf("CDP error %d: %s", e.Code, e.Message)
+}
+
+// cdpMessage is a message on a Chrome DevTools Protocol connection - a call, a response or an event.
+type cdpMessage struct {
+	ID     int64           `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *CDPError       `json:"error,omitempty"`
+}
+
+// Event is an event sent by the browser on a Chrome DevTools Protocol connection.
+type Event struct {
+	Method string
+	Params json.RawMessage
+}
+
+// listener receives the events with the given methods.
+type listener struct {
+	methods map[string]bool
+	events  chan Event
+}
+
+// CDP is a Chrome DevTools Protocol connection to the page shown by a golem.
+type CDP struct {
+	conn       *websocket.Conn
+	writeMutex sync.Mutex
+
+	mutex     sync.Mutex
+	id        int64
+	pending   map[int64]chan cdpMessage
+	listeners []*listener
+	err       error
+	done      chan bool
+}
+
+// target is a page (or other target) in the browser of a golem as listed by the DevTools http endpoint.
+type target struct {
+	ID                   string `json:"id"`
+	Type                 string `json:"type"`
+	URL                  string `json:"url"`
+	Title                string `json:"title"`
+	WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+}
+
+// Connect opens a Chrome DevTools Protocol connection to the page shown by the golem on the given webstrate.
+func Connect(ctx context.Context, webstrate string) (*CDP, error) {
+	port, err := PortOf(webstrate, devtoolsPort)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://localhost:%d/json/list", port), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		log.WithError(err).WithField("webstrate", webstrate).Warn("Could not list golem targets")
+		return nil, err
+	}
+	defer resp.Body.Close()
+	targets := []target{}
+	if err := json.NewDecoder(resp.Body).Decode(&targets); err != nil {
+		return nil, err
+	}
+
+	for _, t := range targets {
+		if t.Type != "page" {
+			continue
+		}
+		// The debugger url given by chrome is not necessarily reachable from the herder
+		url := fmt.Sprintf("ws://localhost:%d/devtools/page/%s", port, t.ID)
+		conn, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+		if err != nil {
+			log.WithError(err).WithField("webstrate", webstrate).Warn("Could not connect to golem page")
+			return nil, err
+		}
+		c := &CDP{conn: conn, pending: map[int64]chan cdpMessage{}, done: make(chan bool)}
+		go c.read()
+		return c, nil
+	}
+	return nil, fmt.Errorf("No page found in golem for webstrate %s", webstrate)
+}
+
+// read dispatches the responses received on the connection until it is closed.
+func (c *CDP) read() {
+	var err error
+	for {
+		message := cdpMessage{}
+		if err = c.conn.ReadJSON(&message); err != nil {
+			break
+		}
+		c.mutex.Lock()
+		if message.ID == 0 {
+			c.dispatch(message)
+		} else if response, ok := c.pending[message.ID]; ok {
+			response <- message
+			delete(c.pending, message.ID)
+		}
+		c.mutex.Unlock()
+	}
+
+	c.mutex.Lock()
+	c.err = err
+	c.pending = map[int64]chan cdpMessage{}
+	for _, l := range c.listeners {
+		close(l.events)
+	}
+	c.listeners = nil
+	c.mutex.Unlock()
+	close(c.done)
+}
+
+// dispatch sends the event to the listeners of its method. Events are dropped for listeners which do not keep up.
+func (c *CDP) dispatch(message cdpMessage) {
+	event := Event{Method: message.Method, Params: message.Params}
+	for _, l := range c.listeners {
+		if !l.methods[event.Method] {
+			continue
+		}
+		select {
+		case l.events <- event:
+		default:
+			log.WithField("method", event.Method).Warn("Dropped golem event")
+		}
+	}
+}
+
+// Listen returns a channel receiving the events with the given methods. It is closed with the connection.
+// Remember to enable the domains of the events.
+func (c *CDP) Listen(methods ...string) <-chan Event {
+	l := &listener{methods: map[string]bool{}, events: make(chan Event, 100)}
+	for _, method := range methods {
+		l.methods[method] = true
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.err != nil {
+		close(l.events)
+	} else {
+		c.listeners = append(c.listeners, l)
+	}
+	return l.events
+}
+
+// Call calls the given method with the given params and unmarshals the result into result (unless it is nil).
+func (c *CDP) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	call := cdpMessage{Method: method}
+	if params != nil {
+		var err error
+		if call.Params, err = json.Marshal(params); err != nil {
+			return err
+		}
+	}
+
+	response := make(chan cdpMessage, 1)
+	c.mutex.Lock()
+	if c.err != nil {
+		c.mutex.Unlock()
+		return c.err
+	}
+	c.id++
+	id := c.id
+	c.pending[id] = response
+	c.mutex.Unlock()
+
+	call.ID = id
+	c.writeMutex.Lock()
+	err := c.conn.WriteJSON(call)
+	c.writeMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case message := <-response:
+		if message.Error != nil {
+			return message.Error
+		}
+		if result != nil {
+			return json.Unmarshal(message.Result, result)
+		}
+		return nil
+	case <-c.done:
+		return fmt.Errorf("Connection to golem closed during %s", method)
+	case <-ctx.Done():
+		c.mutex.Lock()
+		delete(c.pending, id)
+		c.mutex.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Done is closed when the connection is closed.
+func (c *CDP) Done() <-chan bool {
+	return c.done
+}
+
+// Close closes the connection.
+func (c *CDP) Close() error {
+	c.writeMutex.Lock()
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	c.writeMutex.Unlock()
+	return c.conn.Close()
+}
@@ -0,0 +1,281 @@
+package golem
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// callTimeout is how long a control request to a golem may take
+const callTimeout = 10 * time.Second
+
+// remoteObject is a value in the page as given by the Runtime domain.
+type remoteObject struct {
+	Type        string          `json:"type"`
+	Value       json.RawMessage `json:"value"`
+	Description string          `json:"description"`
+}
+
+// exceptionDetails describes an exception thrown in the page.
+type exceptionDetails struct {
+	Text      string        `json:"text"`
+	Exception *remoteObject `json:"exception"`
+}
+
+// EvalError is returned when the evaluated expression throws.
+type EvalError struct {
+	Message string
+}
+
+func (e *EvalError) Error() string {
+	return e.Message
+}
+
+// Location is the page currently shown by a golem.
+type Location struct {
+	URL   string
+	Title string
+}
+
+// withCDP connects to the golem on the given webstrate and runs f with the connection.
+func withCDP(ctx context.Context, webstrate string, f func(c *CDP) error) error {
+	c, err := Connect(ctx, webstrate)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return f(c)
+}
+
+// evaluate evaluates the expression on the connection and returns its (json) value - promises are awaited.
+func evaluate(ctx context.Context, c *CDP, expression string) (json.RawMessage, error) {
+	result := struct {
+		Result           remoteObject      `json:"result"`
+		ExceptionDetails *exceptionDetails `json:"exceptionDetails"`
+	}{}
+	params := map[string]interface{}{
+		"expression":    expression,
+		"returnByValue": true,
+		"awaitPromise":  true,
+	}
+	if err := c.Call(ctx, "Runtime.evaluate", params, &result); err != nil {
+		return nil, err
+	}
+	if details := result.ExceptionDetails; details != nil {
+		message := details.Text
+		if details.Exception != nil && details.Exception.Description != "" {
+			message = details.Exception.Description
+		}
+		return nil, &EvalError{Message: message}
+	}
+	if len(result.Result.Value) == 0 {
+		// undefined (or a value which can not be serialized)
+		return json.RawMessage("null"), nil
+	}
+	return result.Result.Value, nil
+}
+
+// Evaluate evaluates the javascript expression in the page of the golem on the given webstrate and returns its
+// value as json. If the expression gives a promise its resolved value is returned.
+func Evaluate(ctx context.Context, webstrate, expression string) (json.RawMessage, error) {
+	var value json.RawMessage
+	err := withCDP(ctx, webstrate, func(c *CDP) error {
+		var err error
+		value, err = evaluate(ctx, c, expression)
+		return err
+	})
+	return value, err
+}
+
+// Reload reloads the page of the golem on the given webstrate.
+func Reload(ctx context.Context, webstrate string, ignoreCache bool) error {
+	return withCDP(ctx, webstrate, func(c *CDP) error {
+		return c.Call(ctx, "Page.reload", map[string]interface{}{"ignoreCache": ignoreCache}, nil)
+	})
+}
+
+// Navigate makes the golem on the given webstrate show the page at the given url.
+func Navigate(ctx context.Context, webstrate, url string) error {
+	return withCDP(ctx, webstrate, func(c *CDP) error {
+		result := struct {
+			ErrorText string `json:"errorText"`
+		}{}
+		if err := c.Call(ctx, "Page.navigate", map[string]interface{}{"url": url}, &result); err != nil {
+			return err
+		}
+		if result.ErrorText != "" {
+			return fmt.Errorf("Could not navigate to %s: %s", url, result.ErrorText)
+		}
+		return nil
+	})
+}
+
+// GetLocation returns the url and title of the page shown by the golem on the given webstrate.
+func GetLocation(ctx context.Context, webstrate string) (*Location, error) {
+	var location *Location
+	err := withCDP(ctx, webstrate, func(c *CDP) error {
+		history := struct {
+			CurrentIndex int `json:"currentIndex"`
+			Entries      []struct {
+				URL   string `json:"url"`
+				Title string `json:"title"`
+			} `json:"entries"`
+		}{}
+		if err := c.Call(ctx, "Page.getNavigationHistory", nil, &history); err != nil {
+			return err
+		}
+		if history.CurrentIndex < 0 || history.CurrentIndex >= len(history.Entries) {
+			return fmt.Errorf("Golem has no current page")
+		}
+		entry := history.Entries[history.CurrentIndex]
+		location = &Location{URL: entry.URL, Title: entry.Title}
+		return nil
+	})
+	return location, err
+}
+
+// controlError writes the error from a control request to a golem.
+func controlError(w http.ResponseWriter, webstrate string, err error) {
+	switch err.(type) {
+	case *EvalError:
+		http.Error(w, err.Error(), 422 /* Unprocessable entity */)
+	case *CDPError:
+		http.Error(w, err.Error(), 502 /* Bad gateway */)
+	default:
+		if err == ErrNotFound {
+			http.Error(w, err.Error(), 404)
+			return
+		}
+		if err == ErrNotAllowed {
+			http.Error(w, err.Error(), 403 /* Forbidden */)
+			return
+		}
+		if err == ErrCapacity {
+			http.Error(w, err.Error(), 503 /* Service unavailable */)
+			return
+		}
+		log.WithError(err).WithField("webstrate", webstrate).Warn("Golem control request failed")
+		if err == context.DeadlineExceeded {
+			http.Error(w, "Golem did not respond in time", 504 /* Gateway timeout */)
+			return
+		}
+		http.Error(w, err.Error(), 502 /* Bad gateway */)
+	}
+}
+
+// authorized checks that the token is scoped to the webstrate of a control request (see Scoped).
+func authorized(w http.ResponseWriter, webstrate string, token *jwt.Token) bool {
+	if !Scoped(token, webstrate) {
+		http.Error(w, "Token does not give access to the golem of this webstrate", 403 /* Forbidden */)
+		return false
+	}
+	return true
+}
+
+// writeJSON writes the given value as json.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	s, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(s)
+}
+
+// EvalHandler evaluates the javascript expression given as the request body (or the expression form value)
+// in the page of a golem and responds with its value as json.
+func EvalHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
+	webstrate := mux.Vars(r)["webstrate"]
+	if !authorized(w, webstrate, token) {
+		return
+	}
+
+	expression := r.FormValue("expression")
+	if expression == "" {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), 400 /* Bad request */)
+			return
+		}
+		expression = string(body)
+	}
+	if expression == "" {
+		http.Error(w, "No expression given", 400 /* Bad request */)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), callTimeout)
+	defer cancel()
+	value, err := Evaluate(ctx, webstrate, expression)
+	if err != nil {
+		controlError(w, webstrate, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(value)
+}
+
+// ReloadHandler reloads the page of a golem. Add ignoreCache=true to reload without the cache.
+func ReloadHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
+	webstrate := mux.Vars(r)["webstrate"]
+	if !authorized(w, webstrate, token) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), callTimeout)
+	defer cancel()
+	if err := Reload(ctx, webstrate, r.FormValue("ignoreCache") == "true"); err != nil {
+		controlError(w, webstrate, err)
+		return
+	}
+	w.Write([]byte(fmt.Sprintf("Golem for %s reloaded", webstrate)))
+}
+
+// NavigateHandler makes a golem show the (http or https) page given by the url form value.
+func NavigateHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
+	webstrate := mux.Vars(r)["webstrate"]
+	if !authorized(w, webstrate, token) {
+		return
+	}
+	url := r.FormValue("url")
+	if url == "" {
+		http.Error(w, "No url given", 400 /* Bad request */)
+		return
+	}
+	// Only web pages - not e.g. file: urls
+	if u, err := neturl.Parse(url); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		http.Error(w, "Only http(s) urls can be navigated to", 400 /* Bad request */)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), callTimeout)
+	defer cancel()
+	if err := Navigate(ctx, webstrate, url); err != nil {
+		controlError(w, webstrate, err)
+		return
+	}
+	w.Write([]byte(fmt.Sprintf("Golem for %s navigated to %s", webstrate, url)))
+}
+
+// LocationHandler responds with the url and title of the page shown by a golem.
+func LocationHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
+	webstrate := mux.Vars(r)["webstrate"]
+	if !authorized(w, webstrate, token) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), callTimeout)
+	defer cancel()
+	location, err := GetLocation(ctx, webstrate)
+	if err != nil {
+		controlError(w, webstrate, err)
+		return
+	}
+	writeJSON(w, location)
+}
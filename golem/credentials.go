@@ -0,0 +1,148 @@
+package golem
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Webstrates/golem-herder/container"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// blankPage is shown by golems until they are authenticated against the webstrates server (see authenticate)
+const blankPage = "about:blank"
+
+// webstrateURL returns the url of the given webstrate on the webstrates server.
+func webstrateURL(webstrate string) string {
+	return fmt.Sprintf("http://%s/%s", viper.GetString("webstrates"), webstrate)
+}
+
+// serverCredentials returns the credentials for the webstrates server - or nil if there are none.
+func serverCredentials() (*container.Credentials, error) {
+	return container.CredentialsFor(viper.GetString("webstrates"))
+}
+
+// authenticate gives the page of a golem the credentials for the webstrates server. Requests to the server get
+// the Authorization header (see authorizeRequests) and its cookies are set. Requests are only intercepted while
+// the connection is open. Golems with credentials are spawned showing a blank page - it is navigated to the
+// webstrate once authenticated. It tells whether the page was navigated.
+func authenticate(ctx context.Context, c *CDP, webstrate string) (bool, error) {
+	credentials, err := serverCredentials()
+	if err != nil || credentials == nil {
+		return false, err
+	}
+
+	if err := c.Call(ctx, "Network.enable", nil, nil); err != nil {
+		return false, err
+	}
+	if credentials.Authorization() != "" {
+		host := viper.GetString("webstrates")
+		events := c.Listen("Fetch.requestPaused", "Fetch.authRequired")
+		go authorizeRequests(ctx, c, host, credentials, events)
+		params := map[string]interface{}{
+			"patterns":           []map[string]string{{"urlPattern": fmt.Sprintf("*://%s/*", host)}},
+			"handleAuthRequests": true,
+		}
+		if err := c.Call(ctx, "Fetch.enable", params, nil); err != nil {
+			return false, err
+		}
+	}
+	cookies, err := credentials.ParseCookies()
+	if err != nil {
+		return false, err
+	}
+	if len(cookies) > 0 {
+		params := []map[string]interface{}{}
+		for _, cookie := range cookies {
+			params = append(params, map[string]interface{}{
+				"name":  cookie.Name,
+				"value": cookie.Value,
+				"url":   webstrateURL(""),
+			})
+		}
+		if err := c.Call(ctx, "Network.setCookies", map[string]interface{}{"cookies": params}, nil); err != nil {
+			return false, err
+		}
+	}
+
+	href, err := evaluate(ctx, c, "window.location.href")
+	if err != nil {
+		return false, err
+	}
+	if string(href) != fmt.Sprintf("%q", blankPage) {
+		return false, nil
+	}
+	if err := c.Call(ctx, "Page.navigate", map[string]interface{}{"url": webstrateURL(webstrate)}, nil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// pausedRequest is a request intercepted by the Fetch domain - or an auth challenge to it.
+type pausedRequest struct {
+	RequestID string `json:"requestId"`
+	Request   struct {
+		URL     string            `json:"url"`
+		Headers map[string]string `json:"headers"`
+	} `json:"request"`
+	AuthChallenge struct {
+		Source string `json:"source"`
+		Origin string `json:"origin"`
+	} `json:"authChallenge"`
+}
+
+// onHost tells whether the given url is on the given host (host or host:port).
+func onHost(u, host string) bool {
+	parsed, err := url.Parse(u)
+	return err == nil && parsed.Host == host
+}
+
+// authorizeRequests continues the intercepted requests of the page - adding the Authorization header given by the
+// credentials to requests to the given host only - and answers the auth challenges of the host with the
+// credentials. It returns when the events are closed (with the connection).
+func authorizeRequests(ctx context.Context, c *CDP, host string, credentials *container.Credentials, events <-chan Event) {
+	for event := range events {
+		paused := pausedRequest{}
+		if err := json.Unmarshal(event.Params, &paused); err != nil {
+			log.WithError(err).Warn("Could not read intercepted golem request")
+			continue
+		}
+
+		var err error
+		switch event.Method {
+		case "Fetch.requestPaused":
+			headers := []map[string]string{}
+			for name, value := range paused.Request.Headers {
+				if !strings.EqualFold(name, "Authorization") {
+					headers = append(headers, map[string]string{"name": name, "value": value})
+				}
+			}
+			if onHost(paused.Request.URL, host) {
+				headers = append(headers, map[string]string{"name": "Authorization", "value": credentials.Authorization()})
+			}
+			err = c.Call(ctx, "Fetch.continueRequest", map[string]interface{}{
+				"requestId": paused.RequestID,
+				"headers":   headers,
+			}, nil)
+		case "Fetch.authRequired":
+			response := map[string]string{"response": "Default"}
+			if paused.AuthChallenge.Source == "Server" && onHost(paused.AuthChallenge.Origin, host) && credentials.Username != "" {
+				response = map[string]string{
+					"response": "ProvideCredentials",
+					"username": credentials.Username,
+					"password": credentials.Password,
+				}
+			}
+			err = c.Call(ctx, "Fetch.continueWithAuth", map[string]interface{}{
+				"requestId":             paused.RequestID,
+				"authChallengeResponse": response,
+			}, nil)
+		}
+		if err != nil {
+			log.WithError(err).WithField("url", paused.Request.URL).Warn("Could not continue intercepted golem request")
+		}
+	}
+}
@@ -2,10 +2,7 @@ package golem
 
 import (
 	"fmt"
-	"io/ioutil"
-	"os"
-	"path/filepath"
-	"strings"
+	"sync"
 
 	"github.com/Webstrates/golem-herder/container"
 	log "github.com/sirupsen/logrus"
@@ -14,44 +11,114 @@ import (
 	docker "github.com/fsouza/go-dockerclient"
 )
 
+// ErrNotFound is returned when there is no golem on a webstrate.
+var ErrNotFound = fmt.Errorf("No container found for webstrate")
+
 func getName(id string) string {
 	return fmt.Sprintf("golem-%s", id)
 }
 
-// Spawn will create a new container and inject a golem into it
+// flight is a spawn of a golem in progress - callers spawning the same golem wait for it to be done.
+type flight struct {
+	done    chan bool
+	id      string
+	created bool
+	err     error
+}
+
+var (
+	// flights are the spawns in progress by webstrate
+	flights      = map[string]*flight{}
+	flightsMutex = &sync.Mutex{}
+)
+
+// Spawn will create a new container and inject a golem into it - unless a golem is already running on the webstrate.
+// The golem is run with the profile selected by the profile rules (see ProfileFor). The id of the container is returned.
 func Spawn(webstrateID string) (string, error) {
+	id, _, err := Ensure(webstrateID, "")
+	return id, err
+}
+
+// Ensure makes sure a golem is running on the given webstrate. A new golem is run with the given profile - or the
+// one selected by the profile rules if none is given. Concurrent calls for the same webstrate share a single spawn.
+// The id of the container is returned and whether it was created (or already running).
+func Ensure(webstrateID, profile string) (string, bool, error) {
+	flightsMutex.Lock()
+	if f, ok := flights[webstrateID]; ok {
+		flightsMutex.Unlock()
+		<-f.done
+		return f.id, false, f.err
+	}
+	f := &flight{done: make(chan bool)}
+	flights[webstrateID] = f
+	flightsMutex.Unlock()
 
-	client, err := docker.NewClientFromEnv()
+	f.id, f.created, f.err = spawn(webstrateID, profile)
+
+	flightsMutex.Lock()
+	delete(flights, webstrateID)
+	flightsMutex.Unlock()
+	close(f.done)
+	return f.id, f.created, f.err
+}
+
+// spawn creates and starts the container of the golem on the given webstrate with the given profile unless it is
+// already running. Dead containers of the golem are removed.
+func spawn(webstrateID, profile string) (string, bool, error) {
+
+	client, err := container.GetRuntime()
 	if err != nil {
-		log.WithError(err).Error("Could not create docker client")
-		return "", err
+		return "", false, err
+	}
+
+	existing, err := container.List(client, container.WithName(getName(webstrateID)), true)
+	if err != nil {
+		return "", false, err
+	}
+	for _, c := range existing {
+		if c.State == "running" {
+			return c.ID, false, nil
+		}
+		log.WithFields(log.Fields{"webstrateid": webstrateID, "containerid": c.ID}).Info("Removing dead golem container")
+		if err := client.RemoveContainer(docker.RemoveContainerOptions{ID: c.ID, Force: true, RemoveVolumes: true}); err != nil {
+			return "", false, err
+		}
 	}
 
-	repository := "webstrates/golem"
-	tag := "latest"
+	if !Allowed(webstrateID) {
+		return "", false, ErrNotAllowed
+	}
 
-	log.WithFields(log.Fields{"image": fmt.Sprintf("%s:%s", repository, tag)}).Info("Pulling image")
+	release, err := makeRoom(webstrateID)
+	if err != nil {
+		return "", false, err
+	}
+	defer release()
 
-	err = client.PullImage(docker.PullImageOptions{
-		Repository: "webstrates/golem",
-		Tag:        viper.GetString("golem"),
-	}, docker.AuthConfiguration{})
+	if profile == "" {
+		profile = ProfileFor(webstrateID)
+	}
+	p, err := GetProfile(profile)
 	if err != nil {
-		return "", err
+		log.WithError(err).Error("Could not get golem profile")
+		return "", false, err
 	}
-	log.WithFields(log.Fields{"image": fmt.Sprintf("%s:%s", repository, tag)}).Info("Pull done")
 
-	// Get current dir
-	dir, err := os.Getwd()
+	err = container.EnsureImage(client, p.Image, p.Tag)
 	if err != nil {
-		log.WithError(err).Error("Could not discover current directory")
-		return "", err
+		return "", false, err
 	}
 
-	seccomp, err := ioutil.ReadFile(filepath.Join(dir, "chrome.json"))
+	seccomp, err := p.seccomp()
 	if err != nil {
 		log.WithError(err).Error("Could not read seccomp profile")
-		return "", err
+		return "", false, err
+	}
+
+	resources, err := container.ResourceClass(p.Resources)
+	if err != nil {
+		log.WithError(err).Error("Could not get golem resources")
+		return "", false, err
 	}
 
 	// Links
@@ -60,72 +127,116 @@ func Spawn(webstrateID string) (string, error) {
 		links = []string{viper.GetString("webstrates")}
 	}
 
-	log.WithFields(log.Fields{"webstrateid": webstrateID}).Info("Creating container")
-	container, err := client.CreateContainer(
+	ports, err := container.ReservePorts(getName(webstrateID), 1)
+	if err != nil {
+		log.WithError(err).Error("Could not reserve port for golem")
+		return "", false, err
+	}
+
+	// The developer tools are only reached through the herder (see AuthorizeDevTools)
+	hostConfig := &docker.HostConfig{
+		Links: links,
+		PortBindings: map[docker.Port][]docker.PortBinding{
+			"9222/tcp": []docker.PortBinding{{
+				HostIP:   "127.0.0.1",
+				HostPort: fmt.Sprintf("%d", ports[0]),
+			},
+			},
+		},
+		SecurityOpt: []string{
+			fmt.Sprintf("seccomp=%s", string(seccomp)),
+		},
+	}
+	if err := resources.Apply(hostConfig); err != nil {
+		container.ReleasePorts(ports)
+		return "", false, err
+	}
+
+	cmd := []string{
+		"--headless",
+		"--ignore-certificate-errors",
+		"--disable-gpu",
+		"--remote-debugging-address=0.0.0.0",
+		"--remote-debugging-port=9222",
+	}
+	cmd = append(cmd, p.chromeFlags()...)
+	// Golems with credentials for the webstrates server are navigated to the webstrate once authenticated (see bootstrap)
+	credentials, err := serverCredentials()
+	if err != nil {
+		log.WithError(err).Error("Could not get credentials for webstrates server")
+		container.ReleasePorts(ports)
+		return "", false, err
+	}
+	if credentials != nil {
+		cmd = append(cmd, blankPage)
+	} else {
+		cmd = append(cmd, webstrateURL(webstrateID))
+	}
+
+	log.WithFields(log.Fields{"webstrateid": webstrateID, "profile": profile}).Info("Creating container")
+	c, err := client.CreateContainer(
 		docker.CreateContainerOptions{
 			Name: getName(webstrateID),
 			Config: &docker.Config{
-				Image:  "webstrates/golem:latest",
-				Labels: map[string]string{"webstrate": webstrateID},
+				Image:  container.ImageName(p.Image, p.Tag),
+				Labels: map[string]string{LabelWebstrate: webstrateID, LabelProfile: profile},
 				ExposedPorts: map[docker.Port]struct{}{
 					"9222/tcp": {},
 				},
-				Env: []string{fmt.Sprintf("WEBSTRATEID=%s", webstrateID)},
-				Cmd: []string{
-					"--headless",
-					"--ignore-certificate-errors",
-					"--disable-gpu",
-					"--remote-debugging-address=0.0.0.0",
-					"--remote-debugging-port=9222",
-					fmt.Sprintf("http://%s/%s", viper.GetString("webstrates"), webstrateID),
-				},
-			},
-			HostConfig: &docker.HostConfig{
-				Links: links,
-				PortBindings: map[docker.Port][]docker.PortBinding{
-					"9222/tcp": []docker.PortBinding{{
-						HostIP:   "0.0.0.0",
-						HostPort: fmt.Sprintf("%d", container.GetAvailableHostPort()),
-					},
-					},
-				},
-				SecurityOpt: []string{
-					fmt.Sprintf("seccomp=%s", string(seccomp)),
-				},
+				Env: append([]string{fmt.Sprintf("WEBSTRATEID=%s", webstrateID)}, p.Env...),
+				Cmd: cmd,
 			},
+			HostConfig: hostConfig,
 		},
 	)
 	if err != nil {
 		log.WithError(err).Error("Error creating container")
-		return "", err
+		container.ReleasePorts(ports)
+		return "", false, err
+	}
+	if err := container.BindPorts(ports, c.ID); err != nil {
+		log.WithError(err).WithField("containerid", c.ID).Error("Could not bind port to golem")
+		// The port would never be released with the golem
+		if err := client.RemoveContainer(docker.RemoveContainerOptions{ID: c.ID, Force: true, RemoveVolumes: true}); err != nil {
+			log.WithError(err).WithField("containerid", c.ID).Warn("Could not remove golem container")
+		}
+		container.ReleasePorts(ports)
+		return "", false, err
 	}
-	log.WithFields(log.Fields{"webstrateid": webstrateID, "containerid": container.ID}).Info("Created container, starting ...")
+	log.WithFields(log.Fields{"webstrateid": webstrateID, "containerid": c.ID}).Info("Created container, starting ...")
 
-	err = client.StartContainer(container.ID, nil)
+	err = client.StartContainer(c.ID, nil)
 
 	if err != nil {
 		log.WithError(err).Error("Error starting container")
-		return "", err
+		// Do not leave a dead golem holding the port behind
+		if err := client.RemoveContainer(docker.RemoveContainerOptions{ID: c.ID, Force: true, RemoveVolumes: true}); err != nil {
+			log.WithError(err).WithField("containerid", c.ID).Warn("Could not remove golem container which did not start")
+		}
+		container.ReleasePorts(ports)
+		return "", false, err
 	}
-	return container.ID, nil
+	Touch(webstrateID)
+	return c.ID, true, nil
 }
 
 // Kill will kill the container running the given golem
 func Kill(webstrateID string) error {
 
-	client, err := docker.NewClientFromEnv()
+	client, err := container.GetRuntime()
 	if err != nil {
-		log.WithError(err).Error("Error creating docker client")
 		return err
 	}
 
-	golems, err := container.List(client, func(c *docker.APIContainers) bool {
-		return strings.HasPrefix(c.Image, "webstrates/golem") && container.WithName(getName(webstrateID))(c)
-	}, false)
+	golems, err := container.List(client, container.And(container.WithLabel(LabelWebstrate, webstrateID), container.WithName(getName(webstrateID))), false)
+	if err != nil {
+		return err
+	}
 
 	if len(golems) != 1 {
 		return fmt.Errorf("Unexpected amount of golems - %d", len(golems))
 	}
+	markKilled(golems[0].ID)
 
 	err = client.KillContainer(docker.KillContainerOptions{
 		ID: golems[0].ID,
@@ -144,26 +255,47 @@ func Kill(webstrateID string) error {
 	return nil
 }
 
-// Restart will kill, recreate and start a given golem
+// Restart will kill, recreate and start a given golem - with the profile it was running with
 func Restart(webstrateID string) (string, error) {
+	profile := profileOf(webstrateID)
 	err := Kill(webstrateID)
 	if err != nil {
 		return "", err
 	}
-	return Spawn(webstrateID)
+	id, _, err := Ensure(webstrateID, profile)
+	return id, err
+}
+
+// profileOf returns the profile of the (running or dead) golem on the given webstrate - or "" if not known.
+func profileOf(webstrateID string) string {
+	client, err := container.GetRuntime()
+	if err != nil {
+		return ""
+	}
+	golems, err := container.List(client, container.WithLabel(LabelWebstrate, webstrateID), true)
+	if err != nil || len(golems) == 0 {
+		return ""
+	}
+	return golems[0].Labels[LabelProfile]
 }
 
 // PortOf returns the public port mapped to the given privatePort for
 // a golem on the given webstrate.
 func PortOf(webstrate string, privatePort int64) (int64, error) {
-	// Not very effective to do a list each time the port is needed
+	if e, ok := lookup(webstrate); ok {
+		if port, ok := e.Ports[privatePort]; ok {
+			return port, nil
+		}
+	}
+
+	// Not in registry (yet) - fall back to listing
 	golems, err := List()
 	if err != nil {
 		return -1, err
 	}
 
 	for _, golem := range golems {
-		if ws, ok := golem.Labels["webstrate"]; ok && webstrate == ws {
+		if ws, ok := golem.Labels[LabelWebstrate]; ok && webstrate == ws {
 			for _, port := range golem.Ports {
 				if port.PrivatePort == privatePort {
 					return port.PublicPort, nil
@@ -172,19 +304,16 @@ func PortOf(webstrate string, privatePort int64) (int64, error) {
 		}
 	}
 
-	return -1, fmt.Errorf("No container found for webstrate")
+	return -1, ErrNotFound
 }
 
-// List the running golems
+// List the running golems - that is the containers labelled with a webstrate by the herder
 func List() ([]docker.APIContainers, error) {
 
-	client, err := docker.NewClientFromEnv()
+	client, err := container.GetRuntime()
 	if err != nil {
-		log.WithError(err).Error("Error creating docker client")
 		return nil, err
 	}
 
-	return container.List(client, func(container *docker.APIContainers) bool {
-		return strings.HasPrefix(container.Image, "webstrates/golem")
-	}, false)
+	return container.List(client, container.HasLabel(LabelWebstrate), false)
 }
@@ -0,0 +1,109 @@
+package golem
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Webstrates/golem-herder/container"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/spf13/viper"
+)
+
+// failingStart is a runtime whose containers can not be started
+type failingStart struct {
+	*container.FakeRuntime
+}
+
+func (f failingStart) StartContainer(id string, hostConfig *docker.HostConfig) error {
+	return fmt.Errorf("Can not start %s", id)
+}
+
+// setup configures golems to be spawned with the given runtime and returns a func undoing it.
+func setup(t *testing.T, runtime container.Runtime) func() {
+	dir, err := ioutil.TempDir("", "golem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	container.SetRuntime(runtime)
+	viper.Set("ports-db", filepath.Join(dir, "ports.db"))
+	viper.Set("webstrates", "webstrates.example.com")
+	viper.Set("golem-profiles", map[string]interface{}{
+		DefaultProfile: map[string]interface{}{"seccomp": filepath.Join("..", "chrome.json"), "viewport": "800x600"},
+	})
+	return func() {
+		viper.Set("golem-profiles", nil)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSpawn(t *testing.T) {
+	defer setup(t, container.NewFakeRuntime())()
+
+	id, err := Spawn("ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Spawning is idempotent
+	if again, err := Spawn("ws"); err != nil || again != id {
+		t.Errorf("Spawning again gave %s (%v) - expected %s", again, err, id)
+	}
+
+	golems, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(golems) != 1 || golems[0].ID != id || golems[0].Labels[LabelWebstrate] != "ws" || golems[0].Labels[LabelProfile] != DefaultProfile {
+		t.Errorf("Unexpected golems: %+v", golems)
+	}
+
+	client, _ := container.GetRuntime()
+	c, err := client.InspectContainer(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := c.Config.Cmd
+	if len(args) == 0 || args[len(args)-1] != "http://webstrates.example.com/ws" || !contains(args, "--window-size=800,600") {
+		t.Errorf("Unexpected chrome args: %v", args)
+	}
+	if _, err := PortOf("ws", devtoolsPort); err != nil {
+		t.Errorf("No devtools port: %v", err)
+	}
+	for port, bindings := range c.HostConfig.PortBindings {
+		for _, binding := range bindings {
+			if binding.HostIP != "127.0.0.1" {
+				t.Errorf("Port %s of golem published on %q - expected only on the loopback interface", port, binding.HostIP)
+			}
+		}
+	}
+
+	if err := Kill("ws"); err != nil {
+		t.Fatal(err)
+	}
+	if golems, _ := List(); len(golems) != 0 {
+		t.Errorf("Golem still running after kill: %+v", golems)
+	}
+}
+
+func TestSpawnStartFailure(t *testing.T) {
+	runtime := failingStart{container.NewFakeRuntime()}
+	defer setup(t, runtime)()
+
+	if _, err := Spawn("broken"); err == nil {
+		t.Fatal("Golem spawned without starting")
+	}
+	if all, _ := container.List(runtime, container.HasLabel(LabelWebstrate), true); len(all) != 0 {
+		t.Errorf("Golem container left behind: %+v", all)
+	}
+	ports, err := container.Ports()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range ports.Reservations() {
+		if r.Owner == getName("broken") {
+			t.Errorf("Port %d still reserved", r.Port)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package golem
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// upgrader upgrades HTTP 1.1 connection to WebSocket
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(*http.Request) bool { return true }, // allow all origins
+}
+
+// LogEntry is a console message or an uncaught exception in the page of a golem.
+type LogEntry struct {
+	Time time.Time
+	// Level is the type of the console call (log, info, warning, error, debug, ...) or exception
+	Level  string
+	Text   string
+	URL    string   `json:",omitempty"`
+	Line   int      `json:",omitempty"`
+	Column int      `json:",omitempty"`
+	Stack  []string `json:",omitempty"`
+}
+
+// journal keeps the last log entries of the golem on a webstrate and passes new entries on to its followers.
+type journal struct {
+	mutex     sync.Mutex
+	entries   []LogEntry
+	followers map[chan LogEntry]bool
+}
+
+func (j *journal) add(entry LogEntry) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.entries = append(j.entries, entry)
+	if max := viper.GetInt("golem-log-lines"); max > 0 && len(j.entries) > max {
+		j.entries = j.entries[len(j.entries)-max:]
+	}
+	for follower := range j.followers {
+		select {
+		case follower <- entry:
+		default:
+			// the follower does not keep up
+		}
+	}
+}
+
+// get returns the last tail (all if 0) entries with the given level (any if empty).
+func (j *journal) get(tail int, level string) []LogEntry {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	entries := []LogEntry{}
+	for _, entry := range j.entries {
+		if level == "" || entry.Level == level {
+			entries = append(entries, entry)
+		}
+	}
+	if tail > 0 && len(entries) > tail {
+		entries = entries[len(entries)-tail:]
+	}
+	return entries
+}
+
+// follow returns a channel receiving new entries and a function to stop following.
+func (j *journal) follow() (<-chan LogEntry, func()) {
+	follower := make(chan LogEntry, 100)
+	j.mutex.Lock()
+	j.followers[follower] = true
+	j.mutex.Unlock()
+	return follower, func() {
+		j.mutex.Lock()
+		delete(j.followers, follower)
+		j.mutex.Unlock()
+	}
+}
+
+var (
+	// journals contains the log entries of golems by webstrate - they are kept after the golem is gone
+	journals      = map[string]*journal{}
+	journalsMutex = &sync.Mutex{}
+	captureOnce   sync.Once
+)
+
+// journalOf returns the journal of the golem on the given webstrate - it is created if create is set.
+func journalOf(webstrate string, create bool) (*journal, bool) {
+	journalsMutex.Lock()
+	defer journalsMutex.Unlock()
+	j, ok := journals[webstrate]
+	if !ok && create {
+		j = &journal{followers: map[chan LogEntry]bool{}}
+		journals[webstrate] = j
+	}
+	return j, j != nil
+}
+
+// remoteValue is a value in the page as given in console calls.
+type remoteValue struct {
+	Type                string          `json:"type"`
+	Value               json.RawMessage `json:"value"`
+	UnserializableValue string          `json:"unserializableValue"`
+	Description         string          `json:"description"`
+}
+
+func (v remoteValue) String() string {
+	switch {
+	case len(v.Value) > 0:
+		var s string
+		if err := json.Unmarshal(v.Value, &s); err == nil {
+			return s
+		}
+		return string(v.Value)
+	case v.UnserializableValue != "":
+		return v.UnserializableValue
+	case v.Description != "":
+		return v.Description
+	}
+	return v.Type
+}
+
+// stackTrace is the javascript stack of a console call or an exception.
+type stackTrace struct {
+	CallFrames []struct {
+		FunctionName string `json:"functionName"`
+		URL          string `json:"url"`
+		LineNumber   int    `json:"lineNumber"`
+		ColumnNumber int    `json:"columnNumber"`
+	} `json:"callFrames"`
+}
+
+func (s *stackTrace) lines() []string {
+	if s == nil {
+		return nil
+	}
+	lines := []string{}
+	for _, frame := range s.CallFrames {
+		name := frame.FunctionName
+		if name == "" {
+			name = "<anonymous>"
+		}
+		lines = append(lines, fmt.Sprintf("%s (%s:%d:%d)", name, frame.URL, frame.LineNumber+1, frame.ColumnNumber+1))
+	}
+	return lines
+}
+
+// toLogEntry converts a Runtime.consoleAPICalled or Runtime.exceptionThrown event to a log entry.
+func toLogEntry(event Event) (LogEntry, error) {
+	switch event.Method {
+	case "Runtime.consoleAPICalled":
+		call := struct {
+			Type       string        `json:"type"`
+			Args       []remoteValue `json:"args"`
+			Timestamp  float64       `json:"timestamp"`
+			StackTrace *stackTrace   `json:"stackTrace"`
+		}{}
+		if err := json.Unmarshal(event.Params, &call); err != nil {
+			return LogEntry{}, err
+		}
+		args := []string{}
+		for _, arg := range call.Args {
+			args = append(args, arg.String())
+		}
+		entry := LogEntry{
+			Time:  time.Unix(0, int64(call.Timestamp*float64(time.Millisecond))),
+			Level: call.Type,
+			Text:  strings.Join(args, " "),
+			Stack: call.StackTrace.lines(),
+		}
+		if call.StackTrace != nil && len(call.StackTrace.CallFrames) > 0 {
+			frame := call.StackTrace.CallFrames[0]
+			entry.URL, entry.Line, entry.Column = frame.URL, frame.LineNumber+1, frame.ColumnNumber+1
+		}
+		return entry, nil
+	case "Runtime.exceptionThrown":
+		thrown := struct {
+			Timestamp        float64 `json:"timestamp"`
+			ExceptionDetails struct {
+				Text         string       `json:"text"`
+				URL          string       `json:"url"`
+				LineNumber   int          `json:"lineNumber"`
+				ColumnNumber int          `json:"columnNumber"`
+				Exception    *remoteValue `json:"exception"`
+				StackTrace   *stackTrace  `json:"stackTrace"`
+			} `json:"exceptionDetails"`
+		}{}
+		if err := json.Unmarshal(event.Params, &thrown); err != nil {
+			return LogEntry{}, err
+		}
+		details := thrown.ExceptionDetails
+		text := details.Text
+		if details.Exception != nil {
+			text = details.Exception.String()
+		}
+		return LogEntry{
+			Time:   time.Unix(0, int64(thrown.Timestamp*float64(time.Millisecond))),
+			Level:  "exception",
+			Text:   text,
+			URL:    details.URL,
+			Line:   details.LineNumber + 1,
+			Column: details.ColumnNumber + 1,
+			Stack:  details.StackTrace.lines(),
+		}, nil
+	}
+	return LogEntry{}, fmt.Errorf("Unexpected event: %s", event.Method)
+}
+
+// capture keeps the console messages and exceptions of the golem on the given webstrate until stop is closed.
+func capture(webstrate string, stop <-chan bool) {
+	j, _ := journalOf(webstrate, true)
+	logger := log.WithField("webstrate", webstrate)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	for {
+		c, err := Connect(ctx, webstrate)
+		if err != nil {
+			// The golem may still be starting
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+				continue
+			}
+		}
+
+		events := c.Listen("Runtime.consoleAPICalled", "Runtime.exceptionThrown")
+		if err := c.Call(ctx, "Runtime.enable", nil, nil); err != nil {
+			logger.WithError(err).Warn("Could not enable console capture of golem")
+		}
+		logger.Info("Capturing golem console")
+		for event := range events {
+			entry, err := toLogEntry(event)
+			if err != nil {
+				logger.WithError(err).Warn("Could not read golem console event")
+				continue
+			}
+			j.add(entry)
+		}
+		c.Close()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// CaptureLogs starts capturing the console messages and uncaught exceptions of all golems.
+func CaptureLogs() {
+	captureOnce.Do(func() {
+		forEachGolem(capture)
+	})
+}
+
+// LogsHandler responds with the console messages and uncaught exceptions of the golem on a webstrate as a json list.
+// The entries can be limited with the query params tail and level. Websocket connections get the entries as json
+// messages followed by new entries as they occur.
+func LogsHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
+	webstrate := mux.Vars(r)["webstrate"]
+	if !authorized(w, webstrate, token) {
+		return
+	}
+	query := r.URL.Query()
+
+	tail := 0
+	if s := query.Get("tail"); s != "" && s != "all" {
+		var err error
+		if tail, err = strconv.Atoi(s); err != nil || tail < 0 {
+			http.Error(w, fmt.Sprintf("Invalid tail: %s", s), 400 /* Bad request */)
+			return
+		}
+	}
+	level := query.Get("level")
+
+	j, ok := journalOf(webstrate, false)
+	if !ok {
+		http.Error(w, "No logs found for webstrate", 404)
+		return
+	}
+
+	if !websocket.IsWebSocketUpgrade(r) {
+		writeJSON(w, j.get(tail, level))
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.WithError(err).Warn("Error upgrading connection")
+		return
+	}
+	defer conn.Close()
+
+	// Follow before sending the backlog so nothing is missed
+	entries, unfollow := j.follow()
+	defer unfollow()
+	closed := make(chan bool)
+	go func() {
+		// Reading detects when the client goes away
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				close(closed)
+				return
+			}
+		}
+	}()
+
+	var last time.Time
+	for _, entry := range j.get(tail, level) {
+		if err := conn.WriteJSON(entry); err != nil {
+			return
+		}
+		last = entry.Time
+	}
+	for {
+		select {
+		case entry := <-entries:
+			if (level != "" && entry.Level != level) || entry.Time.Before(last) {
+				continue
+			}
+			if err := conn.WriteJSON(entry); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
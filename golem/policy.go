@@ -0,0 +1,285 @@
+package golem
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// ErrCapacity is returned when a golem can not be spawned as the max number of golems are running and none
+// can be evicted (they are pinned or have minions connected).
+var ErrCapacity = fmt.Errorf("Golem capacity reached and no golem can be evicted")
+
+// maxReaped is how many reaped golems are kept for the policy state
+const maxReaped = 50
+
+// Reaped is a golem killed by the herder to save resources.
+type Reaped struct {
+	Webstrate string
+	Time      time.Time
+	// Reason is idle or evicted
+	Reason string
+}
+
+// Activity is the use of a running golem as seen by the golem policies.
+type Activity struct {
+	Webstrate    string
+	LastActivity time.Time
+	// Idle is the number of seconds since the last activity - 0 while minions are connected
+	Idle    int64
+	Minions int `json:",omitempty"`
+	Pinned  bool
+}
+
+// PolicyState is the state of the policies limiting golems.
+type PolicyState struct {
+	// IdleTimeout is the number of seconds a golem may be idle before it is killed - 0 if golems are never reaped
+	IdleTimeout int64
+	// MaxGolems is the max number of concurrent golems - 0 if unlimited
+	MaxGolems int
+	Golems    []Activity
+	// Pinned are the webstrates whose golems are never reaped
+	Pinned []string
+	Reaped []Reaped
+}
+
+var (
+	activity    = map[string]time.Time{}
+	minionCount = map[string]int{}
+	pins        = map[string]bool{}
+	reaped      = []Reaped{}
+	policyMutex = &sync.Mutex{}
+	reaperOnce  sync.Once
+	// spawning are the webstrates whose golems have been given room (see makeRoom) but may not be running yet
+	spawning  = map[string]bool{}
+	roomMutex = &sync.Mutex{}
+)
+
+// Touch records activity (e.g. a message from a minion or the golem) on the golem on the given webstrate.
+func Touch(webstrate string) {
+	policyMutex.Lock()
+	defer policyMutex.Unlock()
+	activity[webstrate] = time.Now()
+}
+
+// MinionConnected records that a minion connected to (or disconnected from) the golem on the given webstrate.
+// Golems are not idle while minions are connected.
+func MinionConnected(webstrate string, connected bool) {
+	policyMutex.Lock()
+	defer policyMutex.Unlock()
+	activity[webstrate] = time.Now()
+	if connected {
+		minionCount[webstrate]++
+		return
+	}
+	if minionCount[webstrate]--; minionCount[webstrate] <= 0 {
+		delete(minionCount, webstrate)
+	}
+}
+
+// minionsOf returns the number of minions connected to the golem on the given webstrate.
+func minionsOf(webstrate string) int {
+	policyMutex.Lock()
+	defer policyMutex.Unlock()
+	return minionCount[webstrate]
+}
+
+// Pin pins (or unpins) the golem on the given webstrate so it is never reaped. Webstrates pinned by
+// "golem-pinned" in the config can not be unpinned.
+func Pin(webstrate string, pin bool) {
+	policyMutex.Lock()
+	defer policyMutex.Unlock()
+	if pin {
+		pins[webstrate] = true
+	} else {
+		delete(pins, webstrate)
+	}
+}
+
+// pinned tells whether the golem on the given webstrate is pinned. Remember to lock policyMutex.
+func pinned(webstrate string) bool {
+	if pins[webstrate] {
+		return true
+	}
+	for _, ws := range viper.GetStringSlice("golem-pinned") {
+		if ws == webstrate {
+			return true
+		}
+	}
+	return false
+}
+
+// activities returns the activity of the running golems - least recently used first.
+func activities() ([]Activity, error) {
+	golems, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	policyMutex.Lock()
+	defer policyMutex.Unlock()
+	result := []Activity{}
+	for _, g := range golems {
+		webstrate, ok := g.Labels[LabelWebstrate]
+		if !ok {
+			continue
+		}
+		last, ok := activity[webstrate]
+		if !ok {
+			// Golems left by a previous herder (or spawned before the first activity) are idle from now
+			last = time.Now()
+			activity[webstrate] = last
+		}
+		a := Activity{Webstrate: webstrate, LastActivity: last, Minions: minionCount[webstrate], Pinned: pinned(webstrate)}
+		if a.Minions == 0 {
+			a.Idle = int64(time.Since(last).Seconds())
+		}
+		result = append(result, a)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].LastActivity.Before(result[j].LastActivity) })
+	return result, nil
+}
+
+// reap kills the golem on the given webstrate and records why.
+func reap(webstrate, reason string) error {
+	log.WithField("webstrate", webstrate).WithField("reason", reason).Info("Reaping golem")
+	if err := Kill(webstrate); err != nil {
+		return err
+	}
+	policyMutex.Lock()
+	defer policyMutex.Unlock()
+	delete(activity, webstrate)
+	reaped = append(reaped, Reaped{Webstrate: webstrate, Time: time.Now(), Reason: reason})
+	if len(reaped) > maxReaped {
+		reaped = reaped[len(reaped)-maxReaped:]
+	}
+	return nil
+}
+
+// makeRoom evicts the least recently used golems (which are not pinned and have no minions connected) until
+// the golem on the given webstrate can be spawned. The room is kept for the golem until the returned func is
+// called (once the golem is running or could not be spawned) so concurrent spawns do not exceed "golem-max".
+func makeRoom(webstrate string) (func(), error) {
+	max := viper.GetInt("golem-max")
+	if max <= 0 {
+		return func() {}, nil
+	}
+	roomMutex.Lock()
+	defer roomMutex.Unlock()
+	golems, err := activities()
+	if err != nil {
+		return nil, err
+	}
+	if runningOn(golems, webstrate) {
+		// Already running - no room needed
+		return func() {}, nil
+	}
+	running := len(golems)
+	// Golems given room which are not running yet take up room as well
+	for ws := range spawning {
+		if ws != webstrate && !runningOn(golems, ws) {
+			running++
+		}
+	}
+	for _, a := range golems {
+		if running < max {
+			break
+		}
+		if a.Pinned || a.Minions > 0 {
+			continue
+		}
+		if err := reap(a.Webstrate, "evicted"); err != nil {
+			log.WithError(err).WithField("webstrate", a.Webstrate).Warn("Could not evict golem")
+			continue
+		}
+		running--
+	}
+	if running >= max {
+		return nil, ErrCapacity
+	}
+	spawning[webstrate] = true
+	return func() {
+		roomMutex.Lock()
+		defer roomMutex.Unlock()
+		delete(spawning, webstrate)
+	}, nil
+}
+
+// runningOn tells whether one of the given golems is on the given webstrate.
+func runningOn(golems []Activity, webstrate string) bool {
+	for _, a := range golems {
+		if a.Webstrate == webstrate {
+			return true
+		}
+	}
+	return false
+}
+
+// reapIdle kills the golems which have been idle for longer than "golem-idle-timeout".
+func reapIdle() {
+	timeout := viper.GetDuration("golem-idle-timeout")
+	if timeout <= 0 {
+		return
+	}
+	golems, err := activities()
+	if err != nil {
+		log.WithError(err).Warn("Could not look for idle golems")
+		return
+	}
+	for _, a := range golems {
+		if a.Pinned || a.Minions > 0 || time.Duration(a.Idle)*time.Second < timeout {
+			continue
+		}
+		if err := reap(a.Webstrate, "idle"); err != nil {
+			log.WithError(err).WithField("webstrate", a.Webstrate).Warn("Could not reap idle golem")
+		}
+	}
+}
+
+// Reap starts reaping idle golems every interval.
+func Reap(interval time.Duration) {
+	reaperOnce.Do(func() {
+		go func() {
+			for range time.Tick(interval) {
+				reapIdle()
+			}
+		}()
+	})
+}
+
+// Policy returns the state of the policies limiting golems.
+func Policy() (*PolicyState, error) {
+	golems, err := activities()
+	if err != nil {
+		return nil, err
+	}
+	policyMutex.Lock()
+	defer policyMutex.Unlock()
+	state := &PolicyState{
+		IdleTimeout: int64(viper.GetDuration("golem-idle-timeout").Seconds()),
+		MaxGolems:   viper.GetInt("golem-max"),
+		Golems:      golems,
+		Pinned:      append([]string{}, viper.GetStringSlice("golem-pinned")...),
+		Reaped:      append([]Reaped{}, reaped...),
+	}
+	for webstrate := range pins {
+		if !contains(state.Pinned, webstrate) {
+			state.Pinned = append(state.Pinned, webstrate)
+		}
+	}
+	sort.Strings(state.Pinned)
+	return state, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
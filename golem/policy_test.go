@@ -0,0 +1,54 @@
+package golem
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/Webstrates/golem-herder/container"
+	"github.com/spf13/viper"
+)
+
+func TestMaxGolemsConcurrentSpawns(t *testing.T) {
+	defer setup(t, container.NewFakeRuntime())()
+	webstrates := []string{}
+	for i := 0; i < 10; i++ {
+		webstrates = append(webstrates, fmt.Sprintf("max-%d", i))
+	}
+	// Pinned golems can not be evicted so only golem-max can be spawned
+	viper.Set("golem-max", 3)
+	viper.Set("golem-pinned", webstrates)
+	defer viper.Set("golem-max", 0)
+	defer viper.Set("golem-pinned", nil)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(webstrates))
+	for _, ws := range webstrates {
+		wg.Add(1)
+		go func(ws string) {
+			defer wg.Done()
+			_, err := Spawn(ws)
+			errs <- err
+		}(ws)
+	}
+	wg.Wait()
+	close(errs)
+
+	spawned := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			spawned++
+		case ErrCapacity:
+		default:
+			t.Error(err)
+		}
+	}
+	golems, _ := List()
+	if spawned != 3 || len(golems) != 3 {
+		t.Errorf("%d golems spawned and %d running - expected 3", spawned, len(golems))
+	}
+	for _, g := range golems {
+		Kill(g.Labels[LabelWebstrate])
+	}
+}
@@ -0,0 +1,206 @@
+package golem
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/Webstrates/golem-herder/container"
+	"github.com/spf13/viper"
+)
+
+// Labels put on golem containers by the herder. Golems are identified by the webstrate label.
+const (
+	// LabelWebstrate is the webstrate shown by the golem
+	LabelWebstrate = "webstrate"
+	// LabelProfile is the profile the golem was spawned with
+	LabelProfile = "golem-profile"
+)
+
+// DefaultProfile is the name of the profile used when none is given and no rule matches
+const DefaultProfile = "default"
+
+// viewportPattern matches viewports given as <width>x<height>
+var viewportPattern = regexp.MustCompile(`^(\d+)x(\d+)$`)
+
+// Profile describes how golems are run. Profiles are defined in the config under "golem-profiles", e.g.
+//
+//	golem-profiles:
+//	  print:
+//	    tag: 1.2
+//	    viewport: 1920x1080
+//	    chrome-flags: ["--font-render-hinting=none"]
+//	    resources: large
+//
+// Settings not given by a profile are taken from the default profile (which may be defined as "default") and
+// otherwise from the golem, golem-resources flags etc.
+type Profile struct {
+	// Image and Tag of the golem image
+	Image string `json:"image,omitempty" mapstructure:"image"`
+	Tag   string `json:"tag,omitempty" mapstructure:"tag"`
+	// ChromeFlags are given to chrome in addition to the flags the herder needs
+	ChromeFlags []string `json:"chrome-flags,omitempty" mapstructure:"chrome-flags"`
+	// Viewport is the size of the window of chrome given as <width>x<height>, e.g. 1280x800
+	Viewport  string `json:"viewport,omitempty" mapstructure:"viewport"`
+	UserAgent string `json:"user-agent,omitempty" mapstructure:"user-agent"`
+	// Env are environment variables in the format NAME=value
+	Env []string `json:"env,omitempty" mapstructure:"env"`
+	// Seccomp is the path of the seccomp profile of chrome
+	Seccomp string `json:"seccomp,omitempty" mapstructure:"seccomp"`
+	// Resources is the name of the resource class of the golem (see container.ResourceClass)
+	Resources string `json:"resources,omitempty" mapstructure:"resources"`
+}
+
+// ProfileRule selects the profile of golems on the webstrates matching the pattern (see path.Match).
+// Rules are defined in the config under "golem-profile-rules" and the first matching rule is used.
+type ProfileRule struct {
+	Pattern string `mapstructure:"pattern"`
+	Profile string `mapstructure:"profile"`
+}
+
+// merge returns the profile with the settings it does not give taken from the given defaults.
+func (p Profile) merge(defaults Profile) Profile {
+	if p.Image == "" {
+		p.Image = defaults.Image
+	}
+	if p.Tag == "" {
+		p.Tag = defaults.Tag
+	}
+	if p.ChromeFlags == nil {
+		p.ChromeFlags = defaults.ChromeFlags
+	}
+	if p.Viewport == "" {
+		p.Viewport = defaults.Viewport
+	}
+	if p.UserAgent == "" {
+		p.UserAgent = defaults.UserAgent
+	}
+	if p.Env == nil {
+		p.Env = defaults.Env
+	}
+	if p.Seccomp == "" {
+		p.Seccomp = defaults.Seccomp
+	}
+	if p.Resources == "" {
+		p.Resources = defaults.Resources
+	}
+	return p
+}
+
+// GetProfile returns the profile with the given name.
+func GetProfile(name string) (*Profile, error) {
+	defaults := Profile{
+		Image:     "webstrates/golem",
+		Tag:       viper.GetString("golem"),
+		Seccomp:   "chrome.json",
+		Resources: viper.GetString("golem-resources"),
+	}
+	if viper.IsSet("golem-profiles." + DefaultProfile) {
+		p := Profile{}
+		if err := viper.UnmarshalKey("golem-profiles."+DefaultProfile, &p); err != nil {
+			return nil, err
+		}
+		defaults = p.merge(defaults)
+	}
+	if name == DefaultProfile {
+		return &defaults, defaults.validate()
+	}
+
+	key := fmt.Sprintf("golem-profiles.%s", name)
+	if !viper.IsSet(key) {
+		return nil, fmt.Errorf("Unknown golem profile: %s", name)
+	}
+	p := Profile{}
+	if err := viper.UnmarshalKey(key, &p); err != nil {
+		return nil, err
+	}
+	p = p.merge(defaults)
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("Invalid golem profile %s: %v", name, err)
+	}
+	return &p, nil
+}
+
+// validate checks the settings of the profile.
+func (p *Profile) validate() error {
+	if p.Viewport != "" && !viewportPattern.MatchString(p.Viewport) {
+		return fmt.Errorf("Invalid viewport: %s", p.Viewport)
+	}
+	if _, err := container.ResourceClass(p.Resources); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ProfileFor returns the name of the profile of golems on the given webstrate as given by "golem-profile-rules".
+func ProfileFor(webstrate string) string {
+	rules := []ProfileRule{}
+	if err := viper.UnmarshalKey("golem-profile-rules", &rules); err != nil {
+		return DefaultProfile
+	}
+	for _, rule := range rules {
+		if matchesAny([]string{rule.Pattern}, webstrate) {
+			return rule.Profile
+		}
+	}
+	return DefaultProfile
+}
+
+// Profiles returns the names of the configured profiles - including the default profile.
+func Profiles() []string {
+	names := []string{DefaultProfile}
+	for name := range viper.GetStringMap("golem-profiles") {
+		if name != DefaultProfile {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// Images returns the images used by the golem profiles.
+func Images() []string {
+	images := []string{}
+	for _, name := range Profiles() {
+		p, err := GetProfile(name)
+		if err != nil {
+			continue
+		}
+		image := container.ImageName(p.Image, p.Tag)
+		if !contains(images, image) {
+			images = append(images, image)
+		}
+	}
+	return images
+}
+
+// seccomp returns the seccomp profile of chrome given by the profile.
+func (p *Profile) seccomp() ([]byte, error) {
+	file := p.Seccomp
+	if !filepath.IsAbs(file) {
+		dir, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		file = filepath.Join(dir, file)
+	}
+	return ioutil.ReadFile(file)
+}
+
+// chromeFlags returns the flags given to chrome by the profile.
+func (p *Profile) chromeFlags() []string {
+	flags := []string{}
+	if matches := viewportPattern.FindStringSubmatch(p.Viewport); matches != nil {
+		width, _ := strconv.Atoi(matches[1])
+		height, _ := strconv.Atoi(matches[2])
+		flags = append(flags, fmt.Sprintf("--window-size=%d,%d", width, height))
+	}
+	if p.UserAgent != "" {
+		flags = append(flags, fmt.Sprintf("--user-agent=%s", p.UserAgent))
+	}
+	return append(flags, p.ChromeFlags...)
+}
@@ -32,7 +32,10 @@ func NewGolemReverseProxy(prefix string, portLookup func(webstrate string, priva
 		}
 		req.URL.Scheme = "http"
 		req.URL.Host = fmt.Sprintf("localhost:%v", port)
-		req.URL.Path = matches[2]
+		req.URL.Path = "/" + matches[2]
+		req.URL.RawPath = ""
+		// The developer tools only accept requests for localhost
+		req.Host = req.URL.Host
 		log.WithField("target-url", req.URL.String()).Info("Proxying")
 	}
 	return &httputil.ReverseProxy{Director: director}
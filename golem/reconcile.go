@@ -0,0 +1,75 @@
+package golem
+
+import (
+	"fmt"
+
+	"github.com/Webstrates/golem-herder/container"
+	docker "github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReconcileReport describes what was done to the golems found when reconciling.
+type ReconcileReport struct {
+	// Kept are running golems which are left running
+	Kept []string
+	// Restarted are running golems which were restarted (to reconnect to the herder)
+	Restarted []string
+	// Killed are running golems which were killed
+	Killed []string
+	// Removed are golems which exited while the herder was not running
+	Removed []string
+}
+
+// Reconcile finds the golems left behind by a previous herder (by their webstrate label).
+// Exited golems are removed and running golems are kept, restarted or killed given by action.
+func Reconcile(action string) (*ReconcileReport, error) {
+	if action != "keep" && action != "restart" && action != "kill" {
+		return nil, fmt.Errorf("Unknown golem reconcile action: %s", action)
+	}
+
+	client, err := container.GetRuntime()
+	if err != nil {
+		return nil, err
+	}
+
+	golems, err := container.List(client, container.HasLabel(LabelWebstrate), true)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReconcileReport{Kept: []string{}, Restarted: []string{}, Killed: []string{}, Removed: []string{}}
+	for _, g := range golems {
+		webstrate := g.Labels[LabelWebstrate]
+		logger := log.WithField("webstrate", webstrate).WithField("container", g.ID)
+
+		if g.State != "running" {
+			logger.Info("Removing golem which exited while herder was down")
+			if err := client.RemoveContainer(docker.RemoveContainerOptions{ID: g.ID, Force: true, RemoveVolumes: true}); err != nil {
+				logger.WithError(err).Warn("Could not remove golem")
+				continue
+			}
+			report.Removed = append(report.Removed, webstrate)
+			continue
+		}
+
+		switch action {
+		case "restart":
+			logger.Info("Restarting golem")
+			if _, err := Restart(webstrate); err != nil {
+				logger.WithError(err).Warn("Could not restart golem")
+				continue
+			}
+			report.Restarted = append(report.Restarted, webstrate)
+		case "kill":
+			logger.Info("Killing golem")
+			if err := Kill(webstrate); err != nil {
+				logger.WithError(err).Warn("Could not kill golem")
+				continue
+			}
+			report.Killed = append(report.Killed, webstrate)
+		default:
+			report.Kept = append(report.Kept, webstrate)
+		}
+	}
+	return report, nil
+}
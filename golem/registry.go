@@ -0,0 +1,100 @@
+package golem
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/Webstrates/golem-herder/container"
+	log "github.com/sirupsen/logrus"
+)
+
+// entry is a running golem as seen by the container watcher.
+type entry struct {
+	ID string
+	// Ports maps private to public ports
+	Ports map[int64]int64
+}
+
+var (
+	// registry contains the running golems by webstrate
+	registry      = map[string]*entry{}
+	registryMutex = &sync.Mutex{}
+	registryOnce  sync.Once
+)
+
+// track keeps the registry up to date with golems starting and dying.
+func track() {
+	events := container.SubscribeRunning(container.All(
+		container.OfLabel(LabelWebstrate),
+		container.OfType(container.EventStart, container.EventDie)))
+
+	go func() {
+		for event := range events.C {
+			webstrate := event.Labels[LabelWebstrate]
+			if event.Type == container.EventDie {
+				registryMutex.Lock()
+				if e, ok := registry[webstrate]; ok && e.ID == event.ID {
+					delete(registry, webstrate)
+				}
+				registryMutex.Unlock()
+				continue
+			}
+
+			client, err := container.GetRuntime()
+			if err != nil {
+				continue
+			}
+			c, err := client.InspectContainer(event.ID)
+			if err != nil {
+				log.WithError(err).WithField("webstrate", webstrate).Warn("Could not inspect started golem")
+				continue
+			}
+			e := &entry{ID: c.ID, Ports: map[int64]int64{}}
+			if c.NetworkSettings != nil {
+				for port, bindings := range c.NetworkSettings.Ports {
+					private, _ := strconv.ParseInt(port.Port(), 10, 64)
+					for _, binding := range bindings {
+						if public, err := strconv.ParseInt(binding.HostPort, 10, 64); err == nil {
+							e.Ports[private] = public
+						}
+					}
+				}
+			}
+			registryMutex.Lock()
+			registry[webstrate] = e
+			registryMutex.Unlock()
+		}
+	}()
+}
+
+// lookup returns the registry entry of the golem on the given webstrate.
+func lookup(webstrate string) (*entry, bool) {
+	registryOnce.Do(track)
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	e, ok := registry[webstrate]
+	return e, ok
+}
+
+// forEachGolem runs f for each golem starting - and for golems already running. f is given the webstrate of the
+// golem and a chan which is closed when the golem dies.
+func forEachGolem(f func(webstrate string, stop <-chan bool)) {
+	events := container.SubscribeRunning(container.All(
+		container.OfLabel(LabelWebstrate),
+		container.OfType(container.EventStart, container.EventDie)))
+
+	running := map[string]chan bool{}
+	go func() {
+		for event := range events.C {
+			if stop, ok := running[event.ID]; ok {
+				close(stop)
+				delete(running, event.ID)
+			}
+			if event.Type == container.EventStart {
+				stop := make(chan bool)
+				running[event.ID] = stop
+				go f(event.Labels[LabelWebstrate], stop)
+			}
+		}
+	}()
+}
@@ -0,0 +1,344 @@
+package golem
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// renderTimeout is how long rendering may take - including spawning a golem
+const renderTimeout = 60 * time.Second
+
+// papers are the paper sizes (width and height in inches) pdfs can be printed on
+var papers = map[string][2]float64{
+	"a3":     {11.69, 16.54},
+	"a4":     {8.27, 11.69},
+	"a5":     {5.83, 8.27},
+	"letter": {8.5, 11},
+	"legal":  {8.5, 14},
+}
+
+// RenderOptions describes how to render the page of a golem.
+type RenderOptions struct {
+	// Width and Height of the viewport in css pixels - the viewport of the golem is used if 0
+	Width  int
+	Height int
+	// Scale is the device scale factor used with Width and Height - 1 if 0
+	Scale float64
+	// Selector clips the screenshot to the first element matching it
+	Selector string
+	// FullPage captures the whole page instead of the viewport
+	FullPage bool
+	// Format of screenshots (png, jpeg or webp) and Quality (0-100) of jpeg and webp screenshots
+	Format  string
+	Quality int
+	// Paper (a3, a4, a5, letter or legal), Landscape and Background (print background graphics) of pdfs
+	Paper      string
+	Landscape  bool
+	Background bool
+}
+
+// clip is an area of the page in css pixels.
+type clip struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+	Scale  float64 `json:"scale"`
+}
+
+// connectOrSpawn connects to the golem on the given webstrate - a golem is spawned if none is running
+// (unless golems are not allowed on the webstrate). The connection is returned once the page of the golem is loaded.
+func connectOrSpawn(ctx context.Context, webstrate string) (*CDP, error) {
+	c, err := Connect(ctx, webstrate)
+	if err == ErrNotFound {
+		if !Allowed(webstrate) {
+			return nil, ErrNotAllowed
+		}
+		log.WithField("webstrate", webstrate).Info("Spawning golem to render webstrate")
+		if _, err := Spawn(webstrate); err != nil {
+			return nil, err
+		}
+	}
+	for err != nil {
+		// The golem may be starting
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("Golem did not start in time: %v", err)
+		case <-time.After(500 * time.Millisecond):
+		}
+		c, err = Connect(ctx, webstrate)
+	}
+
+	for {
+		state, err := evaluate(ctx, c, "document.readyState")
+		if err == nil && string(state) == `"complete"` {
+			return c, nil
+		}
+		select {
+		case <-ctx.Done():
+			c.Close()
+			return nil, fmt.Errorf("Webstrate did not load in time")
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+// withViewport runs f with the viewport of the page set as given by the options.
+func withViewport(ctx context.Context, c *CDP, options RenderOptions, f func() error) error {
+	if options.Width <= 0 || options.Height <= 0 {
+		return f()
+	}
+	scale := options.Scale
+	if scale <= 0 {
+		scale = 1
+	}
+	params := map[string]interface{}{
+		"width":             options.Width,
+		"height":            options.Height,
+		"deviceScaleFactor": scale,
+		"mobile":            false,
+	}
+	if err := c.Call(ctx, "Emulation.setDeviceMetricsOverride", params, nil); err != nil {
+		return err
+	}
+	defer func() {
+		if err := c.Call(ctx, "Emulation.clearDeviceMetricsOverride", nil, nil); err != nil {
+			log.WithError(err).Warn("Could not reset viewport of golem")
+		}
+	}()
+	return f()
+}
+
+// elementClip returns the area of the first element matching the selector.
+func elementClip(ctx context.Context, c *CDP, selector string) (*clip, error) {
+	s, err := json.Marshal(selector)
+	if err != nil {
+		return nil, err
+	}
+	value, err := evaluate(ctx, c, fmt.Sprintf(`(() => {
+		const e = document.querySelector(%s);
+		if (!e) return null;
+		const r = e.getBoundingClientRect();
+		return {x: r.left + window.scrollX, y: r.top + window.scrollY, width: r.width, height: r.height, scale: 1};
+	})()`, s))
+	if err != nil {
+		return nil, err
+	}
+	area := &clip{}
+	if string(value) == "null" {
+		return nil, &EvalError{Message: fmt.Sprintf("No element matches %s", selector)}
+	}
+	if err := json.Unmarshal(value, area); err != nil {
+		return nil, err
+	}
+	return area, nil
+}
+
+// pageClip returns the area of the whole page.
+func pageClip(ctx context.Context, c *CDP) (*clip, error) {
+	size := struct {
+		Width  float64 `json:"width"`
+		Height float64 `json:"height"`
+	}{}
+	metrics := struct {
+		ContentSize    *json.RawMessage `json:"contentSize"`
+		CSSContentSize *json.RawMessage `json:"cssContentSize"`
+	}{}
+	if err := c.Call(ctx, "Page.getLayoutMetrics", nil, &metrics); err != nil {
+		return nil, err
+	}
+	content := metrics.CSSContentSize
+	if content == nil {
+		content = metrics.ContentSize
+	}
+	if content == nil {
+		return nil, fmt.Errorf("Could not get the size of the page")
+	}
+	if err := json.Unmarshal(*content, &size); err != nil {
+		return nil, err
+	}
+	return &clip{Width: size.Width, Height: size.Height, Scale: 1}, nil
+}
+
+// Screenshot captures the page of the golem on the given webstrate - spawning a golem if needed.
+func Screenshot(ctx context.Context, webstrate string, options RenderOptions) ([]byte, error) {
+	format := options.Format
+	if format == "" {
+		format = "png"
+	}
+	if format != "png" && format != "jpeg" && format != "webp" {
+		return nil, fmt.Errorf("Unknown screenshot format: %s", format)
+	}
+
+	c, err := connectOrSpawn(ctx, webstrate)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	var data []byte
+	err = withViewport(ctx, c, options, func() error {
+		params := map[string]interface{}{"format": format}
+		if format != "png" && options.Quality > 0 {
+			params["quality"] = options.Quality
+		}
+
+		var area *clip
+		var err error
+		switch {
+		case options.Selector != "":
+			area, err = elementClip(ctx, c, options.Selector)
+		case options.FullPage:
+			area, err = pageClip(ctx, c)
+		}
+		if err != nil {
+			return err
+		}
+		if area != nil {
+			params["clip"] = area
+			params["captureBeyondViewport"] = true
+		}
+
+		result := struct {
+			Data string `json:"data"`
+		}{}
+		if err := c.Call(ctx, "Page.captureScreenshot", params, &result); err != nil {
+			return err
+		}
+		data, err = base64.StdEncoding.DecodeString(result.Data)
+		return err
+	})
+	return data, err
+}
+
+// PDF prints the page of the golem on the given webstrate - spawning a golem if needed.
+func PDF(ctx context.Context, webstrate string, options RenderOptions) ([]byte, error) {
+	paper := options.Paper
+	if paper == "" {
+		paper = "a4"
+	}
+	size, ok := papers[strings.ToLower(paper)]
+	if !ok {
+		return nil, fmt.Errorf("Unknown paper: %s", paper)
+	}
+
+	c, err := connectOrSpawn(ctx, webstrate)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	var data []byte
+	err = withViewport(ctx, c, options, func() error {
+		params := map[string]interface{}{
+			"paperWidth":      size[0],
+			"paperHeight":     size[1],
+			"landscape":       options.Landscape,
+			"printBackground": options.Background,
+		}
+		result := struct {
+			Data string `json:"data"`
+		}{}
+		if err := c.Call(ctx, "Page.printToPDF", params, &result); err != nil {
+			return err
+		}
+		var err error
+		data, err = base64.StdEncoding.DecodeString(result.Data)
+		return err
+	})
+	return data, err
+}
+
+// renderOptions reads the render options from the query params width, height, scale, selector, fullPage,
+// format, quality, paper, landscape and background.
+func renderOptions(r *http.Request) (RenderOptions, error) {
+	query := r.URL.Query()
+	options := RenderOptions{
+		Selector:   query.Get("selector"),
+		FullPage:   query.Get("fullPage") == "true",
+		Format:     query.Get("format"),
+		Paper:      query.Get("paper"),
+		Landscape:  query.Get("landscape") == "true",
+		Background: query.Get("background") == "true",
+	}
+
+	var err error
+	for name, value := range map[string]*int{"width": &options.Width, "height": &options.Height, "quality": &options.Quality} {
+		if s := query.Get(name); s != "" {
+			if *value, err = strconv.Atoi(s); err != nil || *value < 0 {
+				return options, fmt.Errorf("Invalid %s: %s", name, s)
+			}
+		}
+	}
+	if s := query.Get("scale"); s != "" {
+		if options.Scale, err = strconv.ParseFloat(s, 64); err != nil || options.Scale <= 0 {
+			return options, fmt.Errorf("Invalid scale: %s", s)
+		}
+	}
+	if (options.Width > 0) != (options.Height > 0) {
+		return options, fmt.Errorf("Both width and height must be given")
+	}
+	if options.Format != "" && options.Format != "png" && options.Format != "jpeg" && options.Format != "webp" {
+		return options, fmt.Errorf("Unknown screenshot format: %s", options.Format)
+	}
+	if _, ok := papers[strings.ToLower(options.Paper)]; options.Paper != "" && !ok {
+		return options, fmt.Errorf("Unknown paper: %s", options.Paper)
+	}
+	return options, nil
+}
+
+// render handles a render request using the given renderer.
+func render(w http.ResponseWriter, r *http.Request, token *jwt.Token, contentType func(options RenderOptions) string,
+	renderer func(ctx context.Context, webstrate string, options RenderOptions) ([]byte, error)) {
+	webstrate := mux.Vars(r)["webstrate"]
+	if !authorized(w, webstrate, token) {
+		return
+	}
+
+	options, err := renderOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400 /* Bad request */)
+		return
+	}
+
+	// Rendering may take longer than the write timeout of the server
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.WithError(err).Warn("Could not clear write deadline of render request")
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), renderTimeout)
+	defer cancel()
+	data, err := renderer(ctx, webstrate, options)
+	if err != nil {
+		controlError(w, webstrate, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType(options))
+	w.Write(data)
+}
+
+// ScreenshotHandler responds with a screenshot of the page of a golem. See renderOptions for the query params.
+func ScreenshotHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
+	render(w, r, token, func(options RenderOptions) string {
+		if options.Format == "" {
+			return "image/png"
+		}
+		return "image/" + options.Format
+	}, Screenshot)
+}
+
+// PDFHandler responds with the page of a golem printed as pdf. See renderOptions for the query params.
+func PDFHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
+	render(w, r, token, func(options RenderOptions) string {
+		return "application/pdf"
+	}, PDF)
+}
@@ -0,0 +1,52 @@
+package golem
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Webstrates/golem-herder/container"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+)
+
+func TestRenderSpawnsGolems(t *testing.T) {
+	defer setup(t, container.NewFakeRuntime())()
+	viper.Set("golem-deny", []string{"denied-*"})
+	defer viper.Set("golem-deny", nil)
+
+	token := &jwt.Token{Claims: jwt.MapClaims{"wst": []interface{}{"render-*", "denied-*"}}}
+	screenshot := func(webstrate string, token *jwt.Token) int {
+		// Fake golems never serve the developer tools - so rendering can not succeed
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		r := httptest.NewRequest("GET", "/golem/v1/screenshot/"+webstrate, nil).WithContext(ctx)
+		r = mux.SetURLVars(r, map[string]string{"webstrate": webstrate})
+		w := httptest.NewRecorder()
+		ScreenshotHandler(w, r, token)
+		return w.Code
+	}
+	spawned := func(webstrate string) bool {
+		golems, _ := List()
+		for _, g := range golems {
+			if g.Labels[LabelWebstrate] == webstrate {
+				return true
+			}
+		}
+		return false
+	}
+
+	if code := screenshot("render-a", &jwt.Token{Claims: jwt.MapClaims{"wst": []interface{}{"other"}}}); code != 403 || spawned("render-a") {
+		t.Errorf("Token for another webstrate got %d (spawned: %v) - expected 403", code, spawned("render-a"))
+	}
+	if code := screenshot("denied-a", token); code != 403 || spawned("denied-a") {
+		t.Errorf("Denied webstrate got %d (spawned: %v) - expected 403", code, spawned("denied-a"))
+	}
+	screenshot("render-a", token)
+	if !spawned("render-a") {
+		t.Error("No golem spawned to render webstrate")
+	}
+	Kill("render-a")
+}
@@ -0,0 +1,92 @@
+package golem
+
+import (
+	"net/http"
+
+	"github.com/Webstrates/golem-herder/container"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+)
+
+// Status is the state of the golem on a webstrate.
+type Status struct {
+	Webstrate string
+	// ID of the container of the golem - if it exists
+	ID      string `json:",omitempty"`
+	Running bool
+	// State of the container of the golem (e.g. running or exited)
+	State string `json:",omitempty"`
+	// Profile the golem was spawned with
+	Profile string `json:",omitempty"`
+	// DevToolsPort is the host port of the remote debugging endpoint of the golem
+	DevToolsPort int64 `json:",omitempty"`
+	// Minions is the number of minions connected to the golem
+	Minions   int
+	Bootstrap *BootstrapStatus `json:",omitempty"`
+	Health    *HealthStatus    `json:",omitempty"`
+}
+
+// GetStatus returns the status of the golem on the given webstrate.
+func GetStatus(webstrate string) (*Status, error) {
+	status := &Status{Webstrate: webstrate}
+	client, err := container.GetRuntime()
+	if err != nil {
+		return nil, err
+	}
+	golems, err := container.List(client, container.WithLabel(LabelWebstrate, webstrate), true)
+	if err != nil {
+		return nil, err
+	}
+	for _, golem := range golems {
+		// Prefer the running container if a dead one is left as well
+		if status.ID != "" && golem.State != "running" {
+			continue
+		}
+		status.ID, status.State, status.Running = golem.ID, golem.State, golem.State == "running"
+		status.Profile = golem.Labels[LabelProfile]
+		status.DevToolsPort = 0
+		for _, port := range golem.Ports {
+			if port.PrivatePort == devtoolsPort {
+				status.DevToolsPort = port.PublicPort
+			}
+		}
+	}
+	status.Minions = minionsOf(webstrate)
+	if bootstrap, ok := BootstrapOf(webstrate); ok {
+		status.Bootstrap = &bootstrap
+	}
+	if health, ok := HealthOf(webstrate); ok {
+		status.Health = &health
+	}
+	if status.ID == "" && status.Bootstrap == nil && status.Health == nil {
+		return nil, ErrNotFound
+	}
+	return status, nil
+}
+
+// Public returns a copy of the status without the port of the developer tools and the error of the bootstrap -
+// which are only given to those with a token for the webstrate.
+func (s *Status) Public() *Status {
+	public := *s
+	public.DevToolsPort = 0
+	if s.Bootstrap != nil {
+		bootstrap := *s.Bootstrap
+		bootstrap.Error = ""
+		public.Bootstrap = &bootstrap
+	}
+	return &public
+}
+
+// StatusHandler responds with the status of the golem on a webstrate as json. The token must be scoped to the webstrate.
+func StatusHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
+	webstrate := mux.Vars(r)["webstrate"]
+	if !authorized(w, webstrate, token) {
+		return
+	}
+	status, err := GetStatus(webstrate)
+	if err != nil {
+		controlError(w, webstrate, err)
+		return
+	}
+	writeJSON(w, status)
+}
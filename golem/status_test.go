@@ -0,0 +1,55 @@
+package golem
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Webstrates/golem-herder/container"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+)
+
+func TestStatusHandler(t *testing.T) {
+	defer setup(t, container.NewFakeRuntime())()
+
+	if _, err := Spawn("status-a"); err != nil {
+		t.Fatal(err)
+	}
+	defer Kill("status-a")
+
+	status := func(token *jwt.Token) (*Status, int) {
+		r := httptest.NewRequest("GET", "/golem/v1/status/status-a", nil)
+		r = mux.SetURLVars(r, map[string]string{"webstrate": "status-a"})
+		w := httptest.NewRecorder()
+		StatusHandler(w, r, token)
+		s := &Status{}
+		if w.Code == 200 {
+			if err := json.Unmarshal(w.Body.Bytes(), s); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return s, w.Code
+	}
+
+	for _, token := range []*jwt.Token{
+		{Claims: jwt.MapClaims{"sub": "me@example.com"}},
+		{Claims: jwt.MapClaims{"wst": []interface{}{"status-b", "other-*"}}},
+	} {
+		if s, code := status(token); code != 403 || s.DevToolsPort != 0 {
+			t.Errorf("Status given for token %v (%d) - expected 403", token.Claims, code)
+		}
+	}
+	if s, code := status(&jwt.Token{Claims: jwt.MapClaims{"wst": []interface{}{"status-*"}}}); code != 200 || !s.Running || s.DevToolsPort == 0 {
+		t.Errorf("Status for token of the webstrate gave %d %+v", code, s)
+	}
+
+	s, err := GetStatus("status-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Bootstrap = &BootstrapStatus{State: "failed", Error: "secret"}
+	if public := s.Public(); public.DevToolsPort != 0 || public.Bootstrap.Error != "" || public.Bootstrap.State != "failed" || s.DevToolsPort == 0 || s.Bootstrap.Error == "" {
+		t.Errorf("Unexpected public status %+v of %+v", public, s)
+	}
+}
@@ -0,0 +1,292 @@
+package golem
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Webstrates/golem-herder/container"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Health states of a golem.
+const (
+	// HealthStarting is the state until the first health check of the golem
+	HealthStarting = "starting"
+	// HealthHealthy is the state when the last health check passed
+	HealthHealthy = "healthy"
+	// HealthUnhealthy is the state when the last health check failed - the golem is respawned after
+	// "golem-health-failures" failed checks in a row
+	HealthUnhealthy = "unhealthy"
+	// HealthRespawning is the state while waiting to respawn the golem
+	HealthRespawning = "respawning"
+	// HealthCrashLoop is the state when the golem was respawned too often and is no longer respawned
+	HealthCrashLoop = "crash-loop"
+	// HealthKilled is the state when the golem was killed through the herder
+	HealthKilled = "killed"
+)
+
+// maxRestartHistory is how many restarts are kept in the health status of a golem
+const maxRestartHistory = 10
+
+// maxBackoff is the longest time to wait before respawning a golem
+const maxBackoff = 5 * time.Minute
+
+// Respawn is the replacement of a dead or unhealthy golem by the supervisor.
+type Respawn struct {
+	Time   time.Time
+	Reason string
+}
+
+// HealthStatus is the health of the golem on a webstrate as seen by the supervisor.
+type HealthStatus struct {
+	State string
+	// Reason the last health check failed (if it did)
+	Reason string `json:",omitempty"`
+	// Failures is the number of failed health checks in a row
+	Failures int `json:",omitempty"`
+	Checked  time.Time
+	// Restarts is the number of times the golem has been respawned by the supervisor and History the last of them
+	Restarts int
+	History  []Respawn `json:",omitempty"`
+}
+
+// control is the state of the control websocket of a golem (see minion.GolemConnectHandler).
+type control struct {
+	connected bool
+	since     time.Time
+}
+
+var (
+	healths        = map[string]*HealthStatus{}
+	controls       = map[string]*control{}
+	killed         = map[string]bool{}
+	supervisorOnce sync.Once
+	healthMutex    = &sync.Mutex{}
+)
+
+// healthOf returns the health status of the golem on the given webstrate - it is created if needed.
+// Remember to lock healthMutex.
+func healthOf(webstrate string) *HealthStatus {
+	h, ok := healths[webstrate]
+	if !ok {
+		h = &HealthStatus{State: HealthStarting}
+		healths[webstrate] = h
+	}
+	return h
+}
+
+// HealthOf returns the health status of the golem on the given webstrate.
+func HealthOf(webstrate string) (HealthStatus, bool) {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+	h, ok := healths[webstrate]
+	if !ok {
+		return HealthStatus{}, false
+	}
+	status := *h
+	status.History = append([]Respawn{}, h.History...)
+	return status, true
+}
+
+// markKilled records that the golem in the container with the given id is killed on purpose and must not be respawned.
+func markKilled(id string) {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+	killed[id] = true
+}
+
+// ControlConnected records that the golem on the given webstrate connected (or disconnected) its control websocket.
+func ControlConnected(webstrate string, connected bool) {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+	controls[webstrate] = &control{connected: connected, since: time.Now()}
+}
+
+// check returns why the golem in the container with the given id is unhealthy - or "" if it is healthy.
+func check(id, webstrate string) string {
+	client, err := container.GetRuntime()
+	if err != nil {
+		return ""
+	}
+	c, err := client.InspectContainer(id)
+	if err != nil {
+		return fmt.Sprintf("Could not inspect container: %v", err)
+	}
+	if !c.State.Running {
+		return "Container is not running"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	if _, err := Evaluate(ctx, webstrate, "1"); err != nil {
+		if err == context.DeadlineExceeded {
+			return "Page does not respond"
+		}
+		return fmt.Sprintf("Page does not respond: %v", err)
+	}
+
+	// Golems need not use the control websocket - but once connected it should stay connected
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+	if ctrl, ok := controls[webstrate]; ok && !ctrl.connected && time.Since(ctrl.since) > viper.GetDuration("golem-health-interval") {
+		return "Control websocket is disconnected"
+	}
+	return ""
+}
+
+// respawn replaces the golem on the given webstrate after a backoff given by the number of recent restarts.
+// The golem is no longer respawned (the crash-loop state) when it has been respawned "golem-max-restarts"
+// times within "golem-restart-window". It tells whether the golem is gone - that is killed (or no longer running).
+func respawn(webstrate, reason string) bool {
+	logger := log.WithField("webstrate", webstrate).WithField("reason", reason)
+
+	healthMutex.Lock()
+	h := healthOf(webstrate)
+	recent := 0
+	for _, restart := range h.History {
+		if time.Since(restart.Time) < viper.GetDuration("golem-restart-window") {
+			recent++
+		}
+	}
+	h.Reason = reason
+	if recent >= viper.GetInt("golem-max-restarts") {
+		h.State = HealthCrashLoop
+		healthMutex.Unlock()
+		logger.Warn("Golem is crash-looping - it will not be respawned")
+		return false
+	}
+	backoff := viper.GetDuration("golem-restart-backoff")
+	for i := 0; i < recent && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	h.State = HealthRespawning
+	healthMutex.Unlock()
+
+	logger.WithField("backoff", backoff).Warn("Respawning golem")
+	time.Sleep(backoff)
+
+	client, err := container.GetRuntime()
+	if err != nil {
+		return false
+	}
+	// Dead golems are removed when spawning - the golem is respawned with the profile it was running with
+	profile := profileOf(webstrate)
+	golems, err := container.List(client, container.And(container.WithName(getName(webstrate)), container.WithState("running")), false)
+	if err != nil {
+		logger.WithError(err).Warn("Could not look for golem to respawn")
+		return false
+	}
+	if len(golems) > 0 {
+		if err := Kill(webstrate); err != nil {
+			logger.WithError(err).Warn("Could not kill unhealthy golem")
+			return false
+		}
+	}
+
+	healthMutex.Lock()
+	h = healthOf(webstrate)
+	h.Restarts++
+	h.History = append(h.History, Respawn{Time: time.Now(), Reason: reason})
+	if len(h.History) > maxRestartHistory {
+		h.History = h.History[len(h.History)-maxRestartHistory:]
+	}
+	healthMutex.Unlock()
+
+	if _, _, err := Ensure(webstrate, profile); err != nil {
+		logger.WithError(err).Warn("Could not respawn golem")
+	}
+	return true
+}
+
+// supervise checks the health of the golem in the container with the given id until it dies (or is found
+// unhealthy "golem-health-failures" times in a row) and respawns it unless it was killed on purpose.
+func supervise(id, webstrate string, died <-chan bool) {
+	healthMutex.Lock()
+	h := healthOf(webstrate)
+	h.State, h.Reason, h.Failures = HealthStarting, "", 0
+	delete(controls, webstrate)
+	healthMutex.Unlock()
+
+	interval := viper.GetDuration("golem-health-interval")
+	var ticks <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	intended := func() bool {
+		healthMutex.Lock()
+		defer healthMutex.Unlock()
+		defer delete(killed, id)
+		return killed[id]
+	}
+
+	for {
+		select {
+		case <-died:
+			if !intended() {
+				respawn(webstrate, "Golem died")
+				return
+			}
+			healthMutex.Lock()
+			healthOf(webstrate).State = HealthKilled
+			healthMutex.Unlock()
+			return
+		case <-ticks:
+			reason := check(id, webstrate)
+			healthMutex.Lock()
+			h := healthOf(webstrate)
+			h.Checked = time.Now()
+			if reason == "" {
+				h.State, h.Reason, h.Failures = HealthHealthy, "", 0
+				healthMutex.Unlock()
+				continue
+			}
+			h.State, h.Reason = HealthUnhealthy, reason
+			h.Failures++
+			failures := h.Failures
+			healthMutex.Unlock()
+
+			log.WithField("webstrate", webstrate).WithField("reason", reason).Warn("Golem is unhealthy")
+			if failures >= viper.GetInt("golem-health-failures") {
+				if respawn(webstrate, reason) {
+					// Wait for the unhealthy golem to die - it is not respawned again when it does
+					<-died
+				}
+				intended()
+				return
+			}
+		}
+	}
+}
+
+// Supervise starts supervising golems - respawning those which die or become unhealthy.
+func Supervise() {
+	supervisorOnce.Do(func() {
+		events := container.SubscribeRunning(container.All(
+			container.OfLabel(LabelWebstrate),
+			container.OfType(container.EventStart, container.EventDie)))
+
+		running := map[string]chan bool{}
+		go func() {
+			for event := range events.C {
+				if died, ok := running[event.ID]; ok {
+					close(died)
+					delete(running, event.ID)
+				}
+				if event.Type == container.EventStart {
+					died := make(chan bool)
+					running[event.ID] = died
+					go supervise(event.ID, event.Labels[LabelWebstrate], died)
+				}
+			}
+		}()
+	})
+}
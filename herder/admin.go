@@ -0,0 +1,136 @@
+package herder
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Webstrates/golem-herder/container"
+	"github.com/Webstrates/golem-herder/daemon"
+	"github.com/Webstrates/golem-herder/golem"
+	"github.com/Webstrates/golem-herder/minion"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReconcileReport describes what was done to reconcile the herder with the containers it found running.
+type ReconcileReport struct {
+	Time    time.Time
+	Daemons *daemon.ReconcileReport `json:",omitempty"`
+	Golems  *golem.ReconcileReport  `json:",omitempty"`
+	Lambdas *minion.ReconcileReport `json:",omitempty"`
+	// Ports are the released reservations of host ports of containers which are gone
+	Ports  []int    `json:",omitempty"`
+	Errors []string `json:",omitempty"`
+}
+
+var (
+	lastReconcile      *ReconcileReport
+	lastReconcileMutex = &sync.Mutex{}
+)
+
+// Reconcile re-attaches the herder to the daemons and golems left behind by a previous herder
+// and cleans up leftover lambdas. Golems are kept, restarted or killed as given by golemAction.
+func Reconcile(golemAction string) *ReconcileReport {
+	report := &ReconcileReport{Time: time.Now()}
+
+	var err error
+	if report.Daemons, err = daemon.Reconcile(); err != nil {
+		log.WithError(err).Warn("Could not reconcile daemons")
+		report.Errors = append(report.Errors, err.Error())
+	}
+	if report.Golems, err = golem.Reconcile(golemAction); err != nil {
+		log.WithError(err).Warn("Could not reconcile golems")
+		report.Errors = append(report.Errors, err.Error())
+	}
+	if report.Lambdas, err = minion.Reconcile(); err != nil {
+		log.WithError(err).Warn("Could not reconcile lambdas")
+		report.Errors = append(report.Errors, err.Error())
+	}
+	if report.Ports, err = container.ReconcilePorts(); err != nil {
+		log.WithError(err).Warn("Could not reconcile port reservations")
+		report.Errors = append(report.Errors, err.Error())
+	}
+
+	log.WithField("daemons", report.Daemons).
+		WithField("golems", report.Golems).
+		WithField("lambdas", report.Lambdas).
+		WithField("ports", report.Ports).
+		Info("Reconciled with running containers")
+
+	lastReconcileMutex.Lock()
+	lastReconcile = report
+	lastReconcileMutex.Unlock()
+
+	return report
+}
+
+// AdminHandler will return a http handler which requires the given password (as the password query param)
+// prior to invoking the given handler.
+func AdminHandler(password string, handler func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if password == "" {
+			log.Warn("Trying to use admin endpoint with no token password set")
+			http.Error(w, "No token password set", 405 /* Method Not Allowed */)
+			return
+		}
+		if r.URL.Query().Get("password") != password {
+			log.Warn("Unauthorized admin request")
+			http.Error(w, "Invalid password", 401 /* Unauthorized */)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// ReconcileHandler shows the report of the last reconciliation
+func ReconcileHandler(w http.ResponseWriter, r *http.Request) {
+	lastReconcileMutex.Lock()
+	report := lastReconcile
+	lastReconcileMutex.Unlock()
+
+	if report == nil {
+		http.Error(w, "No reconciliation done yet", 404)
+		return
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Write(data)
+}
+
+// GolemPolicyHandler shows the state of the policies limiting golems - the idle timeout, the max number of golems,
+// the activity of the running golems, the pinned webstrates and the golems reaped recently.
+func GolemPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	state, err := golem.Policy()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Write(data)
+}
+
+// PinHandler pins (POST) or unpins (DELETE) the golem on a webstrate so it is never reaped.
+func PinHandler(w http.ResponseWriter, r *http.Request) {
+	webstrate := mux.Vars(r)["webstrate"]
+	pin := r.Method != "DELETE"
+	golem.Pin(webstrate, pin)
+	if pin {
+		w.Write([]byte(fmt.Sprintf("Golem for %s pinned", webstrate)))
+		return
+	}
+	w.Write([]byte(fmt.Sprintf("Golem for %s unpinned", webstrate)))
+}
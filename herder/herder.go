@@ -5,9 +5,15 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"regexp"
+	"time"
 
+	"github.com/Webstrates/golem-herder/container"
 	"github.com/Webstrates/golem-herder/golem"
+	"github.com/Webstrates/golem-herder/token"
+	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
@@ -15,9 +21,15 @@ import (
 type TemplateContext struct {
 	ID      string
 	BaseURL string
+	// Ticket is a signed ticket to spawn a golem on the webstrate (if tickets are required)
+	Ticket string
 }
 
-// HomeHandler will serve the js emet init file
+// ticketPattern matches well-formed spawn tickets (see golem.Ticket)
+var ticketPattern = regexp.MustCompile(`^[0-9]+\.[0-9a-f]{64}$`)
+
+// HomeHandler will serve the js emet init file. A spawn ticket given by the ticket query param (see TicketHandler)
+// is embedded in the file - the ticket is checked when spawning.
 func HomeHandler(w http.ResponseWriter, r *http.Request) {
 
 	tmpl, err := template.ParseFiles("emet.tmpl.js")
@@ -26,6 +38,9 @@ func HomeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	context := TemplateContext{BaseURL: viper.GetString("url")}
+	if ticket := r.URL.Query().Get("ticket"); ticketPattern.MatchString(ticket) {
+		context.Ticket = ticket
+	}
 
 	err = tmpl.Execute(w, context)
 }
@@ -43,27 +58,141 @@ func ListHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
-// SpawnHandler will spawn a new golem for the webstrate given by the mux.Vars
-func SpawnHandler(w http.ResponseWriter, r *http.Request) {
+// SpawnHandler returns a handler of POST requests which will spawn a new golem for the webstrate given by the
+// mux.Vars - unless one is already running - and respond with the status of the golem (201 if it was spawned, 200
+// if it was running). Golems must be allowed on the webstrate and - if tickets are required - the request must give
+// a spawn ticket (the ticket form value) or a token scoped to the webstrate. The profile form value selects the
+// golem profile.
+func SpawnHandler(m *token.Manager) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Golems must not be spawned by following a link or including the url in a page
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", 405 /* Method Not Allowed */)
+			return
+		}
+
+		// we need id for webstrate
+		vars := mux.Vars(r)
+		wsid := vars["webstrate"]
+
+		if !golem.Allowed(wsid) {
+			http.Error(w, golem.ErrNotAllowed.Error(), 403 /* Forbidden */)
+			return
+		}
+
+		if golem.TicketsRequired() && !golem.ValidTicket(wsid, r.FormValue("ticket")) {
+			t, err := m.ValidateRequest(r)
+			if err != nil || !golem.Scoped(t, wsid) {
+				log.WithField("webstrate", wsid).Warn("Spawn request without valid ticket or token")
+				http.Error(w, "A valid spawn ticket or a token for the webstrate is required", 401 /* Unauthorized */)
+				return
+			}
+		}
+
+		profile := r.FormValue("profile")
+		if profile != "" {
+			if _, err := golem.GetProfile(profile); err != nil {
+				http.Error(w, err.Error(), 400 /* Bad request */)
+				return
+			}
+		}
+
+		_, created, err := golem.Ensure(wsid, profile)
+		if err != nil {
+			if err == container.ErrNoPortsAvailable || err == golem.ErrCapacity {
+				http.Error(w, err.Error(), 503 /* Service Unavailable */)
+				return
+			}
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		status, err := golem.GetStatus(wsid)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		// Only those with a token for the webstrate see the port of its developer tools and its bootstrap errors
+		if t, err := m.ValidateRequest(r); err != nil || !golem.Scoped(t, wsid) {
+			status = status.Public()
+		}
+		data, err := json.Marshal(status)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if created {
+			w.WriteHeader(201 /* Created */)
+		}
+		w.Write(data)
+	}
+}
 
-	// we need id for webstrate
+// scoped checks that the token is scoped to the webstrate of the request (see golem.Scoped).
+func scoped(w http.ResponseWriter, wsid string, token *jwt.Token) bool {
+	if !golem.Scoped(token, wsid) {
+		http.Error(w, "Token does not give access to the golem of this webstrate", 403 /* Forbidden */)
+		return false
+	}
+	return true
+}
+
+// SpawnTicket is a signed, time-limited ticket to spawn a golem on a webstrate. Script is the url of the emet js
+// init file embedding the ticket - include it in the webstrate.
+type SpawnTicket struct {
+	Ticket  string
+	Script  string
+	Expires time.Time
+}
+
+// TicketHandler responds with a spawn ticket for the webstrate given by the mux.Vars. Tickets are only given to
+// tokens scoped to the webstrate. The duration of the ticket is given by the query param ttl (e.g. 720h) -
+// golem.TicketTTL by default.
+func TicketHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
 	vars := mux.Vars(r)
 	wsid := vars["webstrate"]
+	if !scoped(w, wsid, token) {
+		return
+	}
+	if !golem.Allowed(wsid) {
+		http.Error(w, golem.ErrNotAllowed.Error(), 403 /* Forbidden */)
+		return
+	}
+
+	ttl := golem.TicketTTL
+	if value := r.URL.Query().Get("ttl"); value != "" {
+		var err error
+		if ttl, err = time.ParseDuration(value); err != nil || ttl <= 0 || ttl > golem.MaxTicketTTL {
+			http.Error(w, fmt.Sprintf("Invalid ttl: %s", value), 400 /* Bad request */)
+			return
+		}
+	}
 
-	containerID, err := golem.Spawn(wsid)
+	expires := time.Now().Add(ttl)
+	ticket := golem.Ticket(wsid, expires)
+	data, err := json.Marshal(SpawnTicket{
+		Ticket:  ticket,
+		Script:  fmt.Sprintf("https://%s/golem/v1/?ticket=%s", viper.GetString("url"), ticket),
+		Expires: expires,
+	})
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
-
-	w.Write([]byte(fmt.Sprintf("%s lumbering along", containerID)))
-
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
 }
 
 // ResetHandler will reset/reload the golem on the given webstrate
-func ResetHandler(w http.ResponseWriter, r *http.Request) {
+func ResetHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
 	vars := mux.Vars(r)
 	wsid := vars["webstrate"]
+	if !scoped(w, wsid, token) {
+		return
+	}
 	containerID, err := golem.Restart(wsid)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
@@ -73,10 +202,13 @@ func ResetHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // KillHandler will kill the golem
-func KillHandler(w http.ResponseWriter, r *http.Request) {
+func KillHandler(w http.ResponseWriter, r *http.Request, token *jwt.Token) {
 	// kill, kill, kill
 	vars := mux.Vars(r)
 	wsid := vars["webstrate"]
+	if !scoped(w, wsid, token) {
+		return
+	}
 
 	err := golem.Kill(wsid)
 	if err != nil {
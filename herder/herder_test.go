@@ -0,0 +1,191 @@
+package herder
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Webstrates/golem-herder/container"
+	"github.com/Webstrates/golem-herder/golem"
+	"github.com/Webstrates/golem-herder/token"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+)
+
+// setup configures golems to be spawned with a fake runtime and returns a token manager with new keys.
+func setup(t *testing.T) *token.Manager {
+	dir := t.TempDir()
+	container.SetRuntime(container.NewFakeRuntime())
+	viper.Set("ports-db", filepath.Join(dir, "ports.db"))
+	viper.Set("webstrates", "webstrates.example.com")
+	viper.Set("golem-profiles", map[string]interface{}{
+		golem.DefaultProfile: map[string]interface{}{"seccomp": filepath.Join("..", "chrome.json")},
+	})
+	t.Cleanup(func() { viper.Set("golem-profiles", nil) })
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, priv := filepath.Join(dir, "key.pub"), filepath.Join(dir, "key")
+	os.WriteFile(pub, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}), 0600)
+	os.WriteFile(priv, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600)
+	m, err := token.NewManager(pub, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// spawned tells whether a golem runs on the webstrate.
+func spawned(webstrate string) bool {
+	golems, _ := golem.List()
+	for _, g := range golems {
+		if g.Labels[golem.LabelWebstrate] == webstrate {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSpawnHandler(t *testing.T) {
+	m := setup(t)
+	viper.Set("golem-spawn-tickets", true)
+	viper.Set("golem-deny", []string{"denied-*"})
+	defer viper.Set("golem-spawn-tickets", false)
+	defer viper.Set("golem-deny", nil)
+
+	scoped, err := m.Generate("me@example.com", jwt.MapClaims{"wst": []string{"ws-*", "denied-*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unscoped, err := m.Generate("me@example.com", jwt.MapClaims{"wst": []string{"other-*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The ticket is given as form value and the token as query param
+	spawn := func(method, webstrate, ticket, token string) (int, *golem.Status) {
+		form := url.Values{}
+		if ticket != "" {
+			form.Set("ticket", ticket)
+		}
+		r := httptest.NewRequest(method, "/golem/v1/spawn/"+webstrate+"?"+url.Values{"token": {token}}.Encode(), strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r = mux.SetURLVars(r, map[string]string{"webstrate": webstrate})
+		w := httptest.NewRecorder()
+		SpawnHandler(m)(w, r)
+		status := &golem.Status{}
+		if w.Code == 200 || w.Code == 201 {
+			if err := json.Unmarshal(w.Body.Bytes(), status); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w.Code, status
+	}
+	defer golem.Kill("ws-ticket")
+	defer golem.Kill("ws-token")
+
+	valid := golem.Ticket("ws-ticket", time.Now().Add(time.Hour))
+	for _, test := range []struct {
+		description string
+		method      string
+		webstrate   string
+		ticket      string
+		token       string
+		code        int
+	}{
+		{"GET request", "GET", "ws-ticket", valid, "", 405},
+		{"Denied webstrate", "POST", "denied-ws", golem.Ticket("denied-ws", time.Now().Add(time.Hour)), scoped, 403},
+		{"No ticket", "POST", "ws-ticket", "", "", 401},
+		{"Expired ticket", "POST", "ws-ticket", golem.Ticket("ws-ticket", time.Now().Add(-time.Second)), "", 401},
+		{"Forged ticket", "POST", "ws-ticket", strings.SplitN(valid, ".", 2)[0] + "." + strings.Repeat("0", 64), "", 401},
+		{"Ticket for another webstrate", "POST", "ws-other", valid, "", 401},
+		{"Token for other webstrates", "POST", "ws-ticket", "", unscoped, 401},
+		{"Invalid token", "POST", "ws-ticket", "", scoped + "x", 401},
+	} {
+		if code, _ := spawn(test.method, test.webstrate, test.ticket, test.token); code != test.code || spawned(test.webstrate) {
+			t.Errorf("%s gave %d (spawned: %v) - expected %d", test.description, code, spawned(test.webstrate), test.code)
+		}
+	}
+
+	// Spawning is idempotent and the port of the developer tools is only given with a token for the webstrate
+	code, status := spawn("POST", "ws-ticket", valid, "")
+	if code != 201 || !status.Running || status.DevToolsPort != 0 {
+		t.Errorf("Spawn with valid ticket gave %d %+v", code, status)
+	}
+	if code, status := spawn("POST", "ws-ticket", valid, scoped); code != 200 || !status.Running || status.DevToolsPort == 0 {
+		t.Errorf("Spawn with running golem gave %d %+v", code, status)
+	}
+	if code, _ := spawn("POST", "ws-token", "", scoped); code != 201 || !spawned("ws-token") {
+		t.Errorf("Spawn with token for the webstrate gave %d", code)
+	}
+}
+
+func TestTicketHandler(t *testing.T) {
+	setup(t)
+	viper.Set("golem-deny", []string{"denied-*"})
+	defer viper.Set("golem-deny", nil)
+
+	scoped := &jwt.Token{Claims: jwt.MapClaims{"wst": []interface{}{"ws-*", "denied-*"}}}
+	ticket := func(webstrate, query string, token *jwt.Token) (int, *SpawnTicket) {
+		r := httptest.NewRequest("GET", "/golem/v1/ticket/"+webstrate+"?"+query, nil)
+		r = mux.SetURLVars(r, map[string]string{"webstrate": webstrate})
+		w := httptest.NewRecorder()
+		TicketHandler(w, r, token)
+		ticket := &SpawnTicket{}
+		if w.Code == 200 {
+			if err := json.Unmarshal(w.Body.Bytes(), ticket); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w.Code, ticket
+	}
+
+	code, spawnTicket := ticket("ws-a", "", scoped)
+	if code != 200 || !golem.ValidTicket("ws-a", spawnTicket.Ticket) || !strings.Contains(spawnTicket.Script, "ticket="+spawnTicket.Ticket) {
+		t.Errorf("Ticket gave %d %+v", code, spawnTicket)
+	}
+	if until := time.Until(spawnTicket.Expires); until > golem.TicketTTL || until < golem.TicketTTL-time.Minute {
+		t.Errorf("Ticket expires in %v - expected %v", until, golem.TicketTTL)
+	}
+	if golem.ValidTicket("ws-b", spawnTicket.Ticket) {
+		t.Error("Ticket valid for another webstrate")
+	}
+	if code, spawnTicket := ticket("ws-a", "ttl=2h", scoped); code != 200 || time.Until(spawnTicket.Expires) > 2*time.Hour {
+		t.Errorf("Ticket with ttl gave %d %+v", code, spawnTicket)
+	}
+
+	for _, test := range []struct {
+		description string
+		webstrate   string
+		query       string
+		token       *jwt.Token
+		code        int
+	}{
+		{"Token for other webstrates", "ws-a", "", &jwt.Token{Claims: jwt.MapClaims{"wst": []interface{}{"other-*"}}}, 403},
+		{"Token without webstrates", "ws-a", "", &jwt.Token{Claims: jwt.MapClaims{"sub": "me@example.com"}}, 403},
+		{"Denied webstrate", "denied-a", "", scoped, 403},
+		{"Invalid ttl", "ws-a", "ttl=soon", scoped, 400},
+		{"Negative ttl", "ws-a", "ttl=-1h", scoped, 400},
+		{"Too long ttl", "ws-a", "ttl=10000h", scoped, 400},
+	} {
+		if code, _ := ticket(test.webstrate, test.query, test.token); code != test.code {
+			t.Errorf("%s gave %d - expected %d", test.description, code, test.code)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package herder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Webstrates/golem-herder/container"
+	"github.com/Webstrates/golem-herder/daemon"
+	"github.com/Webstrates/golem-herder/golem"
+	"github.com/Webstrates/golem-herder/token"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// route is where requests on a host are sent - either a daemon or the developer tools of a golem.
+type route struct {
+	// ID is the container the route was made for
+	ID        string
+	Daemon    string
+	Webstrate string
+}
+
+var (
+	// routes contains the routes of the running daemons and golems by host (without the herder url)
+	routes      = map[string]route{}
+	routesMutex = &sync.Mutex{}
+	routesOnce  sync.Once
+	// validLabel matches names which are used as host labels as they are
+	validLabel   = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+	invalidLabel = regexp.MustCompile(`[^a-z0-9-]+`)
+	// encodedLabel matches labels ending like those of encoded names - names matching it are encoded as well
+	encodedLabel = regexp.MustCompile(`-[0-9a-f]{12}$`)
+)
+
+// hostLabel turns the given name into something which can be used as part of a host name. Names which are valid
+// host labels are used as they are. Other names are lowercased, characters not allowed in host names are replaced
+// by - and a hash of the name is appended, e.g. me@example.com gives me-example-com-<hash>, so different names
+// never give the same label. The reserved label golem (used for golem hosts) is encoded as well.
+func hostLabel(name string) string {
+	if validLabel.MatchString(name) && !encodedLabel.MatchString(name) && name != "golem" {
+		return name
+	}
+	label := strings.Trim(invalidLabel.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(label) > 50 {
+		label = strings.TrimRight(label[:50], "-")
+	}
+	hash := sha256.Sum256([]byte(name))
+	if label == "" {
+		return hex.EncodeToString(hash[:6])
+	}
+	return label + "-" + hex.EncodeToString(hash[:6])
+}
+
+// hostOf returns the host (without the herder url) of the daemon or golem with the given labels
+// - <name>.<subject> for daemons and <webstrate>.golem for golems.
+func hostOf(name string, labels map[string]string) (string, route, bool) {
+	if webstrate, ok := labels[golem.LabelWebstrate]; ok {
+		return hostLabel(webstrate) + ".golem", route{Webstrate: webstrate}, true
+	}
+	if _, ok := labels["tokenid"]; ok && labels["subject"] != "" {
+		return hostLabel(name) + "." + hostLabel(labels["subject"]), route{Daemon: name}, true
+	}
+	return "", route{}, false
+}
+
+// trackRoutes keeps the routes up to date with daemons and golems starting and dying.
+func trackRoutes() {
+	events := container.SubscribeRunning(container.OfType(container.EventStart, container.EventDie))
+
+	go func() {
+		for event := range events.C {
+			host, r, ok := hostOf(event.Name, event.Labels)
+			if !ok {
+				continue
+			}
+			routesMutex.Lock()
+			if event.Type == container.EventDie {
+				if existing, ok := routes[host]; ok && existing.ID == event.ID {
+					delete(routes, host)
+				}
+			} else if existing, ok := routes[host]; ok && (existing.Daemon != r.Daemon || existing.Webstrate != r.Webstrate) {
+				// Labels are unique by name - but never let one container take over the host of another
+				log.WithField("host", host).WithField("container", event.ID).Warn("Host is already routed to another container")
+			} else {
+				r.ID = event.ID
+				routes[host] = r
+			}
+			routesMutex.Unlock()
+		}
+	}()
+}
+
+// HostRouter routes requests on <name-of-daemon>.<subject>.<url> to daemons (use <port>.<name-of-daemon>.<subject>.<url>
+// for other than the first port) and requests on <webstrate>.golem.<url> to the developer tools of golems.
+// Names are turned into host labels by hostLabel, e.g. daemon editor-1 of me@example.com is reached on
+// editor-1.me-example-com-<hash>.<url>. The developer tools of a golem require a token
+// scoped to its webstrate (see golem.AuthorizeDevTools). Other requests are passed on to next.
+func HostRouter(m *token.Manager, next http.Handler) http.Handler {
+	routesOnce.Do(trackRoutes)
+	golemProxy := golem.NewGolemReverseProxy("", golem.PortOf)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.ToLower(r.Host)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		url := strings.ToLower(viper.GetString("url"))
+		if h, _, err := net.SplitHostPort(url); err == nil {
+			url = h
+		}
+		if url == "" || !strings.HasSuffix(host, "."+url) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		labels := strings.Split(strings.TrimSuffix(host, "."+url), ".")
+		port := 0
+		if len(labels) == 3 {
+			p, err := strconv.Atoi(labels[0])
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			port, labels = p, labels[1:]
+		}
+		if len(labels) != 2 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		routesMutex.Lock()
+		rt, ok := routes[strings.Join(labels, ".")]
+		routesMutex.Unlock()
+		switch {
+		case !ok:
+			http.Error(w, "No daemon or golem found", 404)
+		case rt.Daemon != "":
+			daemon.ProxyHostHandler(m, rt.Daemon, port)(w, r)
+		case port != 0:
+			http.Error(w, "Golems can not be reached on other ports", 404)
+		case !golem.AuthorizeDevTools(m, w, r, rt.Webstrate):
+			http.Error(w, "A token for the webstrate is required to use the developer tools of its golem", 401 /* Unauthorized */)
+		default:
+			// The golem proxy expects the webstrate in front of the path
+			r.URL.Path = "/" + rt.Webstrate + r.URL.Path
+			r.URL.RawPath = ""
+			golemProxy.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,23 @@
+package herder
+
+import "testing"
+
+func TestHostLabel(t *testing.T) {
+	if label := hostLabel("editor-1"); label != "editor-1" {
+		t.Errorf("Valid label changed: %s", label)
+	}
+	labels := map[string]string{}
+	for _, name := range []string{"me.example.com", "me-example-com", "Me-Example-Com", "golem", "me@example.com", hostLabel("me.example.com")} {
+		label := hostLabel(name)
+		if !validLabel.MatchString(label) {
+			t.Errorf("Invalid label %s for %s", label, name)
+		}
+		if other, ok := labels[label]; ok {
+			t.Errorf("%s and %s both give %s", name, other, label)
+		}
+		labels[label] = name
+	}
+	if hostLabel("golem") == "golem" {
+		t.Error("The golem label is reserved")
+	}
+}
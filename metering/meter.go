@@ -3,25 +3,46 @@ package metering
 import (
 	"fmt"
 	"strconv"
+	"sync"
 
 	"github.com/boltdb/bolt"
 	log "github.com/sirupsen/logrus"
 )
 
-var db *bolt.DB
+var (
+	db      *bolt.DB
+	dbMutex = &sync.Mutex{}
+)
 
-func init() {
-	// If already initialized, just return
-	if db != nil {
-		return
-	}
-	meterdb, err := bolt.Open("meter.db", 0600, nil)
+// Open makes the meters use the database at the given path - closing the database used so far.
+// Unless opened, meter.db in the working directory is used.
+func Open(path string) error {
+	meterdb, err := bolt.Open(path, 0600, nil)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+	if db != nil {
+		db.Close()
 	}
 	db = meterdb
+	return nil
+}
 
-	// TODO Start token removal process
+// database returns the meter database - opening meter.db on first use.
+func database() (*bolt.DB, error) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+	if db == nil {
+		meterdb, err := bolt.Open("meter.db", 0600, nil)
+		if err != nil {
+			return nil, err
+		}
+		db = meterdb
+		// TODO Start token removal process
+	}
+	return db, nil
 }
 
 // NewMeter returns a new Meter for the given token
@@ -30,7 +51,11 @@ func init() {
 // Each Bucket has the following properties:
 // * Credits
 func NewMeter(id string, token string, expiration int, credits int) (*Meter, error) {
-	err := db.Update(func(tx *bolt.Tx) error {
+	db, err := database()
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte(id))
 		if err != nil {
 			return err
@@ -69,10 +94,33 @@ func NewMeter(id string, token string, expiration int, credits int) (*Meter, err
 	return &Meter{ID: id, db: db}, nil
 }
 
+// ExistingMeter returns the Meter for the given id without adding any credits.
+// It fails if no tokens have been metered for the id.
+func ExistingMeter(id string) (*Meter, error) {
+	db, err := database()
+	if err != nil {
+		return nil, err
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(id)) == nil {
+			return fmt.Errorf("No meter found for %s", id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Meter{ID: id, db: db}, nil
+}
+
 // Credits by email
 func Credits(email string) (int, bool) {
+	db, err := database()
+	if err != nil {
+		return -1, false
+	}
 	var credits int
-	err := db.Update(func(tx *bolt.Tx) error {
+	err = db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(email))
 		if b == nil {
 			return nil
@@ -118,8 +166,12 @@ func (m *Meter) Credits() (int, error) {
 }
 
 func (m *Meter) readIntegers(keys ...string) ([]int, error) {
+	db, err := database()
+	if err != nil {
+		return nil, err
+	}
 	values := make([]int, len(keys))
-	err := db.View(func(tx *bolt.Tx) error {
+	err = db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(m.ID))
 		if b != nil {
 			for i := 0; i < len(keys); i++ {
@@ -144,6 +196,10 @@ func (m *Meter) readIntegers(keys ...string) ([]int, error) {
 
 // Record will record the usage of the given amount of credits
 func (m *Meter) Record(credits int) error {
+	db, err := database()
+	if err != nil {
+		return err
+	}
 	return db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(m.ID))
 		if b != nil {
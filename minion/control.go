@@ -0,0 +1,259 @@
+package minion
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Webstrates/golem-herder/daemon"
+	"github.com/Webstrates/golem-herder/golem"
+	"github.com/Webstrates/golem-herder/token"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+// Requests a golem can make on its control websocket (see GolemConnectHandler).
+const (
+	// RequestListMinions lists the minions connected to the golem
+	RequestListMinions = "list-minions"
+	// RequestDisconnectMinion disconnects the minion given by Minion
+	RequestDisconnectMinion = "disconnect-minion"
+	// RequestSpawnLambda runs a lambda (see Spawn) given by Env, Output and Files
+	RequestSpawnLambda = "spawn-lambda"
+	// RequestSpawnDaemon spawns a daemon given by Name, Image, Ports, Files, Resources and Access with Token
+	RequestSpawnDaemon = "spawn-daemon"
+	// RequestListDaemons lists the daemons of the owner of Token filtered by State and Name
+	RequestListDaemons = "list-daemons"
+	// RequestKillDaemon kills the daemon given by Name (wiping its data if Wipe) with Token
+	RequestKillDaemon = "kill-daemon"
+	// RequestStatus gives the status of the herder and the golem
+	RequestStatus = "status"
+)
+
+// Request is a request from a golem on its control websocket. It is answered by a reply or an error event with
+// the same ID. Which of the other fields are used depends on the request.
+type Request struct {
+	Request string
+	ID      string
+	// Minion is the id of a minion
+	Minion string
+	// Env, Output and Files of a lambda
+	Env    string
+	Output string
+	Files  map[string]string
+	// Token authorizes daemon requests
+	Token string
+	// Name, Image, Ports, Resources and Access of a daemon
+	Name      string
+	Image     string
+	Ports     []int
+	Resources string
+	Access    string
+	// State filters listed daemons and Wipe removes the data of killed daemons
+	State string
+	Wipe  bool
+}
+
+// ReplyEvent is the reply to a request from a golem
+type ReplyEvent struct {
+	Event  string
+	ID     string
+	Result interface{} `json:",omitempty"`
+}
+
+// ErrorEvent is sent when a request from a golem fails
+type ErrorEvent struct {
+	Event string
+	ID    string
+	Error string
+}
+
+// LambdaEvent is sent when a lambda requested by a golem is started
+type LambdaEvent struct {
+	Event string
+	ID    string
+	Env   string
+}
+
+// MinionInfo is a minion connected to a golem
+type MinionInfo struct {
+	ID   string
+	Type string `json:",omitempty"`
+}
+
+// LambdaResult is the result of a lambda requested by a golem. Output is json output (e.g. stdout) - other
+// output (e.g. a file) is given as Content.
+type LambdaResult struct {
+	MimeType string
+	Output   json.RawMessage `json:",omitempty"`
+	Content  []byte          `json:",omitempty"`
+}
+
+// HerderStatus is the status of the herder as seen by a golem
+type HerderStatus struct {
+	// Golems is the number of running golems
+	Golems int
+	// Minions is the number of minions connected to the golem
+	Minions int
+	Golem   *golem.Status `json:",omitempty"`
+}
+
+// NewReply creates and returns a ReplyEvent for the request with the given id
+func NewReply(id string, result interface{}) ReplyEvent {
+	return ReplyEvent{
+		Event:  "reply",
+		ID:     id,
+		Result: result}
+}
+
+// NewError creates and returns an ErrorEvent for the request with the given id
+func NewError(id string, err error) ErrorEvent {
+	return ErrorEvent{
+		Event: "error",
+		ID:    id,
+		Error: err.Error()}
+}
+
+// NewLambdaStarted creates and returns a LambdaEvent for the request with the given id
+func NewLambdaStarted(id, env string) LambdaEvent {
+	return LambdaEvent{
+		Event: "lambda-started",
+		ID:    id,
+		Env:   env}
+}
+
+// sendTimeout is how long sending an event to a golem may wait for the golem to keep up
+const sendTimeout = 10 * time.Second
+
+// send sends the event to the golem. Events are never dropped - if the golem does not keep up within sendTimeout
+// its connection is closed (the golem reconnects and the requests it had made fail).
+func (g *Golem) send(event interface{}) {
+	content, err := json.Marshal(event)
+	if err != nil {
+		log.WithError(err).Warn("Error marshaling event for golem")
+		return
+	}
+	select {
+	case g.to <- Message{Type: websocket.TextMessage, Content: content}:
+	case <-time.After(sendTimeout):
+		log.Warn("Golem does not keep up - closing connection")
+		if g.conn != nil {
+			g.conn.Close()
+		}
+	}
+}
+
+// parseRequest returns the request in the given message from a golem - or false if it is not a request.
+func parseRequest(content []byte) (*Request, bool) {
+	request := &Request{}
+	if err := json.Unmarshal(content, request); err != nil || request.Request == "" {
+		return nil, false
+	}
+	return request, true
+}
+
+// handleRequest answers the request from the golem on the given webstrate. Requests are handled concurrently
+// so a slow request (e.g. a lambda) does not hold up others.
+func handleRequest(m *token.Manager, g *Golem, webstrate string, request *Request) {
+	logger := log.WithField("webstrate", webstrate).WithField("request", request.Request).WithField("id", request.ID)
+	result, err := answer(m, g, webstrate, request)
+	if err != nil {
+		logger.WithError(err).Warn("Golem request failed")
+		g.send(NewError(request.ID, err))
+		return
+	}
+	g.send(NewReply(request.ID, result))
+}
+
+// answer returns the result of the request from the golem on the given webstrate.
+func answer(m *token.Manager, g *Golem, webstrate string, request *Request) (interface{}, error) {
+	switch request.Request {
+	case RequestListMinions:
+		return connectedMinions(webstrate), nil
+	case RequestDisconnectMinion:
+		return nil, disconnectMinion(webstrate, request.Minion)
+	case RequestSpawnLambda:
+		if request.Env == "" {
+			return nil, fmt.Errorf("Missing env")
+		}
+		files := map[string][]byte{}
+		for name, content := range request.Files {
+			files[name] = []byte(content)
+		}
+		g.send(NewLambdaStarted(request.ID, request.Env))
+		touch(webstrate)
+		output, mimeType, err := Spawn(request.Env, request.Output, files)
+		if err != nil {
+			return nil, err
+		}
+		result := LambdaResult{MimeType: mimeType}
+		if strings.HasPrefix(mimeType, "application/json") && json.Valid(output) {
+			result.Output = output
+		} else {
+			result.Content = output
+		}
+		return result, nil
+	case RequestSpawnDaemon, RequestListDaemons, RequestKillDaemon:
+		t, err := m.Validate(request.Token)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid token - %v", err)
+		}
+		switch request.Request {
+		case RequestSpawnDaemon:
+			files := map[string][]byte{}
+			for name, content := range request.Files {
+				files[name] = []byte(content)
+			}
+			return daemon.SpawnMetered(t, daemon.SpawnRequest{
+				Name:      request.Name,
+				Image:     request.Image,
+				Ports:     request.Ports,
+				Files:     files,
+				Resources: request.Resources,
+				Access:    request.Access,
+			})
+		case RequestListDaemons:
+			return daemon.List(t, request.State, request.Name)
+		default:
+			return nil, daemon.Kill(request.Name, request.Wipe, t)
+		}
+	case RequestStatus:
+		golems, err := golem.List()
+		if err != nil {
+			return nil, err
+		}
+		status := HerderStatus{Golems: len(golems), Minions: len(connectedMinions(webstrate))}
+		if s, err := golem.GetStatus(webstrate); err == nil {
+			status.Golem = s
+		}
+		return status, nil
+	}
+	return nil, fmt.Errorf("Unknown request: %s", request.Request)
+}
+
+// connectedMinions returns the minions connected to the golem on the given webstrate.
+func connectedMinions(webstrate string) []MinionInfo {
+	mutex.Lock()
+	defer mutex.Unlock()
+	infos := []MinionInfo{}
+	for _, minion := range minions[webstrate] {
+		infos = append(infos, MinionInfo{ID: minion.ID, Type: minion.Type})
+	}
+	return infos
+}
+
+// disconnectMinion closes the connection of the minion with the given id to the golem on the given webstrate.
+func disconnectMinion(webstrate, id string) error {
+	mutex.Lock()
+	var conn *websocket.Conn
+	if minion, ok := minions[webstrate][id]; ok {
+		conn = minion.conn
+	}
+	mutex.Unlock()
+	if conn == nil {
+		return fmt.Errorf("No such minion: %s", id)
+	}
+	// The minion is cleaned up (and the golem told) when its connection fails
+	return conn.Close()
+}
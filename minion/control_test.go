@@ -0,0 +1,30 @@
+package minion
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSendWaitsForGolem(t *testing.T) {
+	g := &Golem{to: make(chan Message, 1), done: make(chan bool, 1)}
+	g.send(NewReply("1", nil))
+
+	// The golem is slow - the reply must wait rather than be dropped
+	sent := make(chan bool)
+	go func() {
+		g.send(NewReply("2", nil))
+		close(sent)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	for _, id := range []string{"1", "2"} {
+		reply := ReplyEvent{}
+		if err := json.Unmarshal((<-g.to).Content, &reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply.ID != id {
+			t.Errorf("Got reply %s - expected %s", reply.ID, id)
+		}
+	}
+	<-sent
+}
@@ -7,15 +7,19 @@ import (
 	"io/ioutil"
 	"mime"
 	"net/http"
+	"os"
 	"path/filepath"
 	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/Webstrates/golem-herder/container"
+	"github.com/Webstrates/golem-herder/golem"
+	"github.com/Webstrates/golem-herder/token"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"github.com/rs/xid"
+	"github.com/spf13/viper"
 )
 
 // List all connected minions
@@ -38,11 +42,17 @@ type Golem struct {
 	// in is chan to send messages to the golem
 	to   chan Message
 	done chan bool
+	// conn is the websocket connection of the golem - nil until upgraded
+	conn *websocket.Conn
 }
 
 // Minion represents a connected minion
 type Minion struct {
 	ID string
+	// Type is given by the minion when connecting
+	Type string
+	// conn is the websocket connection of the minion - nil until upgraded
+	conn *websocket.Conn
 	// in is a chan w messages to the minion
 	to   chan Message
 	from chan Message
@@ -62,10 +72,17 @@ type ConnectEvent struct {
 	Type  string `json:",omitempty"`
 }
 
+// BootstrapEvent is an event for changes to the bootstrap of a golem
+type BootstrapEvent struct {
+	Event string
+	golem.BootstrapStatus
+}
+
 // Output is the default information returned from a minion lambda execution
 type Output struct {
-	StdOut string
-	StdErr string `json:",omitempty"`
+	StdOut   string
+	StdErr   string   `json:",omitempty"`
+	Exceeded []string `json:",omitempty"`
 }
 
 // NewGolemNotFound creates and returns a ConnectEvent for a connected minion
@@ -75,6 +92,13 @@ func NewGolemNotFound(id string) ConnectEvent {
 		ID:    id}
 }
 
+// NewGolemBootstrap creates and returns a BootstrapEvent for the given bootstrap status
+func NewGolemBootstrap(status golem.BootstrapStatus) BootstrapEvent {
+	return BootstrapEvent{
+		Event:           "golem-bootstrap",
+		BootstrapStatus: status}
+}
+
 // NewMinionConnected creates and returns a ConnectEvent for a connected minion
 func NewMinionConnected(id string, t string) ConnectEvent {
 	return ConnectEvent{
@@ -102,6 +126,17 @@ func NewGolemConnected() ConnectEvent {
 		Event: "golem-connected"}
 }
 
+// golemMinionConnected records that a minion connected to (or disconnected from) the golem on the given webstrate
+// (see golem.MinionConnected)
+func golemMinionConnected(webstrate string, connected bool) {
+	golem.MinionConnected(webstrate, connected)
+}
+
+// touch records activity on the golem on the given webstrate so it is not reaped as idle (see golem.Touch)
+func touch(webstrate string) {
+	golem.Touch(webstrate)
+}
+
 // Spawn will spawn a new minion given
 // * env - environment (Webstrates/<env> image to use)
 // * files - a map of filename -> content of files to write
@@ -115,6 +150,12 @@ func Spawn(env, output string, files map[string][]byte) ([]byte, string, error)
 
 	log.WithField("dir", dir).Info("Created tmp dir")
 
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			log.WithError(err).WithField("dir", dir).Warn("Could not remove tmp dir")
+		}
+	}()
+
 	err = container.LoadFiles(dir, files)
 	if err != nil {
 		return nil, "", err
@@ -127,15 +168,20 @@ func Spawn(env, output string, files map[string][]byte) ([]byte, string, error)
 		dir: "/minion",
 	}
 
+	resources, err := resourcesFor(env)
+	if err != nil {
+		return nil, "", err
+	}
+
 	// return stdout iff output == "stdout" else read file (name given by output)
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
-	stdout, stderr, err := container.RunLambda(ctx, filepath.Base(dir), fmt.Sprintf("webstrates/%s", env), "latest", mounts)
+	stdout, stderr, exceeded, err := container.RunLambda(ctx, filepath.Base(dir), fmt.Sprintf("webstrates/%s", env), "latest", mounts, resources)
 	if err != nil {
 		return nil, "", err
 	}
 
-	o, err := defaultOutput(stdout, stderr)
+	o, err := defaultOutput(stdout, stderr, exceeded)
 	if err != nil {
 		log.WithError(err).Warn("Error getting default output")
 		return nil, "", nil
@@ -154,11 +200,22 @@ func Spawn(env, output string, files map[string][]byte) ([]byte, string, error)
 	return fileContent, mime.TypeByExtension(filepath.Ext(path)), nil
 }
 
-func defaultOutput(stdout, stderr []byte) ([]byte, error) {
-	o := Output{StdOut: string(stdout), StdErr: string(stderr)}
+func defaultOutput(stdout, stderr []byte, exceeded []string) ([]byte, error) {
+	o := Output{StdOut: string(stdout), StdErr: string(stderr), Exceeded: exceeded}
 	return json.Marshal(o)
 }
 
+// resourcesFor returns the resources for minions in the given env.
+// The resource class is looked up in "lambda-resources" by env falling back to the "default" class.
+func resourcesFor(env string) (*container.Resources, error) {
+	classes := viper.GetStringMapString("lambda-resources")
+	class, ok := classes[env]
+	if !ok {
+		class = classes["default"]
+	}
+	return container.ResourceClass(class)
+}
+
 // SpawnHandler is the http handler for minion spawns
 func SpawnHandler(w http.ResponseWriter, r *http.Request) {
 
@@ -207,6 +264,7 @@ func ConnectHandler(w http.ResponseWriter, r *http.Request) {
 	// create and append minion, init minion.in
 	minion := Minion{
 		ID:   id,
+		Type: r.URL.Query().Get("type"),
 		to:   make(chan Message, 100),
 		from: make(chan Message, 100),
 		done: make(chan bool, 100)}
@@ -230,6 +288,9 @@ func ConnectHandler(w http.ResponseWriter, r *http.Request) {
 		minion.done <- true
 		return
 	}
+	mutex.Lock()
+	minion.conn = conn
+	mutex.Unlock()
 
 	if golem == nil {
 		log.Warn("No golem connected yet, will look for golems for a little while")
@@ -260,6 +321,10 @@ func ConnectHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.WithField("ID", minion.ID).Info("minion assigned id and ready")
 
+	// the golem is in use while the minion is connected
+	golemMinionConnected(webstrate, true)
+	defer golemMinionConnected(webstrate, false)
+
 	go func(ws *websocket.Conn, m *Minion) {
 		for {
 			select {
@@ -277,8 +342,7 @@ func ConnectHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Let golem know that minion is here
 	if golem != nil {
-		t := r.URL.Query().Get("type")
-		connected, err := json.Marshal(NewMinionConnected(id, t))
+		connected, err := json.Marshal(NewMinionConnected(id, minion.Type))
 		if err != nil {
 			log.WithError(err).Warn("Error serialising connected message, Golem will not be alerted to minion-connect")
 		} else {
@@ -304,13 +368,22 @@ func ConnectHandler(w http.ResponseWriter, r *http.Request) {
 			minion.done <- true
 			break
 		}
+		touch(webstrate)
 		minion.from <- Message{Type: messageType, Content: messageContent}
 	}
 	log.WithField("minion", minion).Info("minion done")
 }
 
-// GolemConnectHandler is the http handler for golem connects
-func GolemConnectHandler(w http.ResponseWriter, r *http.Request) {
+// GolemConnectHandler returns the http handler for golem connects. The golem is sent events on its websocket (e.g.
+// minion-connected) and can make requests (see Request) - daemon requests must give a token valid with m.
+func GolemConnectHandler(m *token.Manager) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		golemConnect(m, w, r)
+	}
+}
+
+// golemConnect connects a golem
+func golemConnect(m *token.Manager, w http.ResponseWriter, r *http.Request) {
 
 	vars := mux.Vars(r)
 	webstrate := vars["webstrate"]
@@ -325,6 +398,15 @@ func GolemConnectHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// follow the bootstrap of the golem - the current status is sent when connected
+	bootstraps, unfollow := golem.FollowBootstrap(webstrate)
+	defer unfollow()
+	bootstrap, bootstrapped := golem.BootstrapOf(webstrate)
+
+	// let the supervisor know the golem is connected (see golem.Supervise)
+	golem.ControlConnected(webstrate, true)
+	defer golem.ControlConnected(webstrate, false)
+
 	// create golem, init golem.in
 	golem := &Golem{
 		to:   make(chan Message, 100),
@@ -349,10 +431,27 @@ func GolemConnectHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	golem.conn = conn
+
+	if bootstrapped {
+		if event, err := json.Marshal(NewGolemBootstrap(bootstrap)); err == nil {
+			golem.to <- Message{Type: websocket.TextMessage, Content: event}
+		}
+	}
+
 	// hook golem up with websocket
 	go func(ws *websocket.Conn, g *Golem, webstrate string) {
 		for {
 			select {
+			case status := <-bootstraps:
+				event, err := json.Marshal(NewGolemBootstrap(status))
+				if err != nil {
+					log.WithError(err).Warn("Error marshaling golem-bootstrap event")
+					continue
+				}
+				if err := ws.WriteMessage(websocket.TextMessage, event); err != nil {
+					log.WithError(err).Warn("Could not write message to Golem")
+				}
 			case msg := <-g.to:
 				if err := ws.WriteMessage(msg.Type, msg.Content); err != nil {
 					log.WithError(err).Warn("Could not write message to Golem")
@@ -377,7 +476,11 @@ func GolemConnectHandler(w http.ResponseWriter, r *http.Request) {
 			golem.done <- true
 			break
 		}
-		// TODO do something with golem message
+		touch(webstrate)
+		if request, ok := parseRequest(messageContent); ok {
+			go handleRequest(m, golem, webstrate, request)
+			continue
+		}
 		log.WithField("type", messageType).WithField("content", messageContent).Info("Read message from golem")
 	}
 
@@ -464,6 +567,7 @@ func GolemMinionConnectHandler(w http.ResponseWriter, r *http.Request) {
 			minion.to <- Message{Type: websocket.TextMessage, Content: disconnected}
 			break
 		}
+		touch(webstrate)
 		minion.to <- Message{Type: messageType, Content: messageContent}
 	}
 	log.WithField("webstrate", webstrate).WithField("minion", minionID).Info("golem/minion session done")
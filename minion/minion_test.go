@@ -0,0 +1,43 @@
+package minion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/Webstrates/golem-herder/container"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestSpawn(t *testing.T) {
+	fake := container.NewFakeRuntime()
+	fake.Programs["webstrates/python:latest"] = func(ctx context.Context, c *docker.Container, stdin io.Reader, stdout, stderr io.Writer) int {
+		fmt.Fprint(stdout, "hello")
+		fmt.Fprint(stderr, "warning")
+		return 0
+	}
+	container.SetRuntime(fake)
+
+	output, mimeType, err := Spawn("python", "", map[string][]byte{"main.py": []byte("print('hello')")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mimeType != "application/json" {
+		t.Errorf("Unexpected mime type: %s", mimeType)
+	}
+	o := Output{}
+	if err := json.Unmarshal(output, &o); err != nil {
+		t.Fatal(err)
+	}
+	if o.StdOut != "hello" || o.StdErr != "warning" {
+		t.Errorf("Unexpected output: %+v", o)
+	}
+
+	// Lambdas are removed once done
+	client, _ := container.GetRuntime()
+	if lambdas, _ := container.List(client, isLambda, true); len(lambdas) != 0 {
+		t.Errorf("Lambda containers left: %+v", lambdas)
+	}
+}
@@ -0,0 +1,78 @@
+package minion
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Webstrates/golem-herder/container"
+	docker "github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReconcileReport describes the lambda leftovers which were cleaned up when reconciling.
+type ReconcileReport struct {
+	// Containers are the removed lambda containers
+	Containers []string
+	// Dirs are the removed lambda directories
+	Dirs []string
+}
+
+// isLambda tells whether the container was run as a lambda - that is labelled as a lambda or (if run by a herder
+// which did not label lambdas) named minion-* without the labels of daemons.
+func isLambda(c *docker.APIContainers) bool {
+	if _, ok := c.Labels[container.LabelLambda]; ok {
+		return true
+	}
+	if _, ok := c.Labels["tokenid"]; ok {
+		return false
+	}
+	if _, ok := c.Labels["subject"]; ok {
+		return false
+	}
+	for _, name := range c.Names {
+		if strings.HasPrefix(name, "/minion-") {
+			return true
+		}
+	}
+	return false
+}
+
+// Reconcile removes the lambda containers and /tmp/minion-* directories left behind by a previous herder.
+// Must be called before any lambdas are spawned.
+func Reconcile() (*ReconcileReport, error) {
+	client, err := container.GetRuntime()
+	if err != nil {
+		return nil, err
+	}
+
+	lambdas, err := container.List(client, isLambda, true)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReconcileReport{Containers: []string{}, Dirs: []string{}}
+	for _, lambda := range lambdas {
+		log.WithField("container", lambda.ID).WithField("names", lambda.Names).Info("Removing leftover lambda container")
+		err := client.RemoveContainer(docker.RemoveContainerOptions{ID: lambda.ID, Force: true, RemoveVolumes: true})
+		if err != nil {
+			log.WithError(err).WithField("container", lambda.ID).Warn("Could not remove lambda container")
+			continue
+		}
+		report.Containers = append(report.Containers, strings.TrimPrefix(lambda.Names[0], "/"))
+	}
+
+	dirs, err := filepath.Glob(filepath.Join("/tmp", "minion-*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		log.WithField("dir", dir).Info("Removing leftover lambda dir")
+		if err := os.RemoveAll(dir); err != nil {
+			log.WithError(err).WithField("dir", dir).Warn("Could not remove lambda dir")
+			continue
+		}
+		report.Dirs = append(report.Dirs, dir)
+	}
+	return report, nil
+}
@@ -0,0 +1,27 @@
+package minion
+
+import (
+	"testing"
+
+	"github.com/Webstrates/golem-herder/container"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestIsLambda(t *testing.T) {
+	for _, test := range []struct {
+		c      docker.APIContainers
+		lambda bool
+	}{
+		{docker.APIContainers{Names: []string{"/minion-123"}, Labels: map[string]string{container.LabelLambda: "true"}}, true},
+		{docker.APIContainers{Names: []string{"/other"}, Labels: map[string]string{container.LabelLambda: "true"}}, true},
+		// Lambdas run by herders which did not label them
+		{docker.APIContainers{Names: []string{"/minion-123"}}, true},
+		// A daemon named minion-*
+		{docker.APIContainers{Names: []string{"/minion-x"}, Labels: map[string]string{"tokenid": "1", "subject": "me@example.com"}}, false},
+		{docker.APIContainers{Names: []string{"/golem-ws"}, Labels: map[string]string{"webstrate": "ws"}}, false},
+	} {
+		if isLambda(&test.c) != test.lambda {
+			t.Errorf("%v with labels %v is a lambda: %v", test.c.Names, test.c.Labels, !test.lambda)
+		}
+	}
+}
@@ -145,17 +145,20 @@ func creditsFromQueryParam(r *http.Request) (int, bool) {
 	return 0, false
 }
 
+// ValidateRequest validates the token given in the Authorization header or the token query param of the request.
+func (tm *Manager) ValidateRequest(r *http.Request) (*jwt.Token, error) {
+	token, ok := tokenFromHeader(r)
+	if !ok {
+		token, _ = tokenFromQueryParam(r)
+	}
+	return tm.Validate(token)
+}
+
 // ValidatedHandler will return a http handler which validates a request prior to invoking the given handler.
 func ValidatedHandler(m *Manager, handler func(w http.ResponseWriter, r *http.Request, token *jwt.Token)) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		token, ok := tokenFromHeader(r)
-		if !ok {
-			token, _ = tokenFromQueryParam(r)
-		} else {
-		}
-
-		t, err := m.Validate(token)
+		t, err := m.ValidateRequest(r)
 		if err != nil {
 			log.WithError(err).Warn("Unauthorized")
 			http.Error(w, err.Error(), 401 /* Unauthorized */)
@@ -175,6 +178,18 @@ type TokenReply struct {
 	Token   string `json:"token"`
 	Email   string `json:"email"`
 	Credits int    `json:"credits"`
+	// Webstrates are the patterns of the webstrates whose golems the token can reset and kill
+	Webstrates []string `json:"webstrates,omitempty"`
+}
+
+// Claims returns the claims of a token with the given credits, scoped to the golems of the webstrates matching
+// the given patterns.
+func Claims(credits int, webstrates []string) jwt.MapClaims {
+	claims := jwt.MapClaims{"crd": credits}
+	if len(webstrates) > 0 {
+		claims["wst"] = webstrates
+	}
+	return claims
 }
 
 // GenerateHandler will return a http handler to generate tokens.
@@ -202,10 +217,12 @@ func GenerateHandler(m *Manager, tokenPassword string) func(w http.ResponseWrite
 			credits = 3e4
 		}
 
-		token, _ := m.Generate(email, jwt.MapClaims{"crd": credits})
-		log.WithField("email", email).WithField("credits", credits).Info("Generated token")
+		webstrates := r.URL.Query()["webstrate"]
+
+		token, _ := m.Generate(email, Claims(credits, webstrates))
+		log.WithField("email", email).WithField("credits", credits).WithField("webstrates", webstrates).Info("Generated token")
 
-		reply := &TokenReply{token, email, credits}
+		reply := &TokenReply{token, email, credits, webstrates}
 		s, err := json.Marshal(reply)
 		if err != nil {
 			log.WithError(err).Warn("Failed to create json response")
@@ -240,7 +257,7 @@ func InspectHandler(m *Manager) func(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		reply := &TokenReply{token, email, credits}
+		reply := &TokenReply{Token: token, Email: email, Credits: credits}
 		s, err := json.Marshal(reply)
 		if err != nil {
 			log.WithError(err).Warn("Failed to create json response")